@@ -0,0 +1,134 @@
+package auditapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+type AuditHandler struct {
+	AuditService audit.AuditService
+	AuthHandler  *auth.Handler
+	Logger       *slog.Logger
+}
+
+// Entries lists the audit log newest first, filtered by the from, to, actor, target and action query parameters
+func (h *AuditHandler) Entries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET")
+		return
+	}
+
+	claims, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	query, err := parseQuery(r.URL.Query())
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			With("values", r.URL.Query()).
+			Error("request contains invalid audit parameters")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	h.Logger.
+		With("userID", claims.Subject).
+		With("query", utils.ToJSON(query)).
+		Info("get audit entries")
+
+	entries, err := h.AuditService.GetEntries(r.Context(), query)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			Error("failed to get audit entries")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	type EntriesResponse struct {
+		Entries []*audit.Entry `json:"entries"`
+	}
+
+	data, _ := json.MarshalIndent(&EntriesResponse{Entries: entries}, "", "\t")
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// authorize writes the problem and returns false unless the caller is an administrator
+func (h *AuditHandler) authorize(w http.ResponseWriter, r *http.Request) (*user.Claims, bool) {
+	claims, err := h.AuthHandler.ValidateRequest(r)
+	if err != nil {
+		api.WriteError(w, r, err)
+		return nil, false
+	}
+
+	if !claims.IsAdmin {
+		h.Logger.
+			With("userID", claims.Subject).
+			With("error", "user is not administrator").
+			Error("unauthenticated request")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, "only administrators may read the audit log"))
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// parseQuery reads the filters of the audit log, from and to are RFC 3339 times
+func parseQuery(values url.Values) (*audit.Query, error) {
+	query := &audit.Query{
+		ActorID:      values.Get("actor"),
+		TargetUserID: values.Get("target"),
+		Action:       values.Get("action"),
+	}
+
+	for name, t := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		v := values.Get(name)
+		if v == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%w - '%s' must be an RFC 3339 time", utils.ValidationErr, name)
+		}
+		*t = parsed.UTC()
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("%w - 'limit' must be a positive integer", utils.ValidationErr)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
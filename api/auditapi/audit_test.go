@@ -0,0 +1,133 @@
+package auditapi
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/stretchr/testify/assert"
+)
+
+var testAdmin = &user.User{ID: "admin", IsAdmin: true}
+
+func newTestHandler(t *testing.T, repo audit.Repository) *AuditHandler {
+	svc, err := audit.NewService(&audit.Resources{Repo: repo})
+	assert.NoError(t, err)
+
+	return &AuditHandler{
+		AuditService: svc,
+		Logger:       slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})),
+		AuthHandler:  &auth.Handler{JWTSecret: []byte("1234"), Expiry: time.Minute},
+	}
+}
+
+func newTestRequest(t *testing.T, h *AuditHandler, target string, caller *user.User) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if caller != nil {
+		token, err := h.AuthHandler.SignClaims(caller)
+		assert.NoError(t, err)
+		r.Header.Set(auth.AUTH_HEADER, "Bearer "+token)
+	}
+
+	return r
+}
+
+func TestEntriesAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *user.User
+		status int
+	}{
+		{name: "anonymous", caller: nil, status: http.StatusUnauthorized},
+		{name: "user", caller: &user.User{ID: "1234"}, status: http.StatusForbidden},
+		{name: "admin", caller: testAdmin, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandler(t, audit.NewMemoryRepo())
+			w := httptest.NewRecorder()
+			h.Entries(w, newTestRequest(t, h, "/audit", tc.caller))
+
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
+
+func TestEntriesFilters(t *testing.T) {
+	repo := audit.NewMemoryRepo()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, entry := range []*audit.Entry{
+		{ID: "1", ActorID: "admin", Action: audit.ActionUserCreated, TargetUserID: "1234"},
+		{ID: "2", ActorID: "1234", Action: audit.ActionUserUpdated, TargetUserID: "1234"},
+		{ID: "3", ActorID: "admin", Action: audit.ActionUsersSearched},
+	} {
+		entry.Sequence = int64(i + 1)
+		entry.Time = start.Add(time.Duration(i) * time.Hour)
+		assert.NoError(t, repo.PutEntry(context.Background(), entry))
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		ids   []string
+	}{
+		{name: "all", query: "", ids: []string{"3", "2", "1"}},
+		{name: "actor", query: "?actor=admin", ids: []string{"3", "1"}},
+		{name: "target", query: "?target=1234", ids: []string{"2", "1"}},
+		{name: "action", query: "?action=USERS_SEARCHED", ids: []string{"3"}},
+		{name: "time range", query: "?from=2024-01-01T01:00:00Z&to=2024-01-01T02:00:00Z", ids: []string{"2"}},
+		{name: "limit", query: "?limit=1", ids: []string{"3"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandler(t, repo)
+			w := httptest.NewRecorder()
+			h.Entries(w, newTestRequest(t, h, "/audit"+tc.query, testAdmin))
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var resp struct {
+				Entries []*audit.Entry `json:"entries"`
+			}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+			ids := []string{}
+			for _, entry := range resp.Entries {
+				ids = append(ids, entry.ID)
+			}
+			assert.Equal(t, tc.ids, ids)
+		})
+	}
+}
+
+func TestEntriesInvalidQuery(t *testing.T) {
+	for _, query := range []string{
+		"?from=yesterday",
+		"?to=2024-01-01",
+		"?limit=0",
+		"?limit=5000",
+		"?from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z",
+	} {
+		t.Run(query, func(t *testing.T) {
+			h := newTestHandler(t, audit.NewMemoryRepo())
+			w := httptest.NewRecorder()
+			h.Entries(w, newTestRequest(t, h, "/audit"+query, testAdmin))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var problem api.Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, api.CodeValidationFailed, problem.Code)
+		})
+	}
+}
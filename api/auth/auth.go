@@ -1,13 +1,22 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackmcguire1/UserService/dom/session"
 	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
 )
 
 const (
@@ -22,24 +31,73 @@ var (
 type Handler struct {
 	JWTSecret []byte
 	Expiry    time.Duration
+
+	// RefreshExpiry is the absolute lifetime of a session and its refresh tokens
+	RefreshExpiry time.Duration
+	// Sessions is consulted on every validation when set,
+	// access tokens must then carry the ID (jti) of an active session
+	Sessions session.Repository
+	// Keys switches signing from HS256 with JWTSecret to the asymmetric keys of the ring,
+	// tokens then carry a 'kid' header so they can be verified offline via the JWKS endpoint
+	Keys *KeyRing
+}
+
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    string
 }
 
 func (handler *Handler) SignClaims(usr *user.User) (string, error) {
+	return handler.signSessionClaims(usr, "")
+}
+
+func (handler *Handler) signSessionClaims(usr *user.User, sessionID string) (string, error) {
 	claims := &user.Claims{
 		IsAdmin: usr.IsAdmin,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:      sessionID,
 			Subject: usr.ID,
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(handler.Expiry)),
 		},
 	}
 
+	if handler.Keys != nil {
+		key := handler.Keys.SigningKey()
+
+		token := jwt.NewWithClaims(key.Method, claims)
+		token.Header["kid"] = key.ID
+		return token.SignedString(key.Private)
+	}
+
 	// Declare the token with the algorithm used for signing, and the claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(handler.JWTSecret)
 }
 
-func (handler *Handler) ValidateJWT(token string) (usrClaim *user.Claims, err error) {
+func (handler *Handler) verificationKey(token *jwt.Token) (any, error) {
+	if handler.Keys == nil {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("%w - unexpected signing method %s", jwt.ErrSignatureInvalid, token.Method.Alg())
+		}
+		return handler.JWTSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, err := handler.Keys.VerificationKey(kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", jwt.ErrSignatureInvalid, err)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("%w - unexpected signing method %s for kid:%q", jwt.ErrSignatureInvalid, token.Method.Alg(), kid)
+	}
+
+	return key.Public, nil
+}
+
+func (handler *Handler) ValidateJWT(ctx context.Context, token string) (usrClaim *user.Claims, err error) {
 	// Parse the JWT string and store the result in `claims`.
 	// Note that we are passing the key in this method as well. This method will return an error
 	// if the token is invalid (if it has expired according to the expiry time we set on sign in),
@@ -47,30 +105,201 @@ func (handler *Handler) ValidateJWT(token string) (usrClaim *user.Claims, err er
 
 	usrClaim = &user.Claims{}
 
-	tkn, err := jwt.ParseWithClaims(token, usrClaim, func(token *jwt.Token) (any, error) {
-		return handler.JWTSecret, nil
-	})
+	// expired, not yet valid, unverifiable and unparsable tokens are all invalid credentials
+	tkn, err := jwt.ParseWithClaims(token, usrClaim, handler.verificationKey)
 	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			return nil, UnAuthorizedErr
-		}
-		return nil, InvalidRequestErr
+		return nil, fmt.Errorf("%w - %v", UnAuthorizedErr, err)
 	}
 
 	if !tkn.Valid {
 		return nil, UnAuthorizedErr
 	}
 
+	if handler.Sessions != nil {
+		err = handler.validateSession(ctx, usrClaim)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return
 }
 
+func (handler *Handler) validateSession(ctx context.Context, claims *user.Claims) error {
+	if claims.ID == "" {
+		return UnAuthorizedErr
+	}
+
+	sess, err := handler.Sessions.GetSession(ctx, claims.ID)
+	if err != nil {
+		if errors.Is(err, utils.ErrNotFound) {
+			return UnAuthorizedErr
+		}
+		return err
+	}
+
+	if !sess.Active(time.Now().UTC()) || sess.UserID != claims.Subject {
+		return UnAuthorizedErr
+	}
+
+	return nil
+}
+
+// StartSession creates a new session for the user and issues an access and refresh token pair for it
+func (handler *Handler) StartSession(ctx context.Context, usr *user.User) (*Tokens, error) {
+	if handler.Sessions == nil {
+		accessToken, err := handler.SignClaims(usr)
+		if err != nil {
+			return nil, err
+		}
+		return &Tokens{AccessToken: accessToken}, nil
+	}
+
+	now := time.Now().UTC()
+
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sess := &session.Session{
+		ID:               uuid.NewString(),
+		UserID:           usr.ID,
+		RefreshTokenHash: hash,
+		CreatedAt:        now,
+		RefreshedAt:      now,
+		ExpiresAt:        now.Add(handler.RefreshExpiry),
+	}
+
+	err = handler.Sessions.PutSession(ctx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save session err:%w", err)
+	}
+
+	accessToken, err := handler.signSessionClaims(usr, sess.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: sess.ID + "." + refreshToken,
+		SessionID:    sess.ID,
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new token pair, rotating the refresh token.
+// Presenting an already rotated refresh token revokes the whole session as it is likely to have been stolen
+func (handler *Handler) RefreshSession(ctx context.Context, refreshToken string, getUser func(context.Context, string) (*user.User, error)) (*Tokens, error) {
+	if handler.Sessions == nil {
+		return nil, session.NotImplementedErr
+	}
+
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, InvalidRequestErr
+	}
+
+	sess, err := handler.Sessions.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, utils.ErrNotFound) {
+			return nil, UnAuthorizedErr
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if !sess.Active(now) {
+		return nil, UnAuthorizedErr
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], sess.RefreshTokenHash) != 1 {
+		err = handler.Sessions.RevokeSession(ctx, sess.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke session on refresh token reuse err:%w", err)
+		}
+		return nil, UnAuthorizedErr
+	}
+
+	usr, err := getUser(ctx, sess.UserID)
+	if err != nil {
+		if errors.Is(err, utils.ErrNotFound) {
+			return nil, UnAuthorizedErr
+		}
+		return nil, err
+	}
+
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// the token is only rotated while the session still holds the presented one, of concurrent refreshes
+	// with the same token one wins and the others are rejected like a revoked or expired session
+	err = handler.Sessions.RotateRefreshToken(ctx, sess.ID, sess.RefreshTokenHash, newHash, now)
+	if err != nil {
+		if errors.Is(err, utils.ErrNotFound) {
+			return nil, UnAuthorizedErr
+		}
+		return nil, fmt.Errorf("failed to rotate refresh token err:%w", err)
+	}
+	sess.RefreshTokenHash = newHash
+	sess.RefreshedAt = now
+
+	accessToken, err := handler.signSessionClaims(usr, sess.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: sess.ID + "." + newToken,
+		SessionID:    sess.ID,
+	}, nil
+}
+
+func (handler *Handler) RevokeSession(ctx context.Context, sessionID string) error {
+	if handler.Sessions == nil {
+		return session.NotImplementedErr
+	}
+
+	return handler.Sessions.RevokeSession(ctx, sessionID)
+}
+
+// RevokeUserSessions force-logs out every session belonging to the user
+func (handler *Handler) RevokeUserSessions(ctx context.Context, userID string) error {
+	if handler.Sessions == nil {
+		return session.NotImplementedErr
+	}
+
+	return handler.Sessions.RevokeUserSessions(ctx, userID)
+}
+
+func newRefreshToken() (token string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("failed to generate refresh token err:%w", err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+
+	return token, sum[:], nil
+}
+
+// ValidateRequest validates the bearer token of the request, a missing header is UnAuthorizedErr
+// while a header which is not 'Bearer <token>' is InvalidRequestErr
 func (handler *Handler) ValidateRequest(r *http.Request) (*user.Claims, error) {
 	authHeader := r.Header.Get(AUTH_HEADER)
+	if authHeader == "" {
+		return nil, UnAuthorizedErr
+	}
 
 	items := strings.Split(authHeader, "Bearer ")
 	if len(items) != 2 {
 		return nil, InvalidRequestErr
 	}
 
-	return handler.ValidateJWT(items[1])
+	return handler.ValidateJWT(r.Context(), items[1])
 }
@@ -1,9 +1,14 @@
 package auth
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jackmcguire1/UserService/dom/session"
 	"github.com/jackmcguire1/UserService/dom/user"
 	"github.com/stretchr/testify/assert"
 )
@@ -42,7 +47,175 @@ func TestSignClaimsWithVerification(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
-	claims, err := h.ValidateJWT(token)
+	claims, err := h.ValidateJWT(context.Background(), token)
 	assert.NoError(t, err)
 	assert.EqualValues(t, "1234", claims.Subject)
 }
+
+func TestValidateJWTInvalidCredentials(t *testing.T) {
+	h := &Handler{JWTSecret: testToken, Expiry: -time.Minute}
+	expired, err := h.SignClaims(&user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	_, err = h.ValidateJWT(context.Background(), expired)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+
+	key, err := GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+	unknown, err := (&Handler{Expiry: time.Minute, Keys: NewKeyRing(key)}).SignClaims(&user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	other, err := GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+	_, err = (&Handler{Expiry: time.Minute, Keys: NewKeyRing(other)}).ValidateJWT(context.Background(), unknown)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+
+	_, err = h.ValidateJWT(context.Background(), "not-a-jwt")
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+}
+
+func TestValidateRequest(t *testing.T) {
+	h := &Handler{JWTSecret: testToken, Expiry: time.Minute}
+	token, err := h.SignClaims(&user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = h.ValidateRequest(r)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+
+	r.Header.Set(AUTH_HEADER, "Basic dXNlcjpwYXNz")
+	_, err = h.ValidateRequest(r)
+	assert.ErrorIs(t, err, InvalidRequestErr)
+
+	r.Header.Set(AUTH_HEADER, "Bearer "+token)
+	claims, err := h.ValidateRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", claims.Subject)
+}
+
+func newSessionHandler() *Handler {
+	return &Handler{
+		JWTSecret:     testToken,
+		Expiry:        time.Minute,
+		RefreshExpiry: time.Hour,
+		Sessions:      session.NewMemoryRepo(),
+	}
+}
+
+func TestStartSession(t *testing.T) {
+	h := newSessionHandler()
+
+	tokens, err := h.StartSession(context.Background(), &user.User{ID: "1234"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	claims, err := h.ValidateJWT(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", claims.Subject)
+	assert.Equal(t, tokens.SessionID, claims.ID)
+
+	// tokens without a session are rejected once a session store is configured
+	token, err := h.SignClaims(&user.User{ID: "1234"})
+	assert.NoError(t, err)
+	_, err = h.ValidateJWT(context.Background(), token)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+}
+
+func TestRevokeSession(t *testing.T) {
+	h := newSessionHandler()
+
+	tokens, err := h.StartSession(context.Background(), &user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.RevokeSession(context.Background(), tokens.SessionID))
+
+	_, err = h.ValidateJWT(context.Background(), tokens.AccessToken)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+
+	_, err = h.RefreshSession(context.Background(), tokens.RefreshToken, func(ctx context.Context, id string) (*user.User, error) {
+		return &user.User{ID: id}, nil
+	})
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	h := newSessionHandler()
+
+	first, err := h.StartSession(context.Background(), &user.User{ID: "1234"})
+	assert.NoError(t, err)
+	second, err := h.StartSession(context.Background(), &user.User{ID: "1234"})
+	assert.NoError(t, err)
+	other, err := h.StartSession(context.Background(), &user.User{ID: "5678"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.RevokeUserSessions(context.Background(), "1234"))
+
+	_, err = h.ValidateJWT(context.Background(), first.AccessToken)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+	_, err = h.ValidateJWT(context.Background(), second.AccessToken)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+	_, err = h.ValidateJWT(context.Background(), other.AccessToken)
+	assert.NoError(t, err)
+}
+
+func TestRefreshSession(t *testing.T) {
+	h := newSessionHandler()
+	getUser := func(ctx context.Context, id string) (*user.User, error) {
+		return &user.User{ID: id, IsAdmin: true}, nil
+	}
+
+	tokens, err := h.StartSession(context.Background(), &user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	refreshed, err := h.RefreshSession(context.Background(), tokens.RefreshToken, getUser)
+	assert.NoError(t, err)
+	assert.Equal(t, tokens.SessionID, refreshed.SessionID)
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	claims, err := h.ValidateJWT(context.Background(), refreshed.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, claims.IsAdmin)
+
+	// reusing a rotated refresh token revokes the session
+	_, err = h.RefreshSession(context.Background(), tokens.RefreshToken, getUser)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+
+	_, err = h.ValidateJWT(context.Background(), refreshed.AccessToken)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+
+	_, err = h.RefreshSession(context.Background(), "malformed", getUser)
+	assert.ErrorIs(t, err, InvalidRequestErr)
+}
+
+func TestConcurrentRefreshSession(t *testing.T) {
+	h := newSessionHandler()
+	getUser := func(ctx context.Context, id string) (*user.User, error) {
+		return &user.User{ID: id}, nil
+	}
+
+	tokens, err := h.StartSession(context.Background(), &user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := h.RefreshSession(context.Background(), tokens.RefreshToken, getUser)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	refreshed := 0
+	for err := range errs {
+		if err == nil {
+			refreshed++
+			continue
+		}
+		assert.ErrorIs(t, err, UnAuthorizedErr)
+	}
+	assert.Equal(t, 1, refreshed, "a refresh token is exchanged for one token pair")
+}
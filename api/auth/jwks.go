@@ -0,0 +1,20 @@
+package auth
+
+// KeySet returns the public keys of the key ring so other services can verify our tokens offline,
+// the set is empty while tokens are signed with JWTSecret
+func (handler *Handler) KeySet() (*JWKSet, error) {
+	set := &JWKSet{Keys: []*JWK{}}
+	if handler.Keys == nil {
+		return set, nil
+	}
+
+	for _, key := range handler.Keys.Keys() {
+		jwk, err := key.JWK()
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	UnknownKeyErr         = fmt.Errorf("unknown signing key")
+	UnsupportedKeyTypeErr = fmt.Errorf("unsupported signing key type")
+)
+
+// SigningKey is an asymmetric key pair identified by its 'kid' header
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+
+	// NotAfter is set once the key has been retired,
+	// the key then only verifies tokens until this time
+	NotAfter time.Time
+}
+
+// Retired reports whether the key can no longer verify tokens
+func (key *SigningKey) Retired(now time.Time) bool {
+	return !key.NotAfter.IsZero() && !now.Before(key.NotAfter)
+}
+
+// GenerateSigningKey creates a new key pair for RS256, ES256 or EdDSA
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w - %s", UnsupportedKeyTypeErr, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key err:%w", alg, err)
+	}
+
+	return NewSigningKey("", private)
+}
+
+// ParseSigningKeyPEM loads a PKCS#8 (or PKCS#1 / SEC 1) encoded private key,
+// the signing method is derived from the key type
+func ParseSigningKeyPEM(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w - no PEM block found", UnsupportedKeyTypeErr)
+	}
+
+	var (
+		private any
+		err     error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key err:%w", err)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w - %T", UnsupportedKeyTypeErr, private)
+	}
+
+	return NewSigningKey(kid, signer)
+}
+
+// NewSigningKey wraps a private key, when kid is empty the RFC 7638 thumbprint of the public key is used
+func NewSigningKey(kid string, private crypto.Signer) (*SigningKey, error) {
+	var method jwt.SigningMethod
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w - only P-256 ECDSA keys are supported", UnsupportedKeyTypeErr)
+		}
+		method = jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%w - %T", UnsupportedKeyTypeErr, private)
+	}
+
+	key := &SigningKey{
+		ID:      kid,
+		Method:  method,
+		Private: private,
+		Public:  private.Public(),
+	}
+
+	if key.ID == "" {
+		jwk, err := key.JWK()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = jwk.Thumbprint()
+	}
+
+	return key, nil
+}
+
+// KeyRing holds the active signing key and any retired keys that are still valid for verification
+type KeyRing struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+func NewKeyRing(active *SigningKey) *KeyRing {
+	return &KeyRing{
+		active: active,
+		keys:   map[string]*SigningKey{active.ID: active},
+	}
+}
+
+// AddRetired registers a verification only key which expires at notAfter
+func (ring *KeyRing) AddRetired(key *SigningKey, notAfter time.Time) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	if key.ID == ring.active.ID {
+		return
+	}
+
+	key.NotAfter = notAfter
+	ring.keys[key.ID] = key
+}
+
+// Rotate makes next the active signing key,
+// the previous key keeps verifying tokens for the grace period which should be at least the token expiry
+func (ring *KeyRing) Rotate(next *SigningKey, grace time.Duration) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	ring.active.NotAfter = now.Add(grace)
+	ring.active = next
+	ring.keys[next.ID] = next
+
+	for kid, key := range ring.keys {
+		if key.Retired(now) {
+			delete(ring.keys, kid)
+		}
+	}
+}
+
+func (ring *KeyRing) SigningKey() *SigningKey {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	return ring.active
+}
+
+// VerificationKey returns the key with the given 'kid' as long as it has not been retired for too long
+func (ring *KeyRing) VerificationKey(kid string) (*SigningKey, error) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	key, ok := ring.keys[kid]
+	if !ok || key.Retired(time.Now().UTC()) {
+		return nil, fmt.Errorf("%w - kid:%q", UnknownKeyErr, kid)
+	}
+
+	return key, nil
+}
+
+// Keys returns every key that can currently verify tokens, ordered by 'kid'
+func (ring *KeyRing) Keys() []*SigningKey {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	now := time.Now().UTC()
+
+	keys := []*SigningKey{}
+	for _, key := range ring.keys {
+		if !key.Retired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// JWK returns the public half of the key in JSON Web Key format
+func (key *SigningKey) JWK() (*JWK, error) {
+	jwk := &JWK{
+		KeyID:     key.ID,
+		Use:       "sig",
+		Algorithm: key.Method.Alg(),
+	}
+
+	enc := base64.RawURLEncoding
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = enc.EncodeToString(pub.N.Bytes())
+		jwk.E = enc.EncodeToString(bigEndian(pub.E))
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = enc.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = enc.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = enc.EncodeToString(pub)
+	default:
+		return nil, fmt.Errorf("%w - %T", UnsupportedKeyTypeErr, key.Public)
+	}
+
+	return jwk, nil
+}
+
+// Thumbprint is the RFC 7638 SHA-256 thumbprint of the key
+func (jwk *JWK) Thumbprint() string {
+	var members string
+	switch jwk.KeyType {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.KeyType, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Curve, jwk.KeyType, jwk.X, jwk.Y)
+	default:
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Curve, jwk.KeyType, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func bigEndian(i int) []byte {
+	b := []byte{}
+	for ; i > 0; i >>= 8 {
+		b = append([]byte{byte(i)}, b...)
+	}
+
+	return b
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsymmetricSigning(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateSigningKey(alg)
+			assert.NoError(t, err)
+
+			h := &Handler{Expiry: time.Minute, Keys: NewKeyRing(key)}
+			token, err := h.SignClaims(&user.User{ID: "1234"})
+			assert.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &user.Claims{})
+			assert.NoError(t, err)
+			assert.Equal(t, alg, parsed.Method.Alg())
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+
+			claims, err := h.ValidateJWT(context.Background(), token)
+			assert.NoError(t, err)
+			assert.Equal(t, "1234", claims.Subject)
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	first, err := GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+	second, err := GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+
+	h := &Handler{Expiry: time.Minute, Keys: NewKeyRing(first)}
+	oldToken, err := h.SignClaims(&user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	h.Keys.Rotate(second, time.Minute)
+
+	newToken, err := h.SignClaims(&user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	_, err = h.ValidateJWT(context.Background(), oldToken)
+	assert.NoError(t, err, "retired keys verify until the grace period ends")
+	_, err = h.ValidateJWT(context.Background(), newToken)
+	assert.NoError(t, err)
+	assert.Len(t, h.Keys.Keys(), 2)
+
+	// once the grace period has passed the retired key is no longer trusted
+	first.NotAfter = time.Now().UTC().Add(-time.Second)
+	_, err = h.ValidateJWT(context.Background(), oldToken)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+	assert.Len(t, h.Keys.Keys(), 1)
+}
+
+func TestKeyRingRejectsSymmetricTokens(t *testing.T) {
+	key, err := GenerateSigningKey("RS256")
+	assert.NoError(t, err)
+
+	hmacToken, err := (&Handler{JWTSecret: testToken, Expiry: time.Minute}).SignClaims(&user.User{ID: "1234"})
+	assert.NoError(t, err)
+
+	h := &Handler{JWTSecret: testToken, Expiry: time.Minute, Keys: NewKeyRing(key)}
+	_, err = h.ValidateJWT(context.Background(), hmacToken)
+	assert.ErrorIs(t, err, UnAuthorizedErr)
+}
+
+func TestParseSigningKeyPEM(t *testing.T) {
+	key, err := GenerateSigningKey("EdDSA")
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	assert.NoError(t, err)
+
+	parsed, err := ParseSigningKeyPEM("", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, parsed.ID, "kid defaults to the key thumbprint")
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+
+	_, err = ParseSigningKeyPEM("", []byte("not a key"))
+	assert.ErrorIs(t, err, UnsupportedKeyTypeErr)
+}
+
+func TestKeySet(t *testing.T) {
+	rsaKey, err := GenerateSigningKey("RS256")
+	assert.NoError(t, err)
+	ecKey, err := GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+
+	h := &Handler{Keys: NewKeyRing(rsaKey)}
+	h.Keys.Rotate(ecKey, time.Minute)
+
+	set, err := h.KeySet()
+	assert.NoError(t, err)
+	assert.Len(t, set.Keys, 2)
+
+	byKid := map[string]*JWK{}
+	for _, jwk := range set.Keys {
+		byKid[jwk.KeyID] = jwk
+	}
+
+	assert.Equal(t, "RSA", byKid[rsaKey.ID].KeyType)
+	assert.Equal(t, "AQAB", byKid[rsaKey.ID].E)
+	assert.Equal(t, "EC", byKid[ecKey.ID].KeyType)
+	assert.Equal(t, "P-256", byKid[ecKey.ID].Curve)
+	assert.Equal(t, "ES256", byKid[ecKey.ID].Algorithm)
+}
@@ -1,5 +1,124 @@
 package api
 
-type HTTPError struct {
-	Error string `json:"error"`
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+	// PROBLEM_TYPE_PREFIX prefixes the problem code to form the problem type URI
+	PROBLEM_TYPE_PREFIX = "urn:userservice:problem:"
+)
+
+// Problem codes are stable and safe for clients to branch on, unlike titles and details
+const (
+	CodeValidationFailed     = "validation_failed"
+	CodeMalformedRequest     = "malformed_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeAlreadyExists        = "already_exists"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePatchFailed          = "patch_failed"
+	CodeEditConflict         = "edit_conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeInternal             = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details body, served as application/problem+json
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	Code      string `json:"code"`
+	RequestID string `json:"requestId,omitempty"`
+	// Errors lists the invalid fields of a validation problem
+	Errors []*utils.FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem returns the problem for the request, identified by its path and request ID
+func NewProblem(r *http.Request, status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:      PROBLEM_TYPE_PREFIX + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: utils.RequestID(r.Context()),
+	}
+}
+
+// ProblemFromError maps the well known errors to their problem,
+// the details of unexpected errors are not disclosed, the request ID correlates them with the logs
+func ProblemFromError(r *http.Request, err error) *Problem {
+	switch {
+	case errors.Is(err, utils.ValidationErr):
+		problem := NewProblem(r, http.StatusBadRequest, CodeValidationFailed, err.Error())
+
+		var fields utils.ValidationErrors
+		if errors.As(err, &fields) {
+			problem.Errors = fields
+		}
+
+		return problem
+	case errors.Is(err, utils.ErrNotFound):
+		return NewProblem(r, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, utils.AlreadyExists):
+		return NewProblem(r, http.StatusConflict, CodeAlreadyExists, err.Error())
+	case errors.Is(err, utils.PreconditionFailed):
+		return NewProblem(r, http.StatusPreconditionFailed, CodePreconditionFailed, err.Error())
+	case errors.Is(err, utils.VersionConflict):
+		return NewProblem(r, http.StatusConflict, CodeEditConflict, err.Error())
+	case errors.Is(err, auth.UnAuthorizedErr):
+		return NewProblem(r, http.StatusUnauthorized, CodeUnauthorized, "missing, invalid or expired credentials")
+	case errors.Is(err, auth.InvalidRequestErr):
+		return NewProblem(r, http.StatusBadRequest, CodeMalformedRequest, "malformed authorization header")
+	default:
+		return NewProblem(r, http.StatusInternalServerError, CodeInternal, "an unexpected error occurred")
+	}
+}
+
+// WriteProblem writes the problem as the response, unauthorized responses challenge the caller for a bearer token
+func WriteProblem(w http.ResponseWriter, problem *Problem) {
+	if problem.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.Header().Set("Content-Type", PROBLEM_CONTENT_TYPE)
+	w.WriteHeader(problem.Status)
+	w.Write(utils.ToRAWJSON(problem))
+}
+
+// WriteError writes the problem err maps to
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	WriteProblem(w, ProblemFromError(r, err))
+}
+
+// WriteMethodNotAllowed rejects the request method, listing the methods the resource allows
+func WriteMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed)
+	WriteProblem(w, NewProblem(r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "unsupported HTTP method "+r.Method))
+}
+
+// NotFound serves the problem for unknown routes
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, NewProblem(r, http.StatusNotFound, CodeNotFound, "no resource at "+r.URL.Path))
+}
+
+// MissingParameter returns the validation problem of a missing required query parameter
+func MissingParameter(r *http.Request, name string) *Problem {
+	errs := utils.ValidationErrors{}
+	errs.Add(name, "is required")
+
+	return ProblemFromError(r, errs)
 }
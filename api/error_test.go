@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblemFromError(t *testing.T) {
+	fields := utils.ValidationErrors{}
+	fields.Add("email", "is required")
+
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{err: fields, status: http.StatusBadRequest, code: CodeValidationFailed},
+		{err: fmt.Errorf("%w - bad cursor", utils.ValidationErr), status: http.StatusBadRequest, code: CodeValidationFailed},
+		{err: fmt.Errorf("no user err:%w", utils.ErrNotFound), status: http.StatusNotFound, code: CodeNotFound},
+		{err: fmt.Errorf("email taken err: %w", utils.AlreadyExists), status: http.StatusConflict, code: CodeAlreadyExists},
+		{err: fmt.Errorf("user 1 is at version 2 err: %w", utils.VersionConflict), status: http.StatusConflict, code: CodeEditConflict},
+		{err: fmt.Errorf("%w: %w", utils.PreconditionFailed, utils.VersionConflict), status: http.StatusPreconditionFailed, code: CodePreconditionFailed},
+		{err: auth.UnAuthorizedErr, status: http.StatusUnauthorized, code: CodeUnauthorized},
+		{err: auth.InvalidRequestErr, status: http.StatusBadRequest, code: CodeMalformedRequest},
+		{err: fmt.Errorf("connection refused"), status: http.StatusInternalServerError, code: CodeInternal},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/users?id=1", nil)
+		problem := ProblemFromError(r, tc.err)
+
+		assert.Equal(t, tc.status, problem.Status, tc.err)
+		assert.Equal(t, tc.code, problem.Code, tc.err)
+		assert.Equal(t, PROBLEM_TYPE_PREFIX+tc.code, problem.Type)
+		assert.Equal(t, http.StatusText(tc.status), problem.Title)
+		assert.Equal(t, "/users", problem.Instance)
+	}
+
+	problem := ProblemFromError(httptest.NewRequest(http.MethodGet, "/", nil), fmt.Errorf("dial tcp 10.0.0.1:5432"))
+	assert.NotContains(t, problem.Detail, "10.0.0.1", "unexpected errors are not disclosed")
+}
+
+func TestWriteError(t *testing.T) {
+	fields := utils.ValidationErrors{}
+	fields.Add("email", "is required")
+	fields.Add("countryCode", "must be an ISO 3166-1 alpha-2 country code")
+
+	r := httptest.NewRequest(http.MethodPut, "/users", nil)
+	r = r.WithContext(utils.WithRequestID(r.Context(), "req-1"))
+	w := httptest.NewRecorder()
+	WriteError(w, r, fields)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, PROBLEM_CONTENT_TYPE, w.Header().Get("Content-Type"))
+
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "req-1", problem.RequestID)
+	assert.Equal(t, []*utils.FieldError(fields), problem.Errors)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/jackmcguire1/UserService/api"
 	"github.com/jackmcguire1/UserService/pkg/utils"
 )
 
@@ -30,6 +31,11 @@ func (h *HealthCheckHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method != http.MethodGet {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET")
+		return
+	}
+
 	h.Logger.Info("fetching healthcheck")
 
 	data := &HealthCheckResp{
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var requestID string
+	h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = utils.RequestID(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NotEmpty(t, requestID)
+	assert.Equal(t, requestID, w.Header().Get(REQUEST_ID_HEADER))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(REQUEST_ID_HEADER, "abc")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, "abc", requestID)
+	assert.Equal(t, "abc", w.Header().Get(REQUEST_ID_HEADER))
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted bool
+		headers map[string]string
+		ip      string
+	}{
+		{name: "remote address", ip: "192.0.2.1"},
+		{name: "untrusted forwarded", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}, ip: "192.0.2.1"},
+		{name: "forwarded", trusted: true, headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"}, ip: "203.0.113.7"},
+		{name: "real ip", trusted: true, headers: map[string]string{"X-Real-IP": "203.0.113.8"}, ip: "203.0.113.8"},
+		{name: "trusted without headers", trusted: true, ip: "192.0.2.1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ip string
+			h := ClientIPMiddleware(tc.trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = utils.ClientIP(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, tc.ip, ip)
+		})
+	}
+}
@@ -1,15 +1,20 @@
 package searchapi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/jackmcguire1/UserService/api"
 	"github.com/jackmcguire1/UserService/api/auth"
 	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/iso3166"
 	"github.com/jackmcguire1/UserService/pkg/utils"
 )
 
@@ -17,6 +22,8 @@ type SearchHandler struct {
 	UserService user.UserService
 	AuthHandler *auth.Handler
 	Logger      *slog.Logger
+	// FieldsEncrypted rejects the filters and sorts on the personal fields encrypted by user.EncryptedRepository
+	FieldsEncrypted bool
 }
 
 func (h *SearchHandler) UsersByCountry(w http.ResponseWriter, r *http.Request) {
@@ -30,27 +37,8 @@ func (h *SearchHandler) UsersByCountry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, err := h.AuthHandler.ValidateRequest(r)
-	if err != nil {
-		if errors.Is(err, auth.UnAuthorizedErr) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		if errors.Is(err, auth.InvalidRequestErr) {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	if !claims.IsAdmin {
-		h.Logger.
-			With("userID", claims.Subject).
-			With("error", "user is not administrator").
-			Error("unauthenticated request")
-
-		w.WriteHeader(http.StatusUnauthorized)
+	claims, ok := h.authorize(w, r)
+	if !ok {
 		return
 	}
 
@@ -69,20 +57,21 @@ func (h *SearchHandler) UsersByCountry(w http.ResponseWriter, r *http.Request) {
 			With("values", r.URL.Query()).
 			Error("request does not contain 'cc' query parameter")
 
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(utils.ToRAWJSON(api.HTTPError{Error: "missing 'cc'  query parameter"}))
+		api.WriteProblem(w, api.MissingParameter(r, "cc"))
 
 		return
 	}
 
 	countryCode := strings.ToUpper(ccParams[0])
-	if len(countryCode) != 2 {
+	if !iso3166.Valid(countryCode) {
 		h.Logger.
 			With("country-code", countryCode).
 			Error("request does not contain valid 'cc' query parameter")
 
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(utils.ToRAWJSON(api.HTTPError{Error: "invalid 'cc'  query parameter - must be ISO ALPHA-2"}))
+		errs := utils.ValidationErrors{}
+		errs.Add("cc", "must be an ISO 3166-1 alpha-2 country code")
+
+		api.WriteError(w, r, errs)
 
 		return
 	}
@@ -91,15 +80,14 @@ func (h *SearchHandler) UsersByCountry(w http.ResponseWriter, r *http.Request) {
 		With("country-code", countryCode).
 		Info("searching for users by country code")
 
-	users, err := h.UserService.GetUsersByCountry(countryCode)
+	users, err := h.UserService.GetUsersByCountry(callerContext(r, claims), countryCode)
 	if err != nil {
 		h.Logger.
 			With("error", err).
 			With("country-code", countryCode).
 			Error("failed to get users by country code")
 
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+		api.WriteError(w, r, err)
 
 		return
 	}
@@ -110,64 +98,165 @@ func (h *SearchHandler) UsersByCountry(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 
 	h.Logger.
-		With("users", string(data)).
+		With("user-count", len(users)).
 		Debug("returning users by country")
 
 	return
 }
 
 func (h *SearchHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	type AllUsers struct {
-		Users []*user.User `json:"users"`
+	claims, ok := h.authorize(w, r)
+	if !ok {
+		return
 	}
 
-	claims, err := h.AuthHandler.ValidateRequest(r)
+	query, err := parseSearchQuery(r.URL.Query())
 	if err != nil {
-		if errors.Is(err, auth.UnAuthorizedErr) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		if errors.Is(err, auth.InvalidRequestErr) {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
+		h.Logger.
+			With("error", err).
+			With("values", r.URL.Query()).
+			Error("request contains invalid search parameters")
+
+		api.WriteError(w, r, err)
+
 		return
 	}
 
-	if !claims.IsAdmin {
-		h.Logger.
-			With("userID", claims.Subject).
-			With("error", "user is not administrator").
-			Error("unauthenticated request")
+	if h.FieldsEncrypted {
+		if err := encryptedFieldsErr(query); err != nil {
+			h.Logger.
+				With("error", err).
+				Warn("search filters or sorts by encrypted fields")
 
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+			api.WriteError(w, r, err)
+
+			return
+		}
 	}
 
 	h.Logger.
-		Info("search all users")
+		With("query", query).
+		Info("search users")
 
-	users, err := h.UserService.GetAllUsers()
+	result, err := h.UserService.SearchUsers(callerContext(r, claims), query)
 	if err != nil {
+		if errors.Is(err, utils.ValidationErr) {
+			h.Logger.
+				With("error", err).
+				Error("invalid search query")
+
+			api.WriteError(w, r, err)
+
+			return
+		}
+
 		h.Logger.
 			With("error", err).
-			Error("failed to get all users")
+			Error("failed to search users")
 
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+		api.WriteError(w, r, err)
 
 		return
 	}
 
-	data, _ := json.MarshalIndent(&AllUsers{Users: users}, "", "\t")
+	data, _ := json.MarshalIndent(result, "", "\t")
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(data)
 
 	h.Logger.
-		With("users", string(data)).
+		With("user-count", len(result.Users)).
 		Debug("returning users")
 
 	return
 }
+
+// authorize writes the problem and returns false unless the caller is an administrator
+func (h *SearchHandler) authorize(w http.ResponseWriter, r *http.Request) (*user.Claims, bool) {
+	claims, err := h.AuthHandler.ValidateRequest(r)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			Warn("unauthenticated request")
+
+		api.WriteError(w, r, err)
+		return nil, false
+	}
+
+	if !claims.IsAdmin {
+		h.Logger.
+			With("userID", claims.Subject).
+			With("error", "user is not administrator").
+			Error("unauthenticated request")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusUnauthorized, api.CodeUnauthorized, "only administrators may search users"))
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// callerContext annotates the request context with the administrator making the search
+func callerContext(r *http.Request, claims *user.Claims) context.Context {
+	ctx := utils.WithCallerID(r.Context(), claims.Subject)
+	return utils.WithCallerIsAdmin(ctx, claims.IsAdmin)
+}
+
+// parseSearchQuery reads the pagination, sort and filter query parameters,
+// the sort parameter may be prefixed with '-' for descending order e.g. sort=-saved
+func parseSearchQuery(values url.Values) (*user.SearchQuery, error) {
+	query := &user.SearchQuery{
+		Cursor:      values.Get("cursor"),
+		CountryCode: values.Get("country"),
+		EmailDomain: values.Get("email_domain"),
+		NamePrefix:  values.Get("name_prefix"),
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("%w - 'limit' must be a positive integer", utils.ValidationErr)
+		}
+		query.Limit = limit
+	}
+
+	if v := values.Get("sort"); v != "" {
+		query.Descending = strings.HasPrefix(v, "-")
+		query.SortBy = user.SortField(strings.TrimPrefix(v, "-"))
+	}
+
+	if v := values.Get("is_admin"); v != "" {
+		isAdmin, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w - 'is_admin' must be a boolean", utils.ValidationErr)
+		}
+		query.IsAdmin = &isAdmin
+	}
+
+	if v := values.Get("include_deleted"); v != "" {
+		includeDeleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w - 'include_deleted' must be a boolean", utils.ValidationErr)
+		}
+		query.IncludeDeleted = includeDeleted
+	}
+
+	return query, nil
+}
+
+// encryptedFieldsErr lists the query parameters which filter or sort by encrypted fields, their stored ciphertexts
+// neither share prefixes nor sort like the values they encrypt
+func encryptedFieldsErr(query *user.SearchQuery) error {
+	errs := utils.ValidationErrors{}
+	if query.EmailDomain != "" {
+		errs.Add("email_domain", "is not supported while personal fields are encrypted")
+	}
+	if query.NamePrefix != "" {
+		errs.Add("name_prefix", "is not supported while personal fields are encrypted")
+	}
+	if query.SortBy == user.SortByLastName || query.SortBy == user.SortByEmail {
+		errs.Add("sort", "cannot sort by %s while personal fields are encrypted", query.SortBy)
+	}
+
+	return errs.Err()
+}
@@ -0,0 +1,185 @@
+package searchapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestHandler(t *testing.T, repo *user.MockRepository) *SearchHandler {
+	svc, err := user.NewService(&user.Resources{Repo: repo})
+	assert.NoError(t, err)
+
+	return &SearchHandler{
+		UserService: svc,
+		Logger:      slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})),
+		AuthHandler: &auth.Handler{JWTSecret: []byte("1234"), Expiry: time.Minute},
+	}
+}
+
+func newTestRequest(t *testing.T, h *SearchHandler, target string, caller *user.User) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+
+	token, err := h.AuthHandler.SignClaims(caller)
+	assert.NoError(t, err)
+	r.Header.Set(auth.AUTH_HEADER, "Bearer "+token)
+
+	return r
+}
+
+func TestGetAllUsersPagination(t *testing.T) {
+	repo := &user.MockRepository{}
+	repo.On("SearchUsers", mock.MatchedBy(func(q *user.SearchQuery) bool {
+		return q.Limit == 2 &&
+			q.Cursor == "" &&
+			q.SortBy == user.SortByLastName &&
+			q.Descending &&
+			q.CountryCode == "GB" &&
+			q.IsAdmin != nil && !*q.IsAdmin &&
+			q.EmailDomain == "example.com" &&
+			q.NamePrefix == "jo" &&
+			q.IncludeDeleted
+	})).Return(&user.SearchResult{
+		Users:      []*user.User{{ID: "1"}, {ID: "2"}},
+		NextCursor: "next",
+	}, nil)
+
+	h := newTestHandler(t, repo)
+	w := httptest.NewRecorder()
+	h.GetAllUsers(w, newTestRequest(t, h, "/search/users/?limit=2&sort=-lastName&country=gb&is_admin=false&email_domain=example.com&name_prefix=jo&include_deleted=true", &user.User{ID: "admin", IsAdmin: true}))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp map[string]any
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp["users"], 2)
+	assert.Equal(t, "next", resp["next_cursor"])
+}
+
+func TestGetAllUsersInvalidQuery(t *testing.T) {
+	repo := &user.MockRepository{}
+	h := newTestHandler(t, repo)
+	admin := &user.User{ID: "admin", IsAdmin: true}
+
+	for _, target := range []string{
+		"/search/users/?limit=abc",
+		"/search/users/?limit=1000",
+		"/search/users/?sort=password",
+		"/search/users/?is_admin=maybe",
+		"/search/users/?include_deleted=maybe",
+		"/search/users/?cursor=invalid",
+	} {
+		w := httptest.NewRecorder()
+		h.GetAllUsers(w, newTestRequest(t, h, target, admin))
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+
+	repo.AssertNotCalled(t, "SearchUsers", mock.Anything)
+}
+
+func TestGetAllUsersEncryptedFields(t *testing.T) {
+	repo := &user.MockRepository{}
+	repo.On("SearchUsers", mock.Anything).Return(&user.SearchResult{Users: []*user.User{}}, nil)
+	h := newTestHandler(t, repo)
+	h.FieldsEncrypted = true
+	admin := &user.User{ID: "admin", IsAdmin: true}
+
+	w := httptest.NewRecorder()
+	h.GetAllUsers(w, newTestRequest(t, h, "/search/users/?email_domain=example.com&name_prefix=jo&sort=-lastName", admin))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var problem api.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, api.CodeValidationFailed, problem.Code)
+	fields := []string{}
+	for _, fieldErr := range problem.Errors {
+		fields = append(fields, fieldErr.Field)
+	}
+	assert.Equal(t, []string{"email_domain", "name_prefix", "sort"}, fields)
+	repo.AssertNotCalled(t, "SearchUsers", mock.Anything)
+
+	w = httptest.NewRecorder()
+	h.GetAllUsers(w, newTestRequest(t, h, "/search/users/?country=GB&sort=-saved", admin))
+	assert.Equal(t, http.StatusOK, w.Code, "filters and sorts on plaintext fields are supported")
+}
+
+func TestGetAllUsersRequiresAdmin(t *testing.T) {
+	repo := &user.MockRepository{}
+	h := newTestHandler(t, repo)
+
+	w := httptest.NewRecorder()
+	h.GetAllUsers(w, newTestRequest(t, h, "/search/users/", &user.User{ID: "1234"}))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	repo.AssertNotCalled(t, "SearchUsers", mock.Anything)
+}
+
+func TestUsersByCountryValidatesCountryCode(t *testing.T) {
+	repo := &user.MockRepository{}
+	repo.On("GetUsersByCountry", "GB").Return([]*user.User{{ID: "1"}}, nil)
+
+	h := newTestHandler(t, repo)
+	admin := &user.User{ID: "admin", IsAdmin: true}
+
+	w := httptest.NewRecorder()
+	h.UsersByCountry(w, newTestRequest(t, h, "/search/users/by_country?cc=gb", admin))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 'UK' is two letters but not an assigned ISO 3166-1 code
+	w = httptest.NewRecorder()
+	h.UsersByCountry(w, newTestRequest(t, h, "/search/users/by_country?cc=UK", admin))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp api.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, api.CodeValidationFailed, resp.Code)
+	assert.Len(t, resp.Errors, 1)
+	assert.Equal(t, "cc", resp.Errors[0].Field)
+	repo.AssertNumberOfCalls(t, "GetUsersByCountry", 1)
+}
+
+func TestAuthFailuresReturnProblems(t *testing.T) {
+	repo := &user.MockRepository{}
+	h := newTestHandler(t, repo)
+
+	anonymous := httptest.NewRequest(http.MethodGet, "/search/users/", nil)
+	malformed := httptest.NewRequest(http.MethodGet, "/search/users/", nil)
+	malformed.Header.Set(auth.AUTH_HEADER, "Basic dXNlcjpwYXNz")
+	notAdmin := newTestRequest(t, h, "/search/users/", &user.User{ID: "1234"})
+
+	for _, tc := range []struct {
+		name   string
+		r      *http.Request
+		status int
+		code   string
+	}{
+		{name: "anonymous", r: anonymous, status: http.StatusUnauthorized, code: api.CodeUnauthorized},
+		{name: "malformed header", r: malformed, status: http.StatusBadRequest, code: api.CodeMalformedRequest},
+		{name: "not an administrator", r: notAdmin, status: http.StatusUnauthorized, code: api.CodeUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.GetAllUsers(w, tc.r)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, api.PROBLEM_CONTENT_TYPE, w.Header().Get("Content-Type"))
+
+			var resp api.Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tc.code, resp.Code)
+			assert.Equal(t, tc.status, resp.Status)
+			assert.Equal(t, "/search/users/", resp.Instance)
+		})
+	}
+	repo.AssertNotCalled(t, "SearchUsers", mock.Anything)
+}
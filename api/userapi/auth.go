@@ -1,13 +1,14 @@
 package userapi
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"time"
 
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/user"
 	"github.com/jackmcguire1/UserService/pkg/utils"
 )
 
@@ -17,7 +18,12 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 func (handler *UserHandler) SignIn(w http.ResponseWriter, r *http.Request) {
@@ -27,7 +33,7 @@ func (handler *UserHandler) SignIn(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusBadRequest)
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,POST")
 		return
 	}
 
@@ -38,48 +44,222 @@ func (handler *UserHandler) SignIn(w http.ResponseWriter, r *http.Request) {
 			With("error", err).
 			Error("failed to JSON decode login request")
 
-		w.WriteHeader(http.StatusBadRequest)
+		api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, "request body is not a valid login request"))
 		return
 	}
 
-	usr, err := handler.UserService.GetUserByEmail(loginReq.Email)
+	usr, err := handler.UserService.Authenticate(r.Context(), loginReq.Email, loginReq.Password)
 	if err != nil {
-		if errors.Is(err, utils.ErrNotFound) {
-			w.WriteHeader(http.StatusNotFound)
+		if errors.Is(err, utils.ErrNotFound) || errors.Is(err, user.InvalidCredentialsErr) {
+			api.WriteProblem(w, api.NewProblem(r, http.StatusNotFound, api.CodeInvalidCredentials, "unknown email or wrong password"))
 			return
 		}
 
-		w.WriteHeader(http.StatusInternalServerError)
+		handler.Logger.
+			With("error", err).
+			Error("failed to authenticate user")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	tokens, err := handler.AuthHandler.StartSession(r.Context(), usr)
+	if err != nil {
+		handler.Logger.
+			With("error", err).
+			With("user-id", usr.ID).
+			Error("failed to start session")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	handler.writeTokens(w, tokens)
+
+	return
+}
+
+func (handler *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method != http.MethodPost {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,POST")
+		return
+	}
+
+	var refreshReq *RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&refreshReq)
+	if err != nil {
+		handler.Logger.
+			With("error", err).
+			Error("failed to JSON decode refresh request")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, "request body is not a valid refresh request"))
+		return
+	}
+
+	tokens, err := handler.AuthHandler.RefreshSession(r.Context(), refreshReq.RefreshToken, handler.UserService.GetUser)
+	if err != nil {
+		handler.Logger.
+			With("error", err).
+			Warn("failed to refresh session")
+
+		api.WriteError(w, r, err)
 		return
 	}
 
-	sha := sha256.New()
-	sha.Write([]byte(loginReq.Password))
-	password := sha.Sum(nil)
+	handler.writeTokens(w, tokens)
+
+	return
+}
+
+func (handler *UserHandler) SignOut(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method != http.MethodPost {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,POST")
+		return
+	}
 
-	if !bytes.Equal(password, usr.Password) {
-		w.WriteHeader(http.StatusNotFound)
+	claims, err := handler.AuthHandler.ValidateRequest(r)
+	if err != nil {
+		api.WriteError(w, r, err)
 		return
 	}
 
-	tokenStr, err := handler.AuthHandler.SignClaims(usr)
+	err = handler.AuthHandler.RevokeSession(r.Context(), claims.ID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		handler.Logger.
+			With("error", err).
+			With("user-id", claims.Subject).
+			Error("failed to revoke session")
+
+		api.WriteError(w, r, err)
 		return
 	}
 
+	http.SetCookie(w, &http.Cookie{
+		Name:    "token",
+		Value:   "",
+		Expires: time.Unix(0, 0),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return
+}
+
+// RevokeSessions force-logs out every session of the user given by the 'id' query parameter,
+// callers may revoke their own sessions while administrators may revoke anyone's
+func (handler *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method != http.MethodPost {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,POST")
+		return
+	}
+
+	claims, err := handler.AuthHandler.ValidateRequest(r)
+	if err != nil {
+		api.WriteError(w, r, err)
+		return
+	}
+
+	userID := r.URL.Query().Get("id")
+	if userID == "" {
+		api.WriteProblem(w, api.MissingParameter(r, "id"))
+		return
+	}
+
+	if err := canDelete(claims, userID); err != nil {
+		handler.Logger.
+			With("user-id", userID).
+			With("caller-id", claims.Subject).
+			With("error", err).
+			Warn("caller is not permitted to revoke sessions")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
+		return
+	}
+
+	err = handler.AuthHandler.RevokeUserSessions(r.Context(), userID)
+	if err != nil {
+		handler.Logger.
+			With("error", err).
+			With("user-id", userID).
+			Error("failed to revoke user sessions")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	handler.Logger.
+		With("user-id", userID).
+		With("caller-id", claims.Subject).
+		Info("revoked user sessions")
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return
+}
+
+// JWKS serves the public keys of the key ring so other services can verify our tokens offline
+func (handler *UserHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET")
+		return
+	}
+
+	set, err := handler.AuthHandler.KeySet()
+	if err != nil {
+		handler.Logger.
+			With("error", err).
+			Error("failed to encode signing keys")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	data, _ := json.MarshalIndent(set, "", "\t")
+
+	// keys are rotated well within the grace period so verifiers may cache briefly
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+
+	return
+}
+
+func (handler *UserHandler) writeTokens(w http.ResponseWriter, tokens *auth.Tokens) {
 	// Finally, we set the client cookie for "token" as the JWT we just generated
 	// we also set an expiry time which is the same as the token itself
 	http.SetCookie(w, &http.Cookie{
 		Name:    "token",
-		Value:   tokenStr,
+		Value:   tokens.AccessToken,
 		Expires: time.Now().UTC().Add(handler.AuthHandler.Expiry),
 	})
 
-	b, err := json.MarshalIndent(&LoginResponse{Token: tokenStr}, "", "\t")
+	b, _ := json.MarshalIndent(&LoginResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, "", "\t")
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
-
-	return
 }
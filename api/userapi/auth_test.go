@@ -0,0 +1,154 @@
+package userapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/session"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSignInLegacyPassword(t *testing.T) {
+	legacy := sha256.Sum256([]byte("secret"))
+
+	repo := &user.MockRepository{}
+	repo.On("GetUserByEmail", "test@example.com").Return(&user.User{ID: "1234", Password: legacy[:]}, nil)
+	repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+
+	h := newTestHandler(t, repo, false)
+	w := httptest.NewRecorder()
+	h.SignIn(w, newTestRequest(t, h, http.MethodPost, "/sign_in", `{"email":"test@example.com","password":"secret"}`, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	repo.AssertCalled(t, "PutUser", mock.Anything, mock.Anything)
+
+	var resp *LoginResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	claims, err := h.AuthHandler.ValidateJWT(context.Background(), resp.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", claims.Subject)
+}
+
+func TestSignInInvalidCredentials(t *testing.T) {
+	legacy := sha256.Sum256([]byte("secret"))
+
+	repo := &user.MockRepository{}
+	repo.On("GetUserByEmail", "test@example.com").Return(&user.User{ID: "1234", Password: legacy[:]}, nil)
+	repo.On("GetUserByEmail", "missing@example.com").Return(nil, utils.ErrNotFound)
+
+	h := newTestHandler(t, repo, false)
+
+	w := httptest.NewRecorder()
+	h.SignIn(w, newTestRequest(t, h, http.MethodPost, "/sign_in", `{"email":"test@example.com","password":"wrong"}`, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.SignIn(w, newTestRequest(t, h, http.MethodPost, "/sign_in", `{"email":"missing@example.com","password":"secret"}`, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+}
+
+func TestSignInRefreshSignOut(t *testing.T) {
+	legacy := sha256.Sum256([]byte("secret"))
+
+	repo := &user.MockRepository{}
+	repo.On("GetUserByEmail", "test@example.com").Return(&user.User{ID: "1234", Password: legacy[:]}, nil)
+	repo.On("GetUser", "1234").Return(&user.User{ID: "1234"}, nil)
+	repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+
+	h := newTestHandler(t, repo, false)
+	h.AuthHandler.RefreshExpiry = time.Hour
+	h.AuthHandler.Sessions = session.NewMemoryRepo()
+
+	w := httptest.NewRecorder()
+	h.SignIn(w, newTestRequest(t, h, http.MethodPost, "/sign_in", `{"email":"test@example.com","password":"secret"}`, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var login *LoginResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	assert.NotEmpty(t, login.RefreshToken)
+
+	w = httptest.NewRecorder()
+	h.RefreshToken(w, newTestRequest(t, h, http.MethodPost, "/token/refresh", utils.ToJSON(&RefreshRequest{RefreshToken: login.RefreshToken}), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var refreshed *LoginResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &refreshed))
+
+	r := newTestRequest(t, h, http.MethodPost, "/sign_out", "", nil)
+	r.Header.Set(auth.AUTH_HEADER, "Bearer "+refreshed.Token)
+	w = httptest.NewRecorder()
+	h.SignOut(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	_, err := h.AuthHandler.ValidateJWT(context.Background(), refreshed.Token)
+	assert.ErrorIs(t, err, auth.UnAuthorizedErr)
+
+	w = httptest.NewRecorder()
+	h.RefreshToken(w, newTestRequest(t, h, http.MethodPost, "/token/refresh", utils.ToJSON(&RefreshRequest{RefreshToken: refreshed.RefreshToken}), nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRevokeSessionsAuthorization(t *testing.T) {
+	h := newTestHandler(t, &user.MockRepository{}, false)
+	h.AuthHandler.RefreshExpiry = time.Hour
+	h.AuthHandler.Sessions = session.NewMemoryRepo()
+
+	target, err := h.AuthHandler.StartSession(context.Background(), testOwner)
+	assert.NoError(t, err)
+	other, err := h.AuthHandler.StartSession(context.Background(), testOther)
+	assert.NoError(t, err)
+	admin, err := h.AuthHandler.StartSession(context.Background(), testAdmin)
+	assert.NoError(t, err)
+
+	r := newTestRequest(t, h, http.MethodPost, "/users/sessions/revoke?id=1234", "", nil)
+	r.Header.Set(auth.AUTH_HEADER, "Bearer "+other.AccessToken)
+	w := httptest.NewRecorder()
+	h.RevokeSessions(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	r = newTestRequest(t, h, http.MethodPost, "/users/sessions/revoke?id=1234", "", nil)
+	r.Header.Set(auth.AUTH_HEADER, "Bearer "+admin.AccessToken)
+	w = httptest.NewRecorder()
+	h.RevokeSessions(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	_, err = h.AuthHandler.ValidateJWT(context.Background(), target.AccessToken)
+	assert.ErrorIs(t, err, auth.UnAuthorizedErr)
+}
+
+func TestJWKS(t *testing.T) {
+	key, err := auth.GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+
+	h := newTestHandler(t, &user.MockRepository{}, false)
+	h.AuthHandler = &auth.Handler{Expiry: time.Minute, Keys: auth.NewKeyRing(key)}
+
+	w := httptest.NewRecorder()
+	h.JWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var set *auth.JWKSet
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	assert.Len(t, set.Keys, 1)
+	assert.Equal(t, key.ID, set.Keys[0].KeyID)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		w = httptest.NewRecorder()
+		h.JWKS(w, httptest.NewRequest(method, "/.well-known/jwks.json", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, method)
+		assert.Equal(t, "OPTIONS,GET", w.Header().Get("Allow"))
+		assert.NotContains(t, w.Body.String(), key.ID, "the key set is only served on GET")
+	}
+}
@@ -0,0 +1,93 @@
+package userapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// entityTag is the strong entity tag of the user's representation (RFC 9110), its version
+func entityTag(usr *user.User) string {
+	return `"` + strconv.FormatInt(usr.Version, 10) + `"`
+}
+
+// entityTags splits a list header such as If-None-Match into its entity tags
+func entityTags(header string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// notModified reports whether the If-None-Match header of the request matches the user,
+// tags are compared weakly so validators of compressed or proxied responses also match
+func notModified(r *http.Request, usr *user.User) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	current := entityTag(usr)
+	for _, tag := range entityTags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeCurrentUser responds with the user, or with 304 Not Modified when the caller's cached copy is current
+func (h *UserHandler) writeCurrentUser(w http.ResponseWriter, r *http.Request, usr *user.User) {
+	if notModified(r, usr) {
+		w.Header().Set("ETag", entityTag(usr))
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	h.writeUser(w, http.StatusOK, usr)
+}
+
+// expectVersion makes the write conditional on the version named by the If-Match header, see
+// utils.WithExpectedVersion. Only a single strong entity tag or '*' is supported, weak tags never
+// match (RFC 9110) and fail the precondition, the problem is written and false returned otherwise
+func (h *UserHandler) expectVersion(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return ctx, true
+	}
+
+	tags := entityTags(header)
+	if len(tags) != 1 {
+		api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, "If-Match must name a single entity tag"))
+		return nil, false
+	}
+
+	if strings.HasPrefix(tags[0], "W/") {
+		api.WriteProblem(w, api.NewProblem(r, http.StatusPreconditionFailed, api.CodePreconditionFailed, "weak entity tags never match If-Match"))
+		return nil, false
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(tags[0], `"`), 10, 64)
+	if err != nil || len(tags[0]) < 2 || !strings.HasPrefix(tags[0], `"`) || !strings.HasSuffix(tags[0], `"`) {
+		h.Logger.
+			With("if-match", header).
+			Warn("If-Match does not name a user version")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusPreconditionFailed, api.CodePreconditionFailed, "the user is not at version "+tags[0]))
+		return nil, false
+	}
+
+	return utils.WithExpectedVersion(ctx, version), true
+}
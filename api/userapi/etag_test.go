@@ -0,0 +1,138 @@
+package userapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetUserETag(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{name: "unconditional", status: http.StatusOK},
+		{name: "current", ifNoneMatch: `"1"`, status: http.StatusNotModified},
+		{name: "weak current", ifNoneMatch: `W/"1"`, status: http.StatusNotModified},
+		{name: "list", ifNoneMatch: `"0", "1"`, status: http.StatusNotModified},
+		{name: "any", ifNoneMatch: `*`, status: http.StatusNotModified},
+		{name: "stale", ifNoneMatch: `"0"`, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stored := *storedUser
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&stored, nil)
+
+			h := newTestHandler(t, repo, false)
+			r := newResourceRequest(t, h, http.MethodGet, "1234", "", testOwner)
+			if tc.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+
+			w := httptest.NewRecorder()
+			h.User(w, r)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+			if tc.status == http.StatusNotModified {
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	replacement := `{"firstName":"Jane","lastName":"Doe","email":"jane@example.com","countryCode":"GB"}`
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		ifMatch string
+		status  int
+		code    string
+	}{
+		{name: "replace current", method: http.MethodPut, body: replacement, ifMatch: `"1"`, status: http.StatusOK},
+		{name: "replace any", method: http.MethodPut, body: replacement, ifMatch: `*`, status: http.StatusOK},
+		{name: "replace stale", method: http.MethodPut, body: replacement, ifMatch: `"0"`, status: http.StatusPreconditionFailed, code: api.CodePreconditionFailed},
+		{name: "replace weak", method: http.MethodPut, body: replacement, ifMatch: `W/"1"`, status: http.StatusPreconditionFailed, code: api.CodePreconditionFailed},
+		{name: "replace unknown tag", method: http.MethodPut, body: replacement, ifMatch: `"abc"`, status: http.StatusPreconditionFailed, code: api.CodePreconditionFailed},
+		{name: "replace several tags", method: http.MethodPut, body: replacement, ifMatch: `"0", "1"`, status: http.StatusBadRequest, code: api.CodeMalformedRequest},
+		{name: "patch current", method: http.MethodPatch, body: `{"nickName":"johnny"}`, ifMatch: `"1"`, status: http.StatusOK},
+		{name: "patch stale", method: http.MethodPatch, body: `{"nickName":"johnny"}`, ifMatch: `"2"`, status: http.StatusPreconditionFailed, code: api.CodePreconditionFailed},
+		{name: "delete current", method: http.MethodDelete, ifMatch: `"1"`, status: http.StatusNoContent},
+		{name: "delete stale", method: http.MethodDelete, ifMatch: `"0"`, status: http.StatusPreconditionFailed, code: api.CodePreconditionFailed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stored := *storedUser
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&stored, nil)
+			repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+			repo.On("DeleteUser", "1234", mock.Anything).Return(nil)
+
+			h := newTestHandler(t, repo, false)
+			r := newResourceRequest(t, h, tc.method, "1234", tc.body, testOwner)
+			r.Header.Set("If-Match", tc.ifMatch)
+
+			w := httptest.NewRecorder()
+			h.User(w, r)
+
+			assert.Equal(t, tc.status, w.Code, w.Body.String())
+			if tc.code != "" {
+				var problem api.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				assert.Equal(t, tc.code, problem.Code)
+				repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+				repo.AssertNotCalled(t, "DeleteUser", mock.Anything, mock.Anything)
+				return
+			}
+
+			if tc.status == http.StatusOK {
+				assert.Equal(t, `"2"`, w.Header().Get("ETag"), "updates respond with the new version")
+			}
+		})
+	}
+}
+
+func TestConcurrentPatchFailsPrecondition(t *testing.T) {
+	stored := *storedUser
+	repo := &user.MockRepository{}
+	repo.On("GetUser", "1234").Return(&stored, nil)
+	repo.On("PutUser", mock.Anything, mock.Anything).Return(utils.VersionConflict)
+
+	h := newTestHandler(t, repo, false)
+	w := httptest.NewRecorder()
+	h.User(w, newResourceRequest(t, h, http.MethodPatch, "1234", `{"nickName":"johnny"}`, testOwner))
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code, "patches without If-Match are conditional on the patched version")
+}
+
+func TestConcurrentReplaceConflicts(t *testing.T) {
+	stored := *storedUser
+	repo := &user.MockRepository{}
+	repo.On("GetUser", "1234").Return(&stored, nil)
+	repo.On("PutUser", mock.Anything, mock.Anything).Return(utils.VersionConflict)
+
+	h := newTestHandler(t, repo, false)
+	w := httptest.NewRecorder()
+	body := `{"firstName":"Jane","lastName":"Doe","email":"jane@example.com","countryCode":"GB"}`
+	h.User(w, newResourceRequest(t, h, http.MethodPut, "1234", body, testOwner))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var problem api.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, api.CodeEditConflict, problem.Code)
+}
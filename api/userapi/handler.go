@@ -4,6 +4,7 @@ import (
 	"log/slog"
 
 	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/privacy"
 	"github.com/jackmcguire1/UserService/dom/user"
 )
 
@@ -11,4 +12,10 @@ type UserHandler struct {
 	UserService user.UserService
 	Logger      *slog.Logger
 	AuthHandler *auth.Handler
+	// PrivacyService serves the export and erasure routes
+	PrivacyService privacy.PrivacyService
+
+	// AllowPublicSignUp permits unauthenticated callers to create non-admin accounts,
+	// when disabled only administrators may create users
+	AllowPublicSignUp bool
 }
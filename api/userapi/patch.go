@@ -0,0 +1,229 @@
+package userapi
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	MERGE_PATCH_CONTENT_TYPE = "application/merge-patch+json"
+	JSON_PATCH_CONTENT_TYPE  = "application/json-patch+json"
+
+	// ACCEPT_PATCH lists the patch formats of the user resource, plain JSON bodies are merge patches
+	ACCEPT_PATCH = MERGE_PATCH_CONTENT_TYPE + "," + JSON_PATCH_CONTENT_TYPE
+)
+
+// passwordField is write-only, it is patched as plain text and hashed before the user is stored
+const passwordField = "password"
+
+// protectedFields may only be changed by administrators
+var protectedFields = map[string]bool{
+	"_id":      true,
+	"saved":    true,
+	"version":  true,
+	"is_admin": true,
+}
+
+// readOnlyFields are managed by the service and may not be changed by anyone
+var readOnlyFields = map[string]bool{
+	"_id":       true,
+	"saved":     true,
+	"version":   true,
+	"deletedAt": true,
+	"deletedBy": true,
+}
+
+// patchUser applies the merge patch (RFC 7396) or JSON patch (RFC 6902) in the request body to the stored user,
+// only the fields the patch changes are checked against the caller's permissions. Patches are always
+// conditional on the version they were applied to, a concurrent update fails them with 412
+func (h *UserHandler) patchUser(ctx context.Context, w http.ResponseWriter, r *http.Request, claims *user.Claims, userId string) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = MERGE_PATCH_CONTENT_TYPE
+	}
+	if mediaType != MERGE_PATCH_CONTENT_TYPE && mediaType != JSON_PATCH_CONTENT_TYPE && mediaType != "application/json" {
+		w.Header().Set("Accept-Patch", ACCEPT_PATCH)
+		api.WriteProblem(w, api.NewProblem(r, http.StatusUnsupportedMediaType, api.CodeUnsupportedMediaType, "patches must be "+ACCEPT_PATCH))
+		return
+	}
+
+	ctx, ok := h.expectVersion(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		api.WriteError(w, r, err)
+		return
+	}
+
+	stored, ok := h.loadUser(ctx, w, r, claims, userId)
+	if !ok {
+		return
+	}
+
+	// the patch was applied to the stored version, a concurrent update in between fails the precondition
+	if _, ok := utils.ExpectedVersion(ctx); !ok {
+		ctx = utils.WithExpectedVersion(ctx, stored.Version)
+	}
+
+	original, err := userDocument(stored)
+	if err != nil {
+		api.WriteError(w, r, err)
+		return
+	}
+
+	patched, err := applyPatch(mediaType, original, body)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			With("user-id", userId).
+			Warn("failed to apply user patch")
+
+		if _, ok := err.(*patchDecodeError); ok {
+			api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, err.Error()))
+		} else {
+			api.WriteProblem(w, api.NewProblem(r, http.StatusUnprocessableEntity, api.CodePatchFailed, err.Error()))
+		}
+		return
+	}
+
+	var originalFields, patchedFields map[string]any
+	json.Unmarshal(original, &originalFields)
+	err = json.Unmarshal(patched, &patchedFields)
+	if err != nil || patchedFields == nil {
+		api.WriteProblem(w, api.NewProblem(r, http.StatusUnprocessableEntity, api.CodePatchFailed, "the patched user is not an object"))
+		return
+	}
+
+	changed := changedFields(originalFields, patchedFields)
+
+	errs := utils.ValidationErrors{}
+	forbidden := []string{}
+	for _, field := range changed {
+		// the soft delete fields are omitted while the user is not deleted
+		if _, ok := originalFields[field]; !ok && !readOnlyFields[field] {
+			errs.Add(field, "is not a user field")
+			continue
+		}
+		if protectedFields[field] && !claims.IsAdmin {
+			forbidden = append(forbidden, field)
+			continue
+		}
+		if readOnlyFields[field] {
+			errs.Add(field, "is read-only")
+		}
+	}
+	if len(forbidden) > 0 {
+		h.Logger.
+			With("user-id", userId).
+			With("caller-id", claims.Subject).
+			With("fields", forbidden).
+			Warn("caller is not permitted to patch protected fields")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, "only administrators may change "+strings.Join(forbidden, ", ")))
+		return
+	}
+	if err := errs.Err(); err != nil {
+		api.WriteError(w, r, err)
+		return
+	}
+
+	var usr *user.User
+	err = json.Unmarshal(patched, &usr)
+	if err != nil {
+		api.WriteProblem(w, api.NewProblem(r, http.StatusUnprocessableEntity, api.CodePatchFailed, "the patched user is invalid: "+err.Error()))
+		return
+	}
+	usr.Password = stored.Password
+
+	if password, _ := patchedFields[passwordField].(string); password != "" {
+		usr.Password, err = h.UserService.HashPassword(password)
+		if err != nil {
+			api.WriteError(w, r, err)
+			return
+		}
+	}
+
+	h.Logger.
+		With("user-id", userId).
+		With("fields", changed).
+		Info("patching user")
+
+	h.updateUser(ctx, w, r, claims, usr)
+}
+
+// patchDecodeError is a patch body which is not valid JSON or not a valid list of operations
+type patchDecodeError struct {
+	err error
+}
+
+func (e *patchDecodeError) Error() string {
+	return "request body is not a valid patch: " + e.err.Error()
+}
+
+// applyPatch returns the document patched with the body of the given media type
+func applyPatch(mediaType string, document, body []byte) ([]byte, error) {
+	if mediaType == JSON_PATCH_CONTENT_TYPE {
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, &patchDecodeError{err}
+		}
+
+		return patch.Apply(document)
+	}
+
+	if !json.Valid(body) {
+		return nil, &patchDecodeError{err: jsonpatch.ErrBadJSONPatch}
+	}
+
+	return jsonpatch.MergePatch(document, body)
+}
+
+// userDocument returns the JSON document patches are applied to,
+// the write-only password is present and empty so patches may set it
+func userDocument(usr *user.User) ([]byte, error) {
+	b, err := json.Marshal(usr)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields[passwordField] = ""
+
+	return json.Marshal(fields)
+}
+
+// changedFields returns the sorted top level fields whose value differs between the documents
+func changedFields(original, patched map[string]any) []string {
+	changed := []string{}
+	for field, value := range patched {
+		if previous, ok := original[field]; !ok || !reflect.DeepEqual(previous, value) {
+			changed = append(changed, field)
+		}
+	}
+	for field := range original {
+		if _, ok := patched[field]; !ok && field != passwordField {
+			changed = append(changed, field)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
@@ -0,0 +1,196 @@
+package userapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		caller      *user.User
+		contentType string
+		body        string
+		status      int
+		code        string
+		// check is asserted against the stored user when the patch succeeds
+		check func(t *testing.T, u *user.User)
+	}{
+		{
+			name:        "merge patch",
+			caller:      testOwner,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"nickName":"johnny","countryCode":"IE"}`,
+			status:      http.StatusOK,
+			check: func(t *testing.T, u *user.User) {
+				assert.Equal(t, "johnny", u.NickName)
+				assert.Equal(t, "IE", u.CountryCode)
+				assert.Equal(t, "John", u.FirstName)
+				assert.Equal(t, []byte("stored-hash"), u.Password, "the password is kept")
+			},
+		},
+		{
+			name:        "merge patch removing a field",
+			caller:      testOwner,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"nickName":null}`,
+			status:      http.StatusOK,
+			check: func(t *testing.T, u *user.User) {
+				assert.Empty(t, u.NickName)
+			},
+		},
+		{
+			name:        "json patch",
+			caller:      testOwner,
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			body:        `[{"op":"test","path":"/nickName","value":"jd"},{"op":"replace","path":"/nickName","value":"johnny"}]`,
+			status:      http.StatusOK,
+			check: func(t *testing.T, u *user.User) {
+				assert.Equal(t, "johnny", u.NickName)
+			},
+		},
+		{
+			name:        "json patch failing a test",
+			caller:      testOwner,
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			body:        `[{"op":"test","path":"/nickName","value":"someone"},{"op":"replace","path":"/nickName","value":"johnny"}]`,
+			status:      http.StatusUnprocessableEntity,
+			code:        api.CodePatchFailed,
+		},
+		{
+			name:        "malformed json patch",
+			caller:      testOwner,
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			body:        `{"nickName":"johnny"}`,
+			status:      http.StatusBadRequest,
+			code:        api.CodeMalformedRequest,
+		},
+		{
+			name:        "password",
+			caller:      testOwner,
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			body:        `[{"op":"replace","path":"/password","value":"new-secret"}]`,
+			status:      http.StatusOK,
+			check: func(t *testing.T, u *user.User) {
+				ok, err := user.NewDefaultPasswordHasher().Verify("new-secret", u.Password)
+				assert.NoError(t, err)
+				assert.True(t, ok, "the new password is hashed")
+			},
+		},
+		{
+			name:        "unchanged id",
+			caller:      testOwner,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"_id":"1234","nickName":"johnny"}`,
+			status:      http.StatusOK,
+		},
+		{
+			name:        "user granting admin",
+			caller:      testOwner,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"is_admin":true}`,
+			status:      http.StatusForbidden,
+			code:        api.CodeForbidden,
+		},
+		{
+			name:        "user changing saved",
+			caller:      testOwner,
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			body:        `[{"op":"replace","path":"/saved","value":"2020-01-01T00:00:00Z"}]`,
+			status:      http.StatusForbidden,
+			code:        api.CodeForbidden,
+		},
+		{
+			name:        "user soft deleting",
+			caller:      testOwner,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"deletedAt":"2020-01-01T00:00:00Z","deletedBy":"5678"}`,
+			status:      http.StatusBadRequest,
+			code:        api.CodeValidationFailed,
+		},
+		{
+			name:        "admin granting admin",
+			caller:      testAdmin,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"is_admin":true}`,
+			status:      http.StatusOK,
+			check: func(t *testing.T, u *user.User) {
+				assert.True(t, u.IsAdmin)
+			},
+		},
+		{
+			name:        "admin changing the id",
+			caller:      testAdmin,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"_id":"5678"}`,
+			status:      http.StatusBadRequest,
+			code:        api.CodeValidationFailed,
+		},
+		{
+			name:        "unknown field",
+			caller:      testOwner,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"nickname":"johnny"}`,
+			status:      http.StatusBadRequest,
+			code:        api.CodeValidationFailed,
+		},
+		{
+			name:        "invalid result",
+			caller:      testOwner,
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			body:        `{"firstName":null,"countryCode":"XX"}`,
+			status:      http.StatusBadRequest,
+			code:        api.CodeValidationFailed,
+		},
+		{
+			name:        "unsupported media type",
+			caller:      testOwner,
+			contentType: "text/plain",
+			body:        `nickName=johnny`,
+			status:      http.StatusUnsupportedMediaType,
+			code:        api.CodeUnsupportedMediaType,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stored := *storedUser
+			stored.Password = []byte("stored-hash")
+
+			var put *user.User
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&stored, nil)
+			repo.On("PutUser", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				put = args.Get(0).(*user.User)
+			}).Return(nil)
+
+			h := newTestHandler(t, repo, false)
+			r := newResourceRequest(t, h, http.MethodPatch, "1234", tc.body, tc.caller)
+			r.Header.Set("Content-Type", tc.contentType)
+
+			w := httptest.NewRecorder()
+			h.User(w, r)
+
+			assert.Equal(t, tc.status, w.Code, w.Body.String())
+			if tc.status != http.StatusOK {
+				var problem api.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				assert.Equal(t, tc.code, problem.Code)
+				repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+				return
+			}
+
+			assert.NotNil(t, put)
+			if tc.check != nil && put != nil {
+				tc.check(t, put)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package userapi
+
+import (
+	"fmt"
+
+	"github.com/jackmcguire1/UserService/dom/user"
+)
+
+var (
+	ForbiddenErr = fmt.Errorf("Forbidden")
+)
+
+// canRead reports whether the caller may fetch the user with the given id,
+// callers may only read their own record unless they are an administrator
+func canRead(claims *user.Claims, userID string) error {
+	if claims.IsAdmin || claims.Subject == userID {
+		return nil
+	}
+
+	return fmt.Errorf("%w - cannot read another user", ForbiddenErr)
+}
+
+// canUpdate reports whether the caller may write the given user,
+// callers may only update their own record and only administrators may grant admin rights
+func canUpdate(claims *user.Claims, usr *user.User) error {
+	if claims.IsAdmin {
+		return nil
+	}
+
+	if claims.Subject != usr.ID {
+		return fmt.Errorf("%w - cannot update another user", ForbiddenErr)
+	}
+
+	if usr.IsAdmin {
+		return fmt.Errorf("%w - only administrators may grant admin rights", ForbiddenErr)
+	}
+
+	return nil
+}
+
+// canDelete reports whether the caller may delete the user with the given id,
+// only administrators may delete other users
+func canDelete(claims *user.Claims, userID string) error {
+	if claims.IsAdmin || claims.Subject == userID {
+		return nil
+	}
+
+	return fmt.Errorf("%w - only administrators may delete other users", ForbiddenErr)
+}
+
+// canCreate reports whether the caller may create the requested account,
+// claims are nil for unauthenticated callers.
+// When public sign up is enabled anyone may create a non-admin account,
+// otherwise account creation is reserved for administrators
+func canCreate(claims *user.Claims, req *CreateUserRequest, allowPublicSignUp bool) error {
+	if claims != nil && claims.IsAdmin {
+		return nil
+	}
+
+	if !allowPublicSignUp {
+		return fmt.Errorf("%w - only administrators may create users", ForbiddenErr)
+	}
+
+	if req.IsAdmin {
+		return fmt.Errorf("%w - only administrators may grant admin rights", ForbiddenErr)
+	}
+
+	return nil
+}
+
+// canRestore reports whether the caller may restore deleted users, only administrators may
+func canRestore(claims *user.Claims) error {
+	if claims.IsAdmin {
+		return nil
+	}
+
+	return fmt.Errorf("%w - only administrators may restore users", ForbiddenErr)
+}
+
+// canErase reports whether the caller may erase users, only administrators may so erasure requests
+// are reviewed before the data is gone for good
+func canErase(claims *user.Claims) error {
+	if claims.IsAdmin {
+		return nil
+	}
+
+	return fmt.Errorf("%w - only administrators may erase users", ForbiddenErr)
+}
@@ -0,0 +1,197 @@
+package userapi
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/dom/privacy"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const zipContentType = "application/zip"
+
+// Export returns everything stored about the user (GET) at /v1/users/{id}/export, to the user themselves or an
+// administrator. The export is JSON unless '?format=zip' or 'Accept: application/zip' asks for an archive
+// of profile.json, audit.json and sessions.json
+func (h *UserHandler) Export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+	w.Header().Add("Access-Control-Expose-Headers", "Content-Disposition")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET")
+		return
+	}
+
+	claims, ok := h.authenticate(w, r, false)
+	if !ok {
+		return
+	}
+
+	userId := mux.Vars(r)["id"]
+
+	if err := canRead(claims, userId); err != nil {
+		h.Logger.
+			With("user-id", userId).
+			With("caller-id", claims.Subject).
+			With("error", err).
+			Warn("caller is not permitted to export user")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
+		return
+	}
+
+	asZip, err := exportFormat(r)
+	if err != nil {
+		api.WriteError(w, r, err)
+		return
+	}
+
+	export, err := h.PrivacyService.Export(callerContext(r, claims), userId)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			With("user-id", userId).
+			Error("failed to export user")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	h.Logger.
+		With("user-id", userId).
+		With("caller-id", claims.Subject).
+		Info("exported user")
+
+	w.Header().Set("Cache-Control", "no-store")
+	if !asZip {
+		b, _ := json.MarshalIndent(export, "", "\t")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%s.json"`, userId))
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+
+	w.Header().Set("Content-Type", zipContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%s.zip"`, userId))
+	w.WriteHeader(http.StatusOK)
+
+	err = writeExportZip(w, export)
+	if err != nil {
+		// the status is already sent, the client receives a truncated archive
+		h.Logger.
+			With("error", err).
+			With("user-id", userId).
+			Error("failed to write export archive")
+	}
+}
+
+// exportFormat reports whether the caller asked for a ZIP archive rather than JSON
+func exportFormat(r *http.Request) (bool, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "zip":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+		return strings.Contains(r.Header.Get("Accept"), zipContentType), nil
+	default:
+		errs := utils.ValidationErrors{}
+		errs.Add("format", "must be json or zip")
+		return false, errs
+	}
+}
+
+func writeExportZip(w http.ResponseWriter, export *privacy.Export) error {
+	archive := zip.NewWriter(w)
+
+	for name, v := range map[string]any{
+		"profile.json":  export.User,
+		"audit.json":    export.AuditEntries,
+		"sessions.json": export.Sessions,
+		"events.json":   export.Events,
+	} {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+
+		b, _ := json.MarshalIndent(v, "", "\t")
+		if _, err := f.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// Erase permanently removes the user (POST) at /v1/users/{id}/erase, scrubbing them from the audit log and
+// publishing an ERASED event, only administrators may erase users
+func (h *UserHandler) Erase(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,POST")
+		return
+	}
+
+	claims, ok := h.authenticate(w, r, false)
+	if !ok {
+		return
+	}
+
+	userId := mux.Vars(r)["id"]
+
+	if err := canErase(claims); err != nil {
+		h.Logger.
+			With("user-id", userId).
+			With("caller-id", claims.Subject).
+			With("error", err).
+			Warn("caller is not permitted to erase user")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
+		return
+	}
+
+	report, err := h.PrivacyService.Erase(callerContext(r, claims), userId)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			With("user-id", userId).
+			Error("failed to erase user")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	h.Logger.
+		With("user-id", userId).
+		With("caller-id", claims.Subject).
+		With("pseudonym", report.Pseudonym).
+		Info("erased user")
+
+	b, _ := json.MarshalIndent(report, "", "\t")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
@@ -0,0 +1,136 @@
+package userapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/privacy"
+	"github.com/jackmcguire1/UserService/dom/session"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPrivacyTestHandler(t *testing.T) *UserHandler {
+	users := user.NewMemoryRepo(nil)
+	stored := *storedUser
+	assert.NoError(t, users.PutUser(context.Background(), &stored, nil))
+
+	sessions := session.NewMemoryRepo()
+	now := time.Now().UTC()
+	assert.NoError(t, sessions.PutSession(context.Background(), &session.Session{ID: "1", UserID: "1234", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	auditService, err := audit.NewService(&audit.Resources{Repo: audit.NewMemoryRepo()})
+	assert.NoError(t, err)
+
+	svc, err := privacy.NewService(&privacy.Resources{Users: users, Sessions: sessions, Audit: auditService})
+	assert.NoError(t, err)
+
+	h := newTestHandler(t, &user.MockRepository{}, false)
+	h.PrivacyService = svc
+
+	return h
+}
+
+func TestExportUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *user.User
+		target string
+		accept string
+		status int
+		zip    bool
+	}{
+		{name: "owner", caller: testOwner, status: http.StatusOK},
+		{name: "admin", caller: testAdmin, status: http.StatusOK},
+		{name: "other user", caller: testOther, status: http.StatusForbidden},
+		{name: "zip format", caller: testOwner, target: "?format=zip", status: http.StatusOK, zip: true},
+		{name: "zip accepted", caller: testOwner, accept: "application/zip", status: http.StatusOK, zip: true},
+		{name: "unknown format", caller: testOwner, target: "?format=xml", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newPrivacyTestHandler(t)
+			r := newTestRequest(t, h, http.MethodGet, USERS_PATH+"/1234/export"+tc.target, "", tc.caller)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			h.Export(w, mux.SetURLVars(r, map[string]string{"id": "1234"}))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			if !tc.zip {
+				var export privacy.Export
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+				assert.Equal(t, "john@example.com", export.User.Email)
+				assert.Len(t, export.Sessions, 1)
+				assert.Equal(t, `attachment; filename="user-1234.json"`, w.Header().Get("Content-Disposition"))
+				return
+			}
+
+			assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+			archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			assert.NoError(t, err)
+
+			files := map[string]*zip.File{}
+			for _, f := range archive.File {
+				files[f.Name] = f
+			}
+			assert.Len(t, files, 4)
+			assert.Contains(t, files, "events.json")
+
+			profile, err := files["profile.json"].Open()
+			assert.NoError(t, err)
+			var exported user.User
+			assert.NoError(t, json.NewDecoder(profile).Decode(&exported))
+			assert.Equal(t, "1234", exported.ID)
+		})
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		caller *user.User
+		id     string
+		status int
+	}{
+		{name: "admin", method: http.MethodPost, caller: testAdmin, id: "1234", status: http.StatusOK},
+		{name: "owner", method: http.MethodPost, caller: testOwner, id: "1234", status: http.StatusForbidden},
+		{name: "unknown user", method: http.MethodPost, caller: testAdmin, id: "5678", status: http.StatusNotFound},
+		{name: "unsupported method", method: http.MethodDelete, caller: testAdmin, id: "1234", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newPrivacyTestHandler(t)
+			r := newTestRequest(t, h, tc.method, USERS_PATH+"/"+tc.id+"/erase", "", tc.caller)
+			w := httptest.NewRecorder()
+			h.Erase(w, mux.SetURLVars(r, map[string]string{"id": tc.id}))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var report privacy.ErasureReport
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			assert.True(t, report.UserDeleted)
+			assert.Equal(t, 1, report.SessionsDeleted)
+			assert.NotEmpty(t, report.Pseudonym)
+		})
+	}
+}
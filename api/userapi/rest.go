@@ -0,0 +1,226 @@
+package userapi
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	USERS_PATH = "/v1/users"
+
+	// DEPRECATION_HEADER flags responses of the legacy routes, see RFC 9745
+	DEPRECATION_HEADER = "Deprecation"
+)
+
+// Users creates a user (POST) at /v1/users, anonymously when public sign up is enabled,
+// the response carries the Location of the new user
+func (h *UserHandler) Users(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+	w.Header().Add("Access-Control-Expose-Headers", "Location,ETag")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,POST")
+		return
+	}
+
+	claims, ok := h.authenticate(w, r, true)
+	if !ok {
+		return
+	}
+
+	req, ok := h.decodeCreateUserRequest(w, r)
+	if !ok {
+		return
+	}
+
+	h.createUser(callerContext(r, claims), w, r, claims, req)
+
+	return
+}
+
+// User fetches (GET), replaces (PUT), partially updates (PATCH) and removes (DELETE)
+// the user at /v1/users/{id}, patches are merge patches or JSON patches, see patchUser
+func (h *UserHandler) User(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET,PUT,PATCH,DELETE")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept,If-Match,If-None-Match")
+	w.Header().Add("Access-Control-Expose-Headers", "ETag")
+	w.Header().Set("Accept-Patch", ACCEPT_PATCH)
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := h.authenticate(w, r, false)
+	if !ok {
+		return
+	}
+	ctx := callerContext(r, claims)
+
+	userId := mux.Vars(r)["id"]
+
+	switch r.Method {
+	case http.MethodGet:
+		usr, ok := h.loadUser(ctx, w, r, claims, userId)
+		if !ok {
+			return
+		}
+
+		h.writeCurrentUser(w, r, usr)
+
+	case http.MethodPut:
+		ctx, ok := h.expectVersion(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		usr, ok := h.decodeUser(w, r)
+		if !ok {
+			return
+		}
+
+		if usr.ID != "" && usr.ID != userId {
+			errs := utils.ValidationErrors{}
+			errs.Add("_id", "must match the user in the path")
+
+			api.WriteError(w, r, errs)
+			return
+		}
+		usr.ID = userId
+
+		// users are created with POST, PUT only replaces existing users
+		if _, ok := h.loadUser(ctx, w, r, claims, userId); !ok {
+			return
+		}
+
+		h.updateUser(ctx, w, r, claims, usr)
+
+	case http.MethodPatch:
+		h.patchUser(ctx, w, r, claims, userId)
+
+	case http.MethodDelete:
+		ctx, ok := h.expectVersion(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if !h.deleteUser(ctx, w, r, claims, userId) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET,PUT,PATCH,DELETE")
+	}
+
+	return
+}
+
+// Me returns the authenticated caller at /v1/users/me
+func (h *UserHandler) Me(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept,If-None-Match")
+	w.Header().Add("Access-Control-Expose-Headers", "ETag")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET")
+		return
+	}
+
+	claims, ok := h.authenticate(w, r, false)
+	if !ok {
+		return
+	}
+
+	usr, ok := h.loadUser(callerContext(r, claims), w, r, claims, claims.Subject)
+	if !ok {
+		return
+	}
+
+	h.writeCurrentUser(w, r, usr)
+
+	return
+}
+
+// Restore undoes the soft delete (POST) of the user at /v1/users/{id}/restore, administrators only
+func (h *UserHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept,If-Match")
+	w.Header().Add("Access-Control-Expose-Headers", "ETag")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,POST")
+		return
+	}
+
+	claims, ok := h.authenticate(w, r, false)
+	if !ok {
+		return
+	}
+
+	userId := mux.Vars(r)["id"]
+
+	if err := canRestore(claims); err != nil {
+		h.Logger.
+			With("user-id", userId).
+			With("caller-id", claims.Subject).
+			With("error", err).
+			Warn("caller is not permitted to restore user")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
+		return
+	}
+
+	ctx, ok := h.expectVersion(callerContext(r, claims), w, r)
+	if !ok {
+		return
+	}
+
+	usr, err := h.UserService.RestoreUser(ctx, userId)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			With("user-id", userId).
+			Error("failed to restore user")
+
+		api.WriteError(w, r, err)
+		return
+	}
+
+	h.Logger.
+		With("user-id", userId).
+		With("caller-id", claims.Subject).
+		Info("restored user")
+
+	h.writeUser(w, http.StatusOK, usr)
+
+	return
+}
@@ -0,0 +1,226 @@
+package userapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var storedUser = &user.User{
+	ID:          "1234",
+	FirstName:   "John",
+	LastName:    "Doe",
+	Email:       "john@example.com",
+	NickName:    "jd",
+	CountryCode: "GB",
+	Version:     1,
+}
+
+func newResourceRequest(t *testing.T, h *UserHandler, method, id, body string, caller *user.User) *http.Request {
+	r := newTestRequest(t, h, method, USERS_PATH+"/"+id, body, caller)
+
+	return mux.SetURLVars(r, map[string]string{"id": id})
+}
+
+func TestCreateUserReturnsLocation(t *testing.T) {
+	repo := &user.MockRepository{}
+	repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+
+	h := newTestHandler(t, repo, true)
+	w := httptest.NewRecorder()
+	body := `{"firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB","password":"secret"}`
+	h.Users(w, newTestRequest(t, h, http.MethodPost, USERS_PATH, body, nil))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created user.User
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, USERS_PATH+"/"+created.ID, w.Header().Get("Location"))
+	assert.Empty(t, w.Header().Get(DEPRECATION_HEADER))
+}
+
+func TestUserResource(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		caller *user.User
+		body   string
+		status int
+	}{
+		{name: "get as owner", method: http.MethodGet, caller: testOwner, status: http.StatusOK},
+		{name: "get as other user", method: http.MethodGet, caller: testOther, status: http.StatusForbidden},
+		{
+			name:   "replace as owner",
+			method: http.MethodPut,
+			caller: testOwner,
+			body:   `{"firstName":"Jane","lastName":"Doe","email":"jane@example.com","countryCode":"GB"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "replace with another id",
+			method: http.MethodPut,
+			caller: testAdmin,
+			body:   `{"_id":"5678","firstName":"Jane","lastName":"Doe","email":"jane@example.com","countryCode":"GB"}`,
+			status: http.StatusBadRequest,
+		},
+		{name: "patch as owner", method: http.MethodPatch, caller: testOwner, body: `{"nickName":"johnny"}`, status: http.StatusOK},
+		{name: "patch as other user", method: http.MethodPatch, caller: testOther, body: `{"nickName":"johnny"}`, status: http.StatusForbidden},
+		{name: "patch the id", method: http.MethodPatch, caller: testOwner, body: `{"_id":"5678"}`, status: http.StatusForbidden},
+		{name: "delete as owner", method: http.MethodDelete, caller: testOwner, status: http.StatusNoContent},
+		{name: "delete as other user", method: http.MethodDelete, caller: testOther, status: http.StatusForbidden},
+		{name: "unsupported method", method: http.MethodPost, caller: testAdmin, status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stored := *storedUser
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&stored, nil)
+			repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+			repo.On("DeleteUser", "1234", mock.Anything).Return(nil)
+
+			h := newTestHandler(t, repo, false)
+			w := httptest.NewRecorder()
+			h.User(w, newResourceRequest(t, h, tc.method, "1234", tc.body, tc.caller))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status >= http.StatusBadRequest {
+				repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+				repo.AssertNotCalled(t, "DeleteUser", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestPatchUserKeepsAbsentFields(t *testing.T) {
+	stored := *storedUser
+	repo := &user.MockRepository{}
+	repo.On("GetUser", "1234").Return(&stored, nil)
+	repo.On("PutUser", mock.MatchedBy(func(u *user.User) bool {
+		return u.NickName == "johnny" && u.FirstName == "John" && u.Email == "john@example.com"
+	}), mock.Anything).Return(nil)
+
+	h := newTestHandler(t, repo, false)
+	w := httptest.NewRecorder()
+	h.User(w, newResourceRequest(t, h, http.MethodPatch, "1234", `{"nickName":"johnny"}`, testOwner))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	repo.AssertNumberOfCalls(t, "PutUser", 1)
+}
+
+func TestReplaceUnknownUser(t *testing.T) {
+	repo := &user.MockRepository{}
+	repo.On("GetUser", "1234").Return(nil, utils.ErrNotFound)
+
+	h := newTestHandler(t, repo, false)
+	w := httptest.NewRecorder()
+	body := `{"firstName":"Jane","lastName":"Doe","email":"jane@example.com","countryCode":"GB"}`
+	h.User(w, newResourceRequest(t, h, http.MethodPut, "1234", body, testAdmin))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+}
+
+func TestMe(t *testing.T) {
+	stored := *storedUser
+	repo := &user.MockRepository{}
+	repo.On("GetUser", "1234").Return(&stored, nil)
+
+	h := newTestHandler(t, repo, false)
+	w := httptest.NewRecorder()
+	h.Me(w, newTestRequest(t, h, http.MethodGet, USERS_PATH+"/me", "", testOwner))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var me user.User
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, "1234", me.ID)
+
+	w = httptest.NewRecorder()
+	h.Me(w, newTestRequest(t, h, http.MethodGet, USERS_PATH+"/me", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLegacyRoutesAreDeprecated(t *testing.T) {
+	stored := *storedUser
+	repo := &user.MockRepository{}
+	repo.On("GetUser", "1234").Return(&stored, nil)
+
+	h := newTestHandler(t, repo, false)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTestRequest(t, h, http.MethodGet, "/users?id=1234", "", testOwner))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get(DEPRECATION_HEADER))
+	assert.Equal(t, `</v1/users>; rel="successor-version"`, w.Header().Get("Link"))
+}
+
+func TestRestoreUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		caller *user.User
+		status int
+	}{
+		{name: "admin", method: http.MethodPost, caller: testAdmin, status: http.StatusOK},
+		{name: "owner", method: http.MethodPost, caller: testOwner, status: http.StatusForbidden},
+		{name: "unsupported method", method: http.MethodGet, caller: testAdmin, status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			deleted := *storedUser
+			deleted.Version = 2
+			deleted.DeletedAt = "2024-01-01T00:00:00Z"
+			deleted.DeletedBy = "admin"
+
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&deleted, nil)
+			repo.On("PutUser", mock.MatchedBy(func(u *user.User) bool {
+				return !u.Deleted() && u.Version == 3
+			}), mock.MatchedBy(func(event *user.UserUpdate) bool {
+				return event.Status == user.EventRestored && event.ActorID == testAdmin.ID
+			})).Return(nil)
+
+			h := newTestHandler(t, repo, false)
+			r := newTestRequest(t, h, tc.method, USERS_PATH+"/1234/restore", "", tc.caller)
+			w := httptest.NewRecorder()
+			h.Restore(w, mux.SetURLVars(r, map[string]string{"id": "1234"}))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusOK {
+				repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+				return
+			}
+
+			var restored user.User
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &restored))
+			assert.Empty(t, restored.DeletedAt)
+			assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+		})
+	}
+}
+
+func TestDeletedUsersAreNotFound(t *testing.T) {
+	deleted := *storedUser
+	deleted.DeletedAt = "2024-01-01T00:00:00Z"
+
+	repo := &user.MockRepository{}
+	repo.On("GetUser", "1234").Return(&deleted, nil)
+
+	h := newTestHandler(t, repo, false)
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		h.User(w, newResourceRequest(t, h, method, "1234", "", testAdmin))
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+	repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+}
@@ -1,15 +1,18 @@
 package userapi
 
 import (
-	"crypto/sha256"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 
 	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auth"
 	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/redact"
 	"github.com/jackmcguire1/UserService/pkg/utils"
 )
 
@@ -25,340 +28,380 @@ type CreateUserRequest struct {
 	IsAdmin     bool   `json:"isAdmin"`
 }
 
+// LogValue tags the personal fields of the request, the password is never logged
+func (req *CreateUserRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("_id", redact.Tag(redact.ClassID, req.ID)),
+		slog.Any("firstName", redact.Tag(redact.ClassName, req.FirstName)),
+		slog.Any("lastName", redact.Tag(redact.ClassName, req.LastName)),
+		slog.Any("email", redact.Tag(redact.ClassEmail, req.Email)),
+		slog.Any("nickName", redact.Tag(redact.ClassName, req.NickName)),
+		slog.String("countryCode", req.CountryCode),
+		slog.Bool("isAdmin", req.IsAdmin),
+	)
+}
+
+// ServeHTTP serves the legacy '/users?id=' routes where PUT creates and POST updates users,
+// responses are flagged as deprecated in favour of the /v1/users resource routes
 func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET,POST,PUT,DELETE")
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET,POST,PUT,PATCH,DELETE")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept,If-Match,If-None-Match")
+	w.Header().Add("Access-Control-Expose-Headers", "ETag")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	w.Header().Set(DEPRECATION_HEADER, "true")
+	w.Header().Set("Link", `<`+USERS_PATH+`>; rel="successor-version"`)
+
 	h.Logger.
-		With("raw-request", r).
+		With("http-method", r.Method).
+		With("path", r.URL.Path).
 		Debug("got new request")
 
+	// account creation may be performed anonymously when public sign up is enabled
+	claims, ok := h.authenticate(w, r, r.Method == http.MethodPut)
+	if !ok {
+		return
+	}
+	ctx := callerContext(r, claims)
+
 	switch r.Method {
 	case http.MethodGet:
+		userId, ok := h.idParameter(w, r)
+		if !ok {
+			return
+		}
 
-		userParams, ok := r.URL.Query()["id"]
-		if !ok || len(userParams[0]) < 1 {
-			h.Logger.
-				With("values", r.URL.Query()).
-				Error("request does not contain 'id' query parameter")
+		usr, ok := h.loadUser(ctx, w, r, claims, userId)
+		if !ok {
+			return
+		}
 
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: "missing 'id'  query parameter"}))
+		h.writeCurrentUser(w, r, usr)
 
+		return
+
+	case http.MethodPost:
+		ctx, ok := h.expectVersion(ctx, w, r)
+		if !ok {
 			return
 		}
-		userId := userParams[0]
 
-		userResponse, err := h.getUser(userId)
-		if err != nil {
-			if errors.Is(err, utils.ErrNotFound) {
-				h.Logger.
-					With("user-id", userId).
-					With("error", err).
-					Warn("user does not exist ")
-
-				w.WriteHeader(http.StatusNotFound)
-				w.Write(utils.ToRAWJSON(api.HTTPError{Error: "user not found"}))
+		usr, ok := h.decodeUser(w, r)
+		if !ok {
+			return
+		}
 
-				return
-			}
+		if usr.ID == "" {
+			errs := utils.ValidationErrors{}
+			errs.Add("_id", "is required")
 
 			h.Logger.
-				With("user-id", userId).
-				With("error", err).
-				Error("failed to get user")
+				With("error", errs).
+				With("user", usr).
+				Error("failed to update user")
 
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+			api.WriteError(w, r, errs)
 
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write(userResponse)
+		h.updateUser(ctx, w, r, claims, usr)
 
 		return
+	case http.MethodPatch:
+		userId, ok := h.idParameter(w, r)
+		if !ok {
+			return
+		}
 
-	case http.MethodPost:
-		reqData, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			h.Logger.
-				With("error", err).
-				Error("failed to get read data from request body")
-
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+		h.patchUser(ctx, w, r, claims, userId)
 
+		return
+	case http.MethodPut:
+		req, ok := h.decodeCreateUserRequest(w, r)
+		if !ok {
 			return
 		}
 
-		h.Logger.
-			With("raw-body", string(reqData)).
-			Info("got body from request")
+		h.createUser(ctx, w, r, claims, req)
 
-		var user *user.User
-		err = json.Unmarshal(reqData, &user)
-		if err != nil {
-			h.Logger.
-				With("body", string(reqData)).
-				Error("failed to get user data from request body")
+		return
 
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+	case http.MethodDelete:
+		type DeleteResponse struct {
+			Deleted bool   `json:"deleted"`
+			Message string `json:"message"`
+		}
 
+		userId, ok := h.idParameter(w, r)
+		if !ok {
 			return
 		}
 
-		if user.ID == "" {
-			err := fmt.Errorf("missing user id")
-
-			h.Logger.
-				With("error", err).
-				With("user", user).
-				Error("failed to update user")
-
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
-
+		ctx, ok := h.expectVersion(ctx, w, r)
+		if !ok {
 			return
 		}
 
-		userResponse, err := h.UpdateUser(user)
-		if err != nil {
-			if errors.Is(err, utils.ValidationErr) {
-				h.Logger.
-					With("error", err).
-					With("user", user).
-					Error("failed to update user")
+		if !h.deleteUser(ctx, w, r, claims, userId) {
+			return
+		}
 
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+		resp := utils.ToRAWJSON(&DeleteResponse{
+			Deleted: true,
+			Message: "success",
+		})
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
 
-				return
-			}
+		h.Logger.
+			With("response", string(resp)).
+			With("user-id", userId).
+			Debug("deleted user successfully")
+		return
 
-			if errors.Is(err, utils.AlreadyExists) {
-				h.Logger.
-					With("error", err).
-					With("user", user).
-					Error("failed to update user because of conflict")
+	default:
+		err := fmt.Errorf("unsupported HTTP method")
+		h.Logger.
+			With("error", err).
+			With("http-method", r.Method).
+			Error("unsupported HTTP method requested")
 
-				w.WriteHeader(http.StatusConflict)
-				w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET,POST,PUT,PATCH,DELETE")
+	}
 
-				return
-			}
+	return
+}
 
-			h.Logger.
-				With("error", err).
-				With("user", user).
-				Error("failed to update user")
+// authenticate validates the caller's token, writing the problem and returning false when it is invalid.
+// When anonymous is set requests without a token are let through with nil claims
+func (h *UserHandler) authenticate(w http.ResponseWriter, r *http.Request, anonymous bool) (*user.Claims, bool) {
+	if anonymous && r.Header.Get(auth.AUTH_HEADER) == "" {
+		return nil, true
+	}
 
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+	claims, err := h.AuthHandler.ValidateRequest(r)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			With("http-method", r.Method).
+			Error("unauthenticated request")
 
-			return
-		}
+		api.WriteError(w, r, err)
+		return nil, false
+	}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write(userResponse)
+	return claims, true
+}
 
-		return
-	case http.MethodPut:
-		var user *CreateUserRequest
-		err := json.NewDecoder(r.Body).Decode(&user)
-		if err != nil {
-			h.Logger.
-				With("error", err).
-				Error("failed to unmarshal user data from request body")
+// callerContext attaches the authenticated caller to the request context
+func callerContext(r *http.Request, claims *user.Claims) context.Context {
+	ctx := r.Context()
+	if claims != nil {
+		ctx = utils.WithCallerID(ctx, claims.Subject)
+		ctx = utils.WithCallerIsAdmin(ctx, claims.IsAdmin)
+	}
 
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+	return ctx
+}
 
-			return
-		}
+func (h *UserHandler) idParameter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userParams, ok := r.URL.Query()["id"]
+	if !ok || len(userParams[0]) < 1 {
+		h.Logger.
+			With("values", r.URL.Query()).
+			Error("request does not contain 'id' query parameter")
 
-		userResponse, err := h.createUser(user)
-		if err != nil {
-			if errors.Is(err, utils.AlreadyExists) {
-				h.Logger.
-					With("error", err).
-					With("user", user).
-					Warn("failed to create user")
+		api.WriteProblem(w, api.MissingParameter(r, "id"))
 
-				w.WriteHeader(http.StatusConflict)
-				w.Write(utils.ToRAWJSON(api.HTTPError{Error: "user already exists"}))
+		return "", false
+	}
 
-				return
-			}
+	return userParams[0], true
+}
 
-			if errors.Is(err, utils.ValidationErr) {
-				h.Logger.
-					With("error", err).
-					With("user", user).
-					Error("failed to update user")
+// decodeUser reads the user in the request body
+func (h *UserHandler) decodeUser(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	reqData, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			Error("failed to get read data from request body")
 
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+		api.WriteError(w, r, err)
 
-				return
-			}
+		return nil, false
+	}
 
-			h.Logger.
-				With("error", err).
-				With("user", user).
-				Error("failed to create user")
+	var usr *user.User
+	err = json.Unmarshal(reqData, &usr)
+	if err == nil && usr == nil {
+		err = fmt.Errorf("missing user")
+	}
+	if err != nil {
+		h.Logger.
+			With("error", err).
+			With("body-size", len(reqData)).
+			Error("failed to get user data from request body")
 
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+		api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, "request body is not a valid user: "+err.Error()))
 
-			return
-		}
+		return nil, false
+	}
 
-		w.WriteHeader(http.StatusCreated)
-		w.Write(userResponse)
+	return usr, true
+}
 
+func (h *UserHandler) decodeCreateUserRequest(w http.ResponseWriter, r *http.Request) (*CreateUserRequest, bool) {
+	var req *CreateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil && req == nil {
+		err = fmt.Errorf("missing user")
+	}
+	if err != nil {
 		h.Logger.
-			With("response", string(userResponse)).
-			With("user-id", user.ID).
-			Debug("returning user")
-		return
-
-	case http.MethodDelete:
-		type DeleteResponse struct {
-			Deleted bool   `json:"deleted"`
-			Message string `json:"message"`
-		}
+			With("error", err).
+			Error("failed to unmarshal user data from request body")
 
-		userParams, ok := r.URL.Query()["id"]
-		if !ok || len(userParams[0]) < 1 {
-			h.Logger.
-				With("url-values", r.URL.Query()).
-				Error("request does not contain 'id' query parameter")
+		api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, "request body is not a valid user: "+err.Error()))
 
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: "missing 'id'  query parameter"}))
+		return nil, false
+	}
 
-			return
-		}
-		userId := userParams[0]
+	return req, true
+}
 
+// loadUser fetches the user the caller may read, writing the problem and returning false otherwise
+func (h *UserHandler) loadUser(ctx context.Context, w http.ResponseWriter, r *http.Request, claims *user.Claims, userId string) (*user.User, bool) {
+	if err := canRead(claims, userId); err != nil {
 		h.Logger.
 			With("user-id", userId).
-			Info("got user to delete")
-
-		err := h.UserService.DeleteUser(userId)
-		if err != nil {
+			With("caller-id", claims.Subject).
+			With("error", err).
+			Warn("caller is not permitted to read user")
 
-			if errors.Is(err, utils.ErrNotFound) {
-				h.Logger.
-					With("error", err).
-					With("user-id", userId).
-					Warn("user does not exist")
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
 
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write(utils.ToRAWJSON(api.HTTPError{Error: "user does not exist"}))
+		return nil, false
+	}
 
-				return
-			}
+	logEntry := utils.ContextLogger(ctx, h.Logger).With("user-id", userId)
+	logEntry.Info("call getUser - API")
 
+	usr, err := h.UserService.GetUser(ctx, userId)
+	if err != nil {
+		if errors.Is(err, utils.ErrNotFound) {
 			h.Logger.
-				With("error", err).
 				With("user-id", userId).
-				Error("failed to delete user")
+				With("error", err).
+				Warn("user does not exist ")
 
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(utils.ToRAWJSON(api.HTTPError{Error: err.Error()}))
+			api.WriteProblem(w, api.NewProblem(r, http.StatusNotFound, api.CodeNotFound, "user not found"))
 
-			return
+			return nil, false
 		}
 
 		h.Logger.
 			With("user-id", userId).
-			Debug("deleted user successfully")
+			With("error", err).
+			Error("failed to get user")
 
-		resp := utils.ToRAWJSON(&DeleteResponse{
-			Deleted: true,
-			Message: "success",
-		})
-		w.WriteHeader(http.StatusOK)
-		w.Write(resp)
+		api.WriteError(w, r, err)
+
+		return nil, false
+	}
 
+	return usr, true
+}
+
+// updateUser writes the user on behalf of the caller and responds with the stored user
+func (h *UserHandler) updateUser(ctx context.Context, w http.ResponseWriter, r *http.Request, claims *user.Claims, usr *user.User) {
+	if err := canUpdate(claims, usr); err != nil {
 		h.Logger.
-			With("response", string(resp)).
-			With("user-id", userId).
-			Debug("deleted user successfully")
+			With("user-id", usr.ID).
+			With("caller-id", claims.Subject).
+			With("error", err).
+			Warn("caller is not permitted to update user")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
+
 		return
+	}
 
-	default:
-		err := fmt.Errorf("unsupported HTTP method")
+	logEntry := utils.ContextLogger(ctx, h.Logger).With("user", usr)
+	logEntry.Info("call UpdateUser - API")
+
+	updated, err := h.UserService.PutUser(ctx, usr)
+	if err != nil {
 		h.Logger.
 			With("error", err).
-			With("http-method", r.Method).
-			Error("unsupported HTTP method requested")
+			With("user", usr).
+			Error("failed to update user")
 
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(utils.ToRAWJSON(api.HTTPError{Error: "unsupported HTTP METHOD"}))
+		api.WriteError(w, r, err)
+
+		return
 	}
 
-	return
+	h.writeUser(w, http.StatusOK, updated)
 }
 
-func (h *UserHandler) getUser(userId string) ([]byte, error) {
-	logEntry := h.Logger.With("user-id", userId)
-	logEntry.Info("call getUser - API")
+// createUser creates the requested account on behalf of the caller, claims are nil for anonymous callers,
+// and responds with the new user and its location
+func (h *UserHandler) createUser(ctx context.Context, w http.ResponseWriter, r *http.Request, claims *user.Claims, req *CreateUserRequest) {
+	if err := canCreate(claims, req, h.AllowPublicSignUp); err != nil {
+		h.Logger.
+			With("error", err).
+			Warn("caller is not permitted to create user")
 
-	usr, err := h.UserService.GetUser(userId)
-	if err != nil {
-		return nil, err
+		if claims == nil {
+			api.WriteProblem(w, api.NewProblem(r, http.StatusUnauthorized, api.CodeUnauthorized, err.Error()))
+		} else {
+			api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
+		}
+
+		return
 	}
 
-	b, err := json.MarshalIndent(usr, "", "\t")
+	newUser, err := h.newUser(ctx, req)
 	if err != nil {
-		return nil, err
-	}
+		if errors.Is(err, utils.AlreadyExists) {
+			h.Logger.
+				With("error", err).
+				With("user", req).
+				Warn("failed to create user")
 
-	h.Logger.
-		With("user", string(b)).
-		Info("got user")
+			api.WriteProblem(w, api.NewProblem(r, http.StatusConflict, api.CodeAlreadyExists, "user already exists"))
 
-	return b, err
-}
+			return
+		}
 
-func (h *UserHandler) UpdateUser(usr *user.User) ([]byte, error) {
-	logEntry := h.Logger.With("user", usr)
-	logEntry.Info("call UpdateUser - API")
+		h.Logger.
+			With("error", err).
+			With("user", req).
+			Error("failed to create user")
 
-	usr, err := h.UserService.PutUser(usr)
-	if err != nil {
-		return nil, err
-	}
+		api.WriteError(w, r, err)
 
-	b, err := json.MarshalIndent(usr, "", "\t")
-	if err != nil {
-		return nil, err
+		return
 	}
 
-	h.Logger.
-		With("user", string(b)).
-		Info("got updated user")
-
-	return b, err
+	w.Header().Set("Location", USERS_PATH+"/"+newUser.ID)
+	h.writeUser(w, http.StatusCreated, newUser)
 }
 
-func (h *UserHandler) createUser(usr *CreateUserRequest) ([]byte, error) {
-	logEntry := h.Logger.With("user", usr)
+func (h *UserHandler) newUser(ctx context.Context, usr *CreateUserRequest) (*user.User, error) {
+	logEntry := utils.ContextLogger(ctx, h.Logger).With("user", usr)
 	logEntry.Info("call createUser - API")
 
 	if usr.ID != "" {
-		existingUser, err := h.UserService.GetUser(usr.ID)
+		existingUser, err := h.UserService.GetUser(ctx, usr.ID)
 		if err != nil && !errors.Is(err, utils.ErrNotFound) {
 			return nil, err
 		}
@@ -368,11 +411,12 @@ func (h *UserHandler) createUser(usr *CreateUserRequest) ([]byte, error) {
 		}
 	}
 
-	sha := sha256.New()
-	sha.Write([]byte(usr.Password))
-	password := sha.Sum(nil)
+	password, err := h.UserService.HashPassword(usr.Password)
+	if err != nil {
+		return nil, err
+	}
 
-	newUser, err := h.UserService.PutUser(&user.User{
+	return h.UserService.PutUser(ctx, &user.User{
 		ID:          usr.ID,
 		FirstName:   usr.FirstName,
 		LastName:    usr.LastName,
@@ -382,18 +426,76 @@ func (h *UserHandler) createUser(usr *CreateUserRequest) ([]byte, error) {
 		Password:    password,
 		IsAdmin:     usr.IsAdmin,
 	})
-	if err != nil {
-		return nil, err
+}
+
+// deleteUser removes the user the caller may delete and revokes their sessions,
+// the problem is written and false returned when the user was not deleted
+func (h *UserHandler) deleteUser(ctx context.Context, w http.ResponseWriter, r *http.Request, claims *user.Claims, userId string) bool {
+	if err := canDelete(claims, userId); err != nil {
+		h.Logger.
+			With("user-id", userId).
+			With("caller-id", claims.Subject).
+			With("error", err).
+			Warn("caller is not permitted to delete user")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, err.Error()))
+
+		return false
 	}
 
-	b, err := json.MarshalIndent(newUser, "", "\t")
+	h.Logger.
+		With("user-id", userId).
+		Info("got user to delete")
+
+	err := h.UserService.DeleteUser(ctx, userId)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, utils.ErrNotFound) {
+			h.Logger.
+				With("error", err).
+				With("user-id", userId).
+				Warn("user does not exist")
+
+			api.WriteProblem(w, api.NewProblem(r, http.StatusNotFound, api.CodeNotFound, "user does not exist"))
+
+			return false
+		}
+
+		h.Logger.
+			With("error", err).
+			With("user-id", userId).
+			Error("failed to delete user")
+
+		api.WriteError(w, r, err)
+
+		return false
+	}
+
+	if h.AuthHandler.Sessions != nil {
+		err = h.AuthHandler.RevokeUserSessions(ctx, userId)
+		if err != nil {
+			h.Logger.
+				With("error", err).
+				With("user-id", userId).
+				Error("failed to revoke sessions of deleted user")
+		}
 	}
 
 	h.Logger.
-		With("user", string(b)).
-		Info("got new user")
+		With("user-id", userId).
+		Debug("deleted user successfully")
+
+	return true
+}
 
-	return b, err
+// writeUser responds with the user and its entity tag
+func (h *UserHandler) writeUser(w http.ResponseWriter, status int, usr *user.User) {
+	b, _ := json.MarshalIndent(usr, "", "\t")
+
+	w.Header().Set("ETag", entityTag(usr))
+	w.WriteHeader(status)
+	w.Write(b)
+
+	h.Logger.
+		With("user", usr).
+		Debug("returning user")
 }
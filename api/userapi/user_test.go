@@ -0,0 +1,261 @@
+package userapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestHandler(t *testing.T, repo *user.MockRepository, allowPublicSignUp bool) *UserHandler {
+	svc, err := user.NewService(&user.Resources{Repo: repo})
+	assert.NoError(t, err)
+
+	return &UserHandler{
+		UserService:       svc,
+		Logger:            slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})),
+		AuthHandler:       &auth.Handler{JWTSecret: []byte("1234"), Expiry: time.Minute},
+		AllowPublicSignUp: allowPublicSignUp,
+	}
+}
+
+func newTestRequest(t *testing.T, h *UserHandler, method, target, body string, caller *user.User) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	if caller != nil {
+		token, err := h.AuthHandler.SignClaims(caller)
+		assert.NoError(t, err)
+		r.Header.Set(auth.AUTH_HEADER, "Bearer "+token)
+	}
+
+	return r
+}
+
+var (
+	testAdmin = &user.User{ID: "admin", IsAdmin: true}
+	testOwner = &user.User{ID: "1234"}
+	testOther = &user.User{ID: "5678"}
+)
+
+func TestGetUserAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *user.User
+		status int
+	}{
+		{name: "anonymous", caller: nil, status: http.StatusUnauthorized},
+		{name: "owner", caller: testOwner, status: http.StatusOK},
+		{name: "other user", caller: testOther, status: http.StatusForbidden},
+		{name: "admin", caller: testAdmin, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&user.User{ID: "1234", FirstName: "John"}, nil)
+
+			h := newTestHandler(t, repo, false)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newTestRequest(t, h, http.MethodGet, "/users?id=1234", "", tc.caller))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusOK {
+				repo.AssertNotCalled(t, "GetUser", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestUpdateUserAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *user.User
+		body   string
+		status int
+	}{
+		{
+			name:   "anonymous",
+			caller: nil,
+			body:   `{"_id":"1234","firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB"}`,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "owner",
+			caller: testOwner,
+			body:   `{"_id":"1234","firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "owner granting admin",
+			caller: testOwner,
+			body:   `{"_id":"1234","firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB","is_admin":true}`,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "other user",
+			caller: testOther,
+			body:   `{"_id":"1234","firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB"}`,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "admin granting admin",
+			caller: testAdmin,
+			body:   `{"_id":"1234","firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB","is_admin":true}`,
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&user.User{ID: "1234", FirstName: "John"}, nil)
+			repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+
+			h := newTestHandler(t, repo, false)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newTestRequest(t, h, http.MethodPost, "/users", tc.body, tc.caller))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusOK {
+				repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestCreateUserAuthorization(t *testing.T) {
+	const (
+		body      = `{"firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB","password":"secret"}`
+		adminBody = `{"firstName":"John","lastName":"Doe","email":"john@example.com","countryCode":"GB","password":"secret","isAdmin":true}`
+	)
+
+	tests := []struct {
+		name              string
+		caller            *user.User
+		body              string
+		allowPublicSignUp bool
+		status            int
+	}{
+		{name: "anonymous with public sign up", caller: nil, body: body, allowPublicSignUp: true, status: http.StatusCreated},
+		{name: "anonymous admin with public sign up", caller: nil, body: adminBody, allowPublicSignUp: true, status: http.StatusUnauthorized},
+		{name: "anonymous without public sign up", caller: nil, body: body, allowPublicSignUp: false, status: http.StatusUnauthorized},
+		{name: "user without public sign up", caller: testOther, body: body, allowPublicSignUp: false, status: http.StatusForbidden},
+		{name: "user creating admin with public sign up", caller: testOther, body: adminBody, allowPublicSignUp: true, status: http.StatusForbidden},
+		{name: "admin without public sign up", caller: testAdmin, body: adminBody, allowPublicSignUp: false, status: http.StatusCreated},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &user.MockRepository{}
+			repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+
+			h := newTestHandler(t, repo, tc.allowPublicSignUp)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newTestRequest(t, h, http.MethodPut, "/users", tc.body, tc.caller))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusCreated {
+				repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestCreateUserInvalidToken(t *testing.T) {
+	repo := &user.MockRepository{}
+
+	h := newTestHandler(t, repo, true)
+	r := newTestRequest(t, h, http.MethodPut, "/users", `{}`, nil)
+	r.Header.Set(auth.AUTH_HEADER, "Bearer invalid")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+}
+
+func TestDeleteUserAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *user.User
+		status int
+	}{
+		{name: "anonymous", caller: nil, status: http.StatusUnauthorized},
+		{name: "owner", caller: testOwner, status: http.StatusOK},
+		{name: "other user", caller: testOther, status: http.StatusForbidden},
+		{name: "admin", caller: testAdmin, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &user.MockRepository{}
+			repo.On("GetUser", "1234").Return(&user.User{ID: "1234"}, nil)
+			repo.On("PutUser", mock.Anything, mock.Anything).Return(nil)
+
+			h := newTestHandler(t, repo, false)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newTestRequest(t, h, http.MethodDelete, "/users?id=1234", "", tc.caller))
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusOK {
+				repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestCreateUserValidationErrors(t *testing.T) {
+	repo := &user.MockRepository{}
+
+	h := newTestHandler(t, repo, true)
+	w := httptest.NewRecorder()
+	body := `{"firstName":"John3","lastName":"","email":"John <john@example.com>","countryCode":"UK","password":"secret"}`
+	h.ServeHTTP(w, newTestRequest(t, h, http.MethodPut, "/users", body, nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, api.PROBLEM_CONTENT_TYPE, w.Header().Get("Content-Type"))
+
+	var resp api.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, api.CodeValidationFailed, resp.Code)
+
+	fields := []string{}
+	for _, fieldErr := range resp.Errors {
+		fields = append(fields, fieldErr.Field)
+	}
+	assert.Equal(t, []string{"countryCode", "firstName", "lastName", "email"}, fields, "every invalid field is reported")
+	repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+}
+
+func TestUpdateUserMalformedBody(t *testing.T) {
+	repo := &user.MockRepository{}
+
+	h := newTestHandler(t, repo, false)
+	r := newTestRequest(t, h, http.MethodPost, "/users", `{"_id":`, testOwner)
+	r = r.WithContext(utils.WithRequestID(r.Context(), "req-1"))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, api.PROBLEM_CONTENT_TYPE, w.Header().Get("Content-Type"))
+
+	var resp api.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), "the decode error is wrapped in a problem")
+	assert.Equal(t, api.CodeMalformedRequest, resp.Code)
+	assert.Equal(t, "req-1", resp.RequestID)
+	repo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,300 @@
+package webhookapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/dom/webhook"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const defaultDeliveriesLimit = 50
+
+type WebhookHandler struct {
+	WebhookService webhook.WebhookService
+	AuthHandler    *auth.Handler
+	Logger         *slog.Logger
+}
+
+type SubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// Subscriptions lists (GET) and registers (POST) webhook subscriptions
+func (h *WebhookHandler) Subscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		type SubscriptionsResponse struct {
+			Subscriptions []*webhook.Subscription `json:"subscriptions"`
+		}
+
+		subs, err := h.WebhookService.GetSubscriptions()
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		data, _ := json.MarshalIndent(&SubscriptionsResponse{Subscriptions: subs}, "", "\t")
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+
+	case http.MethodPost:
+		var req *SubscriptionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			h.Logger.
+				With("error", err).
+				Error("failed to unmarshal subscription from request body")
+
+			api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, "request body is not a valid subscription: "+err.Error()))
+			return
+		}
+
+		sub, err := h.WebhookService.CreateSubscription(&webhook.Subscription{
+			URL:       req.URL,
+			Events:    req.Events,
+			CreatedBy: claims.Subject,
+		})
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		h.Logger.
+			With("subscription-id", sub.ID).
+			With("url", sub.URL).
+			Info("created webhook subscription")
+
+		data, _ := json.MarshalIndent(sub, "", "\t")
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+
+	default:
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET,POST")
+	}
+
+	return
+}
+
+// Subscription fetches (GET), updates (PUT) and removes (DELETE) a single webhook subscription
+func (h *WebhookHandler) Subscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET,PUT,DELETE")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if _, ok := h.authorize(w, r); !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	switch r.Method {
+	case http.MethodGet:
+		sub, err := h.WebhookService.GetSubscription(id)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		data, _ := json.MarshalIndent(sub, "", "\t")
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+
+	case http.MethodPut:
+		var req *SubscriptionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			h.Logger.
+				With("error", err).
+				Error("failed to unmarshal subscription from request body")
+
+			api.WriteProblem(w, api.NewProblem(r, http.StatusBadRequest, api.CodeMalformedRequest, "request body is not a valid subscription: "+err.Error()))
+			return
+		}
+
+		active := true
+		if req.Active != nil {
+			active = *req.Active
+		}
+
+		sub, err := h.WebhookService.UpdateSubscription(&webhook.Subscription{
+			ID:     id,
+			URL:    req.URL,
+			Events: req.Events,
+			Active: active,
+		})
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		data, _ := json.MarshalIndent(sub, "", "\t")
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+
+	case http.MethodDelete:
+		err := h.WebhookService.DeleteSubscription(id)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		h.Logger.
+			With("subscription-id", id).
+			Info("deleted webhook subscription")
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		api.WriteMethodNotAllowed(w, r, "OPTIONS,GET,PUT,DELETE")
+	}
+
+	return
+}
+
+// Deliveries returns the delivery log of a subscription, newest first
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,GET")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if _, ok := h.authorize(w, r); !ok {
+		return
+	}
+
+	type DeliveriesResponse struct {
+		Deliveries []*webhook.Delivery `json:"deliveries"`
+	}
+
+	limit := defaultDeliveriesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			errs := utils.ValidationErrors{}
+			errs.Add("limit", "must be a positive integer")
+
+			api.WriteError(w, r, errs)
+			return
+		}
+	}
+
+	deliveries, err := h.WebhookService.GetDeliveries(mux.Vars(r)["id"], limit)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	data, _ := json.MarshalIndent(&DeliveriesResponse{Deliveries: deliveries}, "", "\t")
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+
+	return
+}
+
+// SendTestEvent delivers a signed TEST event to the subscription and returns the delivery log entry
+func (h *WebhookHandler) SendTestEvent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Methods", "OPTIONS,POST")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type,Authorization,X-Requested-With,Origin,Accept")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if _, ok := h.authorize(w, r); !ok {
+		return
+	}
+
+	delivery, err := h.WebhookService.SendTestEvent(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	data, _ := json.MarshalIndent(delivery, "", "\t")
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+
+	return
+}
+
+// authorize writes the error response and returns false unless the caller is an administrator
+func (h *WebhookHandler) authorize(w http.ResponseWriter, r *http.Request) (*user.Claims, bool) {
+	claims, err := h.AuthHandler.ValidateRequest(r)
+	if err != nil {
+		api.WriteError(w, r, err)
+		return nil, false
+	}
+
+	if !claims.IsAdmin {
+		h.Logger.
+			With("userID", claims.Subject).
+			With("error", "user is not administrator").
+			Error("unauthenticated request")
+
+		api.WriteProblem(w, api.NewProblem(r, http.StatusForbidden, api.CodeForbidden, "only administrators may manage webhooks"))
+		return nil, false
+	}
+
+	return claims, true
+}
+
+func (h *WebhookHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, utils.ErrNotFound):
+		api.WriteProblem(w, api.NewProblem(r, http.StatusNotFound, api.CodeNotFound, "subscription not found"))
+	case errors.Is(err, utils.ValidationErr):
+		api.WriteError(w, r, err)
+	default:
+		h.Logger.
+			With("error", err).
+			Error("failed to handle webhook request")
+
+		api.WriteError(w, r, err)
+	}
+}
@@ -0,0 +1,99 @@
+package webhookapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackmcguire1/UserService/api/auth"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/dom/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHandler(t *testing.T) *WebhookHandler {
+	svc, err := webhook.NewService(&webhook.Resources{Repo: webhook.NewMemoryRepo()})
+	assert.NoError(t, err)
+
+	return &WebhookHandler{
+		WebhookService: svc,
+		Logger:         slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})),
+		AuthHandler:    &auth.Handler{JWTSecret: []byte("1234"), Expiry: time.Minute},
+	}
+}
+
+func newTestRequest(t *testing.T, h *WebhookHandler, method, target, body string, caller *user.User) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	if caller != nil {
+		token, err := h.AuthHandler.SignClaims(caller)
+		assert.NoError(t, err)
+		r.Header.Set(auth.AUTH_HEADER, "Bearer "+token)
+	}
+
+	return r
+}
+
+func TestSubscriptionsAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *user.User
+		status int
+	}{
+		{name: "anonymous", caller: nil, status: http.StatusUnauthorized},
+		{name: "user", caller: &user.User{ID: "1234"}, status: http.StatusForbidden},
+		{name: "admin", caller: &user.User{ID: "admin", IsAdmin: true}, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			w := httptest.NewRecorder()
+			h.Subscriptions(w, newTestRequest(t, h, http.MethodGet, "/webhooks", "", tc.caller))
+
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	admin := &user.User{ID: "admin", IsAdmin: true}
+	h := newTestHandler(t)
+
+	w := httptest.NewRecorder()
+	h.Subscriptions(w, newTestRequest(t, h, http.MethodPost, "/webhooks", `{"url":"https://example.com/hook","events":["DELETED"]}`, admin))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created *webhook.Subscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Secret, "the secret is returned on creation")
+	assert.Equal(t, "admin", created.CreatedBy)
+
+	w = httptest.NewRecorder()
+	r := mux.SetURLVars(newTestRequest(t, h, http.MethodGet, "/webhooks/"+created.ID, "", admin), map[string]string{"id": created.ID})
+	h.Subscription(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var fetched *webhook.Subscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &fetched))
+	assert.Empty(t, fetched.Secret)
+
+	w = httptest.NewRecorder()
+	h.Subscriptions(w, newTestRequest(t, h, http.MethodPost, "/webhooks", `{"url":"https://example.com","events":["UNKNOWN"]}`, admin))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	r = mux.SetURLVars(newTestRequest(t, h, http.MethodDelete, "/webhooks/"+created.ID, "", admin), map[string]string{"id": created.ID})
+	h.Subscription(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	r = mux.SetURLVars(newTestRequest(t, h, http.MethodGet, "/webhooks/"+created.ID, "", admin), map[string]string{"id": created.ID})
+	h.Subscription(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/user"
+)
+
+// runCommand runs a maintenance command against the configured storage backend
+// instead of starting the server, e.g. `api backfill-emails -dry-run`
+func runCommand(name string, args []string) error {
+	switch name {
+	case "backfill-emails":
+		return backfillEmails(args)
+	case "verify-audit":
+		return verifyAudit(args)
+	case "re-encrypt":
+		return reEncrypt(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// backfillEmails sets the normalized email of users saved before emails were normalized
+// and reports users whose normalized emails collide
+func backfillEmails(args []string) error {
+	flags := flag.NewFlagSet("backfill-emails", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report without writing")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	report, err := user.BackfillNormalizedEmails(context.Background(), repos.users, emailNormalizer, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, collision := range report.Collisions {
+		log.
+			With("normalized-email", collision.NormalizedEmail).
+			With("kept-user-id", collision.KeptUserID).
+			With("user-ids", collision.UserIDs).
+			Warn("users share a normalized email, change or merge them and run the backfill again")
+	}
+	log.
+		With("dry-run", *dryRun).
+		With("scanned", report.Scanned).
+		With("updated", report.Updated).
+		With("collisions", len(report.Collisions)).
+		Info("backfilled normalized emails")
+
+	return nil
+}
+
+// reEncrypt encrypts users stored in plaintext and rewraps data keys wrapped with a retired key,
+// run it after enabling FIELD_ENCRYPTION_KEY_FILE or making a new key current
+func reEncrypt(args []string) error {
+	flags := flag.NewFlagSet("re-encrypt", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report without writing")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	repo, ok := repos.users.(*user.EncryptedRepository)
+	if !ok {
+		return fmt.Errorf("FIELD_ENCRYPTION_KEY_FILE is not set")
+	}
+
+	report, err := user.ReEncryptUsers(context.Background(), repo, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	log.
+		With("dry-run", *dryRun).
+		With("scanned", report.Scanned).
+		With("encrypted", report.Encrypted).
+		With("rewrapped", report.Rewrapped).
+		Info("re-encrypted users")
+
+	return nil
+}
+
+// verifyAudit walks the audit log hash chain and fails at the first broken link, checkpoint signatures are
+// checked with the -public-key PEM file or the public half of AUDIT_SIGNING_KEY_FILE
+func verifyAudit(args []string) error {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	publicKeyFile := flags.String("public-key", "", "PEM encoded Ed25519 public key verifying checkpoints")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	opts := audit.VerifyOptions{CheckpointInterval: auditCheckpointInterval}
+	switch {
+	case *publicKeyFile != "":
+		data, err := os.ReadFile(*publicKeyFile)
+		if err != nil {
+			return err
+		}
+
+		opts.PublicKey, err = audit.ParsePublicKeyPEM(data)
+		if err != nil {
+			return err
+		}
+	case auditSigningKey != nil:
+		opts.PublicKey = auditSigningKey.Public().(ed25519.PublicKey)
+	default:
+		log.Warn("no audit public key configured, checkpoint signatures are not verified")
+	}
+
+	report, err := audit.Verify(context.Background(), repos.audit, opts)
+	if err != nil {
+		return err
+	}
+
+	logEntry := log.
+		With("verified", report.Verified).
+		With("unchained", report.Unchained).
+		With("unsequenced", report.Unsequenced).
+		With("checkpoints", report.Checkpoints).
+		With("scrubbed", report.Scrubbed).
+		With("head-sequence", report.HeadSequence).
+		With("head-hash", report.HeadHash)
+
+	if report.Broken != nil {
+		logEntry.
+			With("broken-sequence", report.Broken.Sequence).
+			With("broken-entry-id", report.Broken.EntryID).
+			With("reason", report.Broken.Reason).
+			Error("audit log chain is broken")
+
+		return fmt.Errorf("audit log chain is broken at sequence %d: %s", report.Broken.Sequence, report.Broken.Reason)
+	}
+
+	if report.Unsequenced > 0 {
+		logEntry.Warn("audit entries recorded before the log was chained are not numbered and were not verified")
+	}
+
+	logEntry.Info("verified audit log chain")
+
+	return nil
+}
@@ -1,32 +1,52 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jackmcguire1/UserService/api"
+	"github.com/jackmcguire1/UserService/api/auditapi"
 	"github.com/jackmcguire1/UserService/api/auth"
 	"github.com/jackmcguire1/UserService/api/healthcheck"
 	"github.com/jackmcguire1/UserService/api/searchapi"
 	"github.com/jackmcguire1/UserService/api/userapi"
+	"github.com/jackmcguire1/UserService/api/webhookapi"
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/dom/privacy"
 	"github.com/jackmcguire1/UserService/dom/user"
-	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/jackmcguire1/UserService/dom/webhook"
+	"github.com/jackmcguire1/UserService/pkg/fieldcrypt"
+	"github.com/jackmcguire1/UserService/pkg/redact"
 )
 
+// capturingResponseWriter records the status and size of the response, bodies are not logged as they hold user data
 type capturingResponseWriter struct {
 	http.ResponseWriter
-	body []byte
+	status int
+	size   int
+}
+
+func (w *capturingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
 }
 
 func (w *capturingResponseWriter) Write(b []byte) (int, error) {
-	w.body = append(w.body, b...)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.size += len(b)
 	return w.ResponseWriter.Write(b)
 }
 
@@ -36,56 +56,248 @@ var (
 	userService        user.UserService
 	userHandler        *userapi.UserHandler
 	searchHandler      *searchapi.SearchHandler
+	webhookHandler     *webhookapi.WebhookHandler
+	auditHandler       *auditapi.AuditHandler
 	healthCheckHandler *healthcheck.HealthCheckHandler
 
-	mongoHost            string
-	mongoDatabase        string
-	mongoUsersCollection string
+	storageBackend string
+	repos          *repositories
+
+	mongoHost               string
+	mongoDatabase           string
+	mongoUsersCollection    string
+	mongoSessionsCollection string
+	mongoOutboxCollection   string
+	mongoWebhooksCollection string
+	mongoAuditCollection    string
+
+	postgresDSN string
+	sqlitePath  string
+	autoMigrate bool
 
 	listenPort string
 	listenHost string
 
-	userUpdates chan *user.UserUpdate
-	eventsURL   string
+	trustProxyHeaders bool
+
+	auditSigningKey         ed25519.PrivateKey
+	auditCheckpointInterval int64
+
+	eventsURL        string
+	outboxDispatcher *outbox.Dispatcher
+
+	deletedUserRetention time.Duration
+	purgeInterval        time.Duration
+	userPurger           *user.Purger
+
+	JWTSecret                []byte
+	JWTExpiryDuration        time.Duration
+	JWTRefreshExpiryDuration time.Duration
+	JWTSigningAlg            string
+	JWTKeyRotationInterval   time.Duration
+	JWTKeyRing               *auth.KeyRing
+
+	allowPublicSignUp bool
 
-	JWTSecret         []byte
-	JWTExpiryDuration time.Duration
+	repoTimeouts user.Timeouts
+
+	emailNormalizer user.EmailNormalizer
+
+	fieldCipher *fieldcrypt.Cipher
 )
 
 func init() {
 	jsonLogHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
-	log = slog.New(jsonLogHandler)
+
+	// personal data is redacted from every log line, services log through the default logger
+	redactionPolicy := redact.DefaultPolicy()
+	redactionPolicy.HashKey = []byte(os.Getenv("LOG_REDACTION_KEY"))
+	if err := redactionPolicy.Set(os.Getenv("LOG_REDACTION")); err != nil {
+		slog.New(jsonLogHandler).
+			With("error", err).
+			Error("invalid LOG_REDACTION")
+		panic(err)
+	}
+	log = slog.New(redact.NewHandler(jsonLogHandler, redactionPolicy))
+	slog.SetDefault(log)
+
+	storageBackend = os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = STORAGE_MONGO
+	}
 
 	mongoHost = os.Getenv("MONGO_HOST")
 	mongoDatabase = os.Getenv("MONGO_DATABASE")
 	mongoUsersCollection = os.Getenv("MONGO_USERS_COLLECTION")
+	mongoSessionsCollection = os.Getenv("MONGO_SESSIONS_COLLECTION")
+	if mongoSessionsCollection == "" {
+		mongoSessionsCollection = "sessions"
+	}
+	mongoOutboxCollection = os.Getenv("MONGO_OUTBOX_COLLECTION")
+	if mongoOutboxCollection == "" {
+		mongoOutboxCollection = "user_events"
+	}
+	mongoWebhooksCollection = os.Getenv("MONGO_WEBHOOKS_COLLECTION")
+	if mongoWebhooksCollection == "" {
+		mongoWebhooksCollection = "webhooks"
+	}
+	mongoAuditCollection = os.Getenv("MONGO_AUDIT_COLLECTION")
+	if mongoAuditCollection == "" {
+		mongoAuditCollection = "audit"
+	}
+
+	postgresDSN = os.Getenv("POSTGRES_DSN")
+	sqlitePath = os.Getenv("SQLITE_PATH")
+	if sqlitePath == "" {
+		sqlitePath = "users.db"
+	}
+	autoMigrate = os.Getenv("AUTO_MIGRATE") != "false"
 
 	listenPort = os.Getenv("LISTEN_PORT")
 	listenHost = os.Getenv("LISTEN_HOST")
+	trustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
 
-	userUpdates = make(chan *user.UserUpdate, 1)
 	eventsURL = os.Getenv("EVENTS_URL")
 
 	JWTSecret = []byte(os.Getenv("JWT_SECRET"))
 	JWTExpiryDuration = time.Hour
+	JWTRefreshExpiryDuration = time.Hour * 24 * 30
+	JWTSigningAlg = os.Getenv("JWT_SIGNING_ALG")
+
+	allowPublicSignUp = os.Getenv("ALLOW_PUBLIC_SIGNUP") == "true"
+
+	emailNormalizer = user.EmailNormalizer{FoldGmail: os.Getenv("EMAIL_FOLD_GMAIL") == "true"}
 
 	var err error
 
-	userMongoRepo, err := user.NewMongoRepo(context.Background(), &user.MongoRepoParams{
-		Host:           mongoHost,
-		Database:       mongoDatabase,
-		CollectionName: mongoUsersCollection,
+	if path := os.Getenv("AUDIT_SIGNING_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			auditSigningKey, err = audit.ParsePrivateKeyPEM(data)
+		}
+		if err != nil {
+			log.
+				With("error", err).
+				Error("failed to load AUDIT_SIGNING_KEY_FILE")
+			panic(err)
+		}
+	}
+	if path := os.Getenv("FIELD_ENCRYPTION_KEY_FILE"); path != "" {
+		fieldCipher, err = loadFieldCipher(path)
+		if err != nil {
+			log.
+				With("error", err).
+				Error("failed to load FIELD_ENCRYPTION_KEY_FILE")
+			panic(err)
+		}
+	}
+
+	auditCheckpointInterval = audit.DefaultCheckpointInterval
+	if v := os.Getenv("AUDIT_CHECKPOINT_INTERVAL"); v != "" {
+		auditCheckpointInterval, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || auditCheckpointInterval <= 0 {
+			log.
+				With("error", err).
+				Error("failed to parse AUDIT_CHECKPOINT_INTERVAL")
+			panic(fmt.Errorf("AUDIT_CHECKPOINT_INTERVAL must be a positive integer"))
+		}
+	}
+
+	deletedUserRetention = user.DefaultRetention
+	purgeInterval = time.Hour
+	for env, duration := range map[string]*time.Duration{
+		"DELETED_USER_RETENTION": &deletedUserRetention,
+		"PURGE_INTERVAL":         &purgeInterval,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*duration, err = time.ParseDuration(v)
+			if err != nil || *duration <= 0 {
+				log.
+					With("error", err).
+					With("env", env).
+					Error("failed to parse positive duration")
+				panic(fmt.Errorf("%s must be a positive duration", env))
+			}
+		}
+	}
+
+	repoTimeouts = user.DefaultTimeouts
+	for env, timeout := range map[string]*time.Duration{
+		"REPO_READ_TIMEOUT":   &repoTimeouts.Read,
+		"REPO_WRITE_TIMEOUT":  &repoTimeouts.Write,
+		"REPO_SEARCH_TIMEOUT": &repoTimeouts.Search,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*timeout, err = time.ParseDuration(v)
+			if err != nil {
+				log.
+					With("error", err).
+					With("env", env).
+					Error("failed to parse repository timeout")
+				panic(err)
+			}
+		}
+	}
+
+	if JWTSigningAlg != "" && JWTSigningAlg != "HS256" {
+		JWTKeyRing, err = loadKeyRing()
+		if err != nil {
+			log.
+				With("error", err).
+				With("alg", JWTSigningAlg).
+				Error("failed to init JWT key ring")
+			panic(err)
+		}
+
+		if v := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); v != "" {
+			JWTKeyRotationInterval, err = time.ParseDuration(v)
+			if err != nil {
+				log.
+					With("error", err).
+					Error("failed to parse JWT_KEY_ROTATION_INTERVAL")
+				panic(err)
+			}
+		}
+
+		// rotated keys only live in the memory of the instance which generated them
+		if JWTKeyRotationInterval > 0 && sharedStorage(storageBackend) {
+			err = fmt.Errorf("JWT_KEY_ROTATION_INTERVAL can not be used with the %s storage backend, which several instances may share", storageBackend)
+			log.
+				With("error", err).
+				Error("failed to init JWT key rotation")
+			panic(err)
+		}
+	}
+
+	repos, err = newRepositories(storageBackend)
+	if err != nil {
+		log.
+			With("error", err).
+			With("storage-backend", storageBackend).
+			Error("failed to init repositories")
+		panic(err)
+	}
+	if fieldCipher != nil {
+		repos.users = user.NewEncryptedRepo(repos.users, fieldCipher)
+	}
+
+	auditService, err := audit.NewService(&audit.Resources{
+		Repo:               repos.audit,
+		SigningKey:         auditSigningKey,
+		CheckpointInterval: auditCheckpointInterval,
 	})
 	if err != nil {
 		log.
 			With("error", err).
-			Error("failed to init user mongo repo")
+			Error("failed to init audit service")
 		panic(err)
 	}
 
 	userService, err = user.NewService(&user.Resources{
-		UserChannel: userUpdates,
-		Repo:        userMongoRepo,
+		Repo:     repos.users,
+		Timeouts: repoTimeouts,
+		Emails:   emailNormalizer,
+		Audit:    auditService,
 	})
 	if err != nil {
 		log.
@@ -94,26 +306,108 @@ func init() {
 		panic(err)
 	}
 
-	authHandler = &auth.Handler{JWTSecret: JWTSecret, Expiry: JWTExpiryDuration}
-	userHandler = &userapi.UserHandler{UserService: userService, Logger: log, AuthHandler: authHandler}
-	searchHandler = &searchapi.SearchHandler{UserService: userService, Logger: log, AuthHandler: authHandler}
+	webhookService, err := webhook.NewService(&webhook.Resources{Repo: repos.webhooks})
+	if err != nil {
+		log.
+			With("error", err).
+			Error("failed to init webhook service")
+		panic(err)
+	}
+
+	// user events fan out to every webhook subscription as well as the legacy EVENTS_URL
+	publishers := outbox.MultiPublisher{webhookService}
+	if eventsURL != "" {
+		publishers = append(publishers, outbox.NewHTTPPublisher(eventsURL))
+	}
+	var publisher outbox.Publisher = publishers
+	var eventDecrypter privacy.EventDecrypter
+	if fieldCipher != nil {
+		// events are stored with the personal fields of the user encrypted like the user itself
+		decrypting := user.NewDecryptingPublisher(publishers, fieldCipher)
+		publisher, eventDecrypter = decrypting, decrypting
+	}
+	outboxDispatcher = outbox.NewDispatcher(repos.outbox, publisher, log)
+
+	privacyService, err := privacy.NewService(&privacy.Resources{
+		Users:     repos.users,
+		Sessions:  repos.sessions,
+		Audit:     auditService,
+		Events:    repos.outbox,
+		Decrypter: eventDecrypter,
+	})
+	if err != nil {
+		log.
+			With("error", err).
+			Error("failed to init privacy service")
+		panic(err)
+	}
+
+	userPurger = user.NewPurger(repos.users, deletedUserRetention, log)
+	userPurger.PollInterval = purgeInterval
+	userPurger.Audit = auditService
+
+	authHandler = &auth.Handler{
+		JWTSecret:     JWTSecret,
+		Expiry:        JWTExpiryDuration,
+		RefreshExpiry: JWTRefreshExpiryDuration,
+		Sessions:      repos.sessions,
+		Keys:          JWTKeyRing,
+	}
+	userHandler = &userapi.UserHandler{
+		UserService:       userService,
+		PrivacyService:    privacyService,
+		Logger:            log,
+		AuthHandler:       authHandler,
+		AllowPublicSignUp: allowPublicSignUp,
+	}
+	searchHandler = &searchapi.SearchHandler{UserService: userService, Logger: log, AuthHandler: authHandler, FieldsEncrypted: fieldCipher != nil}
+	webhookHandler = &webhookapi.WebhookHandler{WebhookService: webhookService, Logger: log, AuthHandler: authHandler}
+	auditHandler = &auditapi.AuditHandler{AuditService: auditService, Logger: log, AuthHandler: authHandler}
 	healthCheckHandler = &healthcheck.HealthCheckHandler{LogVerbosity: "DEBUG", StartTime: time.Now().UTC(), Logger: log}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.
+				With("error", err).
+				With("command", os.Args[1]).
+				Error("command failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	s := mux.NewRouter()
 
 	s.HandleFunc("/sign_in", userHandler.SignIn)
+	s.HandleFunc("/sign_out", userHandler.SignOut)
+	s.HandleFunc("/token/refresh", userHandler.RefreshToken)
+	s.HandleFunc("/users/sessions/revoke", userHandler.RevokeSessions)
+	s.HandleFunc("/.well-known/jwks.json", userHandler.JWKS)
 	s.Handle("/users", userHandler)
+	s.HandleFunc(userapi.USERS_PATH, userHandler.Users)
+	s.HandleFunc(userapi.USERS_PATH+"/me", userHandler.Me)
+	s.HandleFunc(userapi.USERS_PATH+"/{id}", userHandler.User)
+	s.HandleFunc(userapi.USERS_PATH+"/{id}/restore", userHandler.Restore)
+	s.HandleFunc(userapi.USERS_PATH+"/{id}/export", userHandler.Export)
+	s.HandleFunc(userapi.USERS_PATH+"/{id}/erase", userHandler.Erase)
 	s.HandleFunc("/search/users/by_country", searchHandler.UsersByCountry)
 	s.HandleFunc("/search/users/", searchHandler.GetAllUsers)
+	s.HandleFunc("/webhooks", webhookHandler.Subscriptions)
+	s.HandleFunc("/webhooks/{id}", webhookHandler.Subscription)
+	s.HandleFunc("/webhooks/{id}/deliveries", webhookHandler.Deliveries)
+	s.HandleFunc("/webhooks/{id}/test", webhookHandler.SendTestEvent)
+	s.HandleFunc("/audit", auditHandler.Entries)
 	s.Handle("/healthcheck", healthCheckHandler)
+	s.NotFoundHandler = api.RequestIDMiddleware(http.HandlerFunc(api.NotFound))
 
 	addr := fmt.Sprintf("%s:%s", listenHost, listenPort)
 
 	log.
 		With("events-url", eventsURL).
-		Info("starting user updates handler")
+		Info("starting user updates dispatcher")
 
 	// Use the headersMiddleware to set headers for all routes
 	headersMiddleware := func(next http.Handler) http.Handler {
@@ -134,9 +428,11 @@ func main() {
 				capturingWriter := &capturingResponseWriter{ResponseWriter: w}
 				next.ServeHTTP(capturingWriter, r)
 				log.
-					With("request", utils.ToJSON(r)).
-					With("raw-resp", utils.ToJSON(r)).
-					With("raw-body", string(capturingWriter.body)).
+					With("request-id", w.Header().Get(api.REQUEST_ID_HEADER)).
+					With("http-method", r.Method).
+					With("path", r.URL.Path).
+					With("status", capturingWriter.status).
+					With("response-size", capturingWriter.size).
 					Debug("HTTP RESPONSE")
 			} else {
 				next.ServeHTTP(w, r)
@@ -144,27 +440,21 @@ func main() {
 		})
 	}
 	s.Use(headersMiddleware)
+	s.Use(api.RequestIDMiddleware)
+	s.Use(api.ClientIPMiddleware(trustProxyHeaders))
 
-	// POST user updates to URL
-	go func(i chan *user.UserUpdate) {
-		for update := range userUpdates {
+	// deliver user updates from the outbox to EVENTS_URL
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	defer stopDispatcher()
+	go outboxDispatcher.Run(dispatcherCtx)
 
-			log.
-				With("update", utils.ToJSON(update)).
-				Info("got user update")
-
-			if eventsURL != "" {
-				r := bytes.NewReader([]byte(utils.ToJSON(update)))
-
-				_, err := http.Post(eventsURL, "application/json", r)
-				if err != nil {
-					log.
-						With("error", err).
-						Error("failed to publish user update")
-				}
-			}
-		}
-	}(userUpdates)
+	// hard delete users once their soft delete is older than DELETED_USER_RETENTION
+	go userPurger.Run(dispatcherCtx)
+
+	// rotate the signing key, previous keys keep verifying tokens until they have expired
+	if JWTKeyRing != nil && JWTKeyRotationInterval > 0 {
+		go rotateSigningKeys(dispatcherCtx)
+	}
 
 	log.
 		With("addr", addr).
@@ -189,3 +479,107 @@ func main() {
 	receivedSig := <-signalChan
 	log.With("signal", receivedSig).Warn("recieved OS SIGNAL")
 }
+
+// rotateSigningKeys generates a new signing key every JWT_KEY_ROTATION_INTERVAL until the context is cancelled
+func rotateSigningKeys(ctx context.Context) {
+	ticker := time.NewTicker(JWTKeyRotationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		key, err := auth.GenerateSigningKey(JWTSigningAlg)
+		if err != nil {
+			log.
+				With("error", err).
+				Error("failed to generate JWT signing key")
+			continue
+		}
+
+		JWTKeyRing.Rotate(key, JWTExpiryDuration)
+
+		log.
+			With("kid", key.ID).
+			Info("rotated JWT signing key")
+	}
+}
+
+// loadKeyRing reads the active signing key from JWT_SIGNING_KEY_FILE, generating one when unset on storage backends
+// used by a single instance, keys listed in JWT_RETIRED_KEY_FILES as 'path' or 'path=kid' remain valid for verification
+// until tokens signed by them have expired
+func loadKeyRing() (*auth.KeyRing, error) {
+	var (
+		active *auth.SigningKey
+		err    error
+	)
+
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		active, err = auth.ParseSigningKeyPEM(os.Getenv("JWT_SIGNING_KEY_ID"), data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// every instance would sign with a key of its own which the other instances can not verify
+		if sharedStorage(storageBackend) {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY_FILE is required with the %s storage backend, which several instances may share", storageBackend)
+		}
+
+		active, err = auth.GenerateSigningKey(JWTSigningAlg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if active.Method.Alg() != JWTSigningAlg {
+		return nil, fmt.Errorf("signing key is %s but JWT_SIGNING_ALG is %s", active.Method.Alg(), JWTSigningAlg)
+	}
+
+	ring := auth.NewKeyRing(active)
+
+	for _, entry := range strings.Split(os.Getenv("JWT_RETIRED_KEY_FILES"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		// the kid the key signed with when it was active, like JWT_SIGNING_KEY_ID it defaults to the thumbprint
+		path, kid, _ := strings.Cut(entry, "=")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := auth.ParseSigningKeyPEM(kid, data)
+		if err != nil {
+			return nil, err
+		}
+
+		ring.AddRetired(key, time.Now().UTC().Add(JWTExpiryDuration))
+	}
+
+	return ring, nil
+}
+
+// loadFieldCipher reads the local key file encrypting the personal fields of users, see fieldcrypt.KeyFile
+func loadFieldCipher(path string) (*fieldcrypt.Cipher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := fieldcrypt.ParseKeyFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return fieldcrypt.NewCipher(keys, keys.IndexKey())
+}
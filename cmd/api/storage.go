@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/dom/session"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/dom/webhook"
+	"github.com/jackmcguire1/UserService/pkg/sqlerr"
+)
+
+const (
+	STORAGE_MONGO    = "mongo"
+	STORAGE_MEMORY   = "memory"
+	STORAGE_POSTGRES = "postgres"
+	STORAGE_SQLITE   = "sqlite"
+)
+
+type repositories struct {
+	users    user.Repository
+	sessions session.Repository
+	outbox   outbox.Repository
+	webhooks webhook.Repository
+	audit    audit.Repository
+}
+
+// sharedStorage reports whether several instances of the service may run against the STORAGE_BACKEND,
+// the memory and sqlite backends are only ever used by a single process
+func sharedStorage(backend string) bool {
+	return backend == STORAGE_MONGO || backend == STORAGE_POSTGRES
+}
+
+// newRepositories connects the repositories of the STORAGE_BACKEND,
+// the memory backend keeps everything in process and is lost on restart
+func newRepositories(backend string) (*repositories, error) {
+	switch backend {
+	case STORAGE_MONGO:
+		return newMongoRepositories()
+	case STORAGE_POSTGRES:
+		return newPostgresRepositories()
+	case STORAGE_SQLITE:
+		return newSQLiteRepositories()
+	case STORAGE_MEMORY:
+		events := outbox.NewMemoryRepo()
+
+		return &repositories{
+			users:    user.NewMemoryRepo(events),
+			sessions: session.NewMemoryRepo(),
+			outbox:   events,
+			webhooks: webhook.NewMemoryRepo(),
+			audit:    audit.NewMemoryRepo(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+func newMongoRepositories() (*repositories, error) {
+	userMongoRepo, err := user.NewMongoRepo(context.Background(), &user.MongoRepoParams{
+		Host:                 mongoHost,
+		Database:             mongoDatabase,
+		CollectionName:       mongoUsersCollection,
+		OutboxCollectionName: mongoOutboxCollection,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init user mongo repo err:%w", err)
+	}
+
+	sessionMongoRepo, err := session.NewMongoRepo(context.Background(), &session.MongoRepoParams{
+		Host:           mongoHost,
+		Database:       mongoDatabase,
+		CollectionName: mongoSessionsCollection,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init session mongo repo err:%w", err)
+	}
+
+	outboxMongoRepo, err := outbox.NewMongoRepo(context.Background(), &outbox.MongoRepoParams{
+		Host:                     mongoHost,
+		Database:                 mongoDatabase,
+		CollectionName:           mongoOutboxCollection,
+		DeadLetterCollectionName: mongoOutboxCollection + "_dead_letters",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init outbox mongo repo err:%w", err)
+	}
+
+	webhookMongoRepo, err := webhook.NewMongoRepo(context.Background(), &webhook.MongoRepoParams{
+		Host:                     mongoHost,
+		Database:                 mongoDatabase,
+		CollectionName:           mongoWebhooksCollection,
+		DeliveriesCollectionName: mongoWebhooksCollection + "_deliveries",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init webhook mongo repo err:%w", err)
+	}
+
+	auditMongoRepo, err := audit.NewMongoRepo(context.Background(), &audit.MongoRepoParams{
+		Host:           mongoHost,
+		Database:       mongoDatabase,
+		CollectionName: mongoAuditCollection,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init audit mongo repo err:%w", err)
+	}
+
+	return &repositories{
+		users:    userMongoRepo,
+		sessions: sessionMongoRepo,
+		outbox:   outboxMongoRepo,
+		webhooks: webhookMongoRepo,
+		audit:    auditMongoRepo,
+	}, nil
+}
+
+// newPostgresRepositories shares one connection pool between the repositories
+func newPostgresRepositories() (*repositories, error) {
+	userPostgresRepo, err := user.NewPostgresRepo(context.Background(), &user.PostgresRepoParams{DSN: postgresDSN})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init user postgres repo err:%w", err)
+	}
+	db := userPostgresRepo.DB
+
+	err = migrateSQL(db, migrations.POSTGRES)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repositories{
+		users:    userPostgresRepo,
+		sessions: session.NewSQLRepo(db),
+		outbox:   outbox.NewSQLRepo(db, outbox.POSTGRES_CLAIM_LOCK),
+		webhooks: webhook.NewSQLRepo(db),
+		audit:    audit.NewSQLRepo(db, sqlerr.IsPostgresUniqueViolation),
+	}, nil
+}
+
+// newSQLiteRepositories keeps everything in the SQLITE_PATH database file, so a single binary
+// needs no database server
+func newSQLiteRepositories() (*repositories, error) {
+	userSQLiteRepo, err := user.NewSQLiteRepo(context.Background(), &user.SQLiteRepoParams{Path: sqlitePath})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init user sqlite repo err:%w", err)
+	}
+	db := userSQLiteRepo.DB
+
+	err = migrateSQL(db, migrations.SQLITE)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repositories{
+		users:    userSQLiteRepo,
+		sessions: session.NewSQLRepo(db),
+		// claims need no row locks as sqlite serialises writers
+		outbox:   outbox.NewSQLRepo(db, ""),
+		webhooks: webhook.NewSQLRepo(db),
+		audit:    audit.NewSQLRepo(db, sqlerr.IsSQLiteUniqueViolation),
+	}, nil
+}
+
+// migrateSQL applies the pending schema migrations of the dialect unless AUTO_MIGRATE is false
+func migrateSQL(db *sql.DB, dialect string) error {
+	if !autoMigrate {
+		return nil
+	}
+
+	runner, err := migrations.NewRunner(db, dialect, log)
+	if err != nil {
+		return err
+	}
+
+	_, err = runner.Up(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s err:%w", dialect, err)
+	}
+
+	return nil
+}
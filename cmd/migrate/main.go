@@ -0,0 +1,105 @@
+// migrate applies the embedded schema migrations to the SQL storage backends
+//
+//	migrate [-dialect postgres|sqlite] [-dsn $POSTGRES_DSN|$SQLITE_PATH] up|status
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/jackmcguire1/UserService/dom/user"
+)
+
+func main() {
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	dialect := flag.String("dialect", migrations.POSTGRES, "database dialect")
+	dsn := flag.String("dsn", "", "database connection string or sqlite file, defaults to POSTGRES_DSN or SQLITE_PATH")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: migrate [flags] up|status\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *dsn == "" {
+		*dsn = os.Getenv("POSTGRES_DSN")
+		if *dialect == migrations.SQLITE {
+			*dsn = os.Getenv("SQLITE_PATH")
+		}
+	}
+
+	err := run(context.Background(), log, *dialect, *dsn, flag.Arg(0))
+	if err != nil {
+		log.
+			With("error", err).
+			Error("migration failed")
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, log *slog.Logger, dialect, dsn, command string) error {
+	db, err := open(dialect, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runner, err := migrations.NewRunner(db, dialect, log)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := runner.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		log.
+			With("applied", len(applied)).
+			Info("database is up to date")
+	case "status":
+		pending, err := runner.Pending(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range pending {
+			log.
+				With("version", m.Version).
+				With("name", m.Name).
+				Info("pending migration")
+		}
+		log.
+			With("pending", len(pending)).
+			Info("migration status")
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	return nil
+}
+
+func open(dialect, dsn string) (*sql.DB, error) {
+	switch dialect {
+	case migrations.POSTGRES:
+		return sql.Open("pgx", dsn)
+	case migrations.SQLITE:
+		// same pragmas as the service so the migrations run with the same locking
+		return sql.Open("sqlite", user.SQLiteDSN(dsn))
+	default:
+		return nil, fmt.Errorf("unknown dialect %q", dialect)
+	}
+}
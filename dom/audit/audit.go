@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	ActionUserCreated   = "USER_CREATED"
+	ActionUserUpdated   = "USER_UPDATED"
+	ActionUserDeleted   = "USER_DELETED"
+	ActionUserRestored  = "USER_RESTORED"
+	ActionUserPurged    = "USER_PURGED"
+	ActionUsersSearched = "USERS_SEARCHED"
+	ActionUserExported  = "USER_EXPORTED"
+	ActionUserErased    = "USER_ERASED"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Entry is an append-only record of a single mutation or administrative action,
+// BeforeHash and AfterHash fingerprint the target user around the change without storing their data.
+// Entries form a hash chain in Sequence order, see ComputeHash and Verify.
+// The personal fields, ActorID, TargetUserID, BeforeHash, AfterHash, ClientIP and Details, are chained through
+// PersonalDigest so they can be scrubbed when a user is erased without breaking the chain
+type Entry struct {
+	ID           string    `json:"id" bson:"_id"`
+	Sequence     int64     `json:"sequence" bson:"sequence"`
+	Time         time.Time `json:"time" bson:"time"`
+	ActorID      string    `json:"actorId" bson:"actorId"`
+	ActorIsAdmin bool      `json:"actorIsAdmin" bson:"actorIsAdmin"`
+	Action       string    `json:"action" bson:"action"`
+	TargetUserID string    `json:"targetUserId,omitempty" bson:"targetUserId,omitempty"`
+	BeforeHash   string    `json:"beforeHash,omitempty" bson:"beforeHash,omitempty"`
+	AfterHash    string    `json:"afterHash,omitempty" bson:"afterHash,omitempty"`
+	ClientIP     string    `json:"clientIp,omitempty" bson:"clientIp,omitempty"`
+	RequestID    string    `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	// Details describes actions without a target, e.g. the query of a search
+	Details string `json:"details,omitempty" bson:"details,omitempty"`
+
+	// PrevHash is the Hash of the previous entry in the chain
+	PrevHash string `json:"prevHash" bson:"prevHash"`
+	Hash     string `json:"hash" bson:"hash"`
+	// Signature is the base64 Ed25519 signature of the Hash of checkpoint entries
+	Signature string `json:"signature,omitempty" bson:"signature,omitempty"`
+
+	// PersonalDigest is the salted SHA-256 of the personal fields, see ComputePersonalDigest
+	PersonalDigest string `json:"personalDigest,omitempty" bson:"personalDigest,omitempty"`
+	// PersonalSalt is random per entry and removed on scrubbing, so the digest of a scrubbed entry can not be
+	// used to guess the values it replaced
+	PersonalSalt string `json:"-" bson:"personalSalt,omitempty"`
+	// Scrubbed is set once the personal fields of an erased user were replaced by a pseudonym
+	Scrubbed bool `json:"scrubbed,omitempty" bson:"scrubbed,omitempty"`
+	// Scrubs lists the entries a USER_ERASED entry scrubbed, TargetUserID is the pseudonym which replaced the user
+	Scrubs []*ScrubbedEntry `json:"scrubs,omitempty" bson:"scrubs,omitempty"`
+}
+
+// ScrubbedEntry commits to the content of an entry right after it was scrubbed, see Entry.ScrubbedHash
+type ScrubbedEntry struct {
+	Sequence int64  `json:"sequence" bson:"sequence"`
+	Hash     string `json:"hash" bson:"hash"`
+}
+
+// ComputeHash returns the SHA-256 over every field of the entry, including PrevHash, except Hash and Signature,
+// so changing any recorded value or the order of the chain changes the hash
+// Entries with a PersonalDigest chain the digest in place of their personal fields
+func (e *Entry) ComputeHash() string {
+	linked := *e
+	linked.Time = linked.Time.UTC()
+	linked.Hash = ""
+	linked.Signature = ""
+
+	if linked.PersonalDigest != "" {
+		linked.ActorID = ""
+		linked.TargetUserID = ""
+		linked.BeforeHash = ""
+		linked.AfterHash = ""
+		linked.ClientIP = ""
+		linked.Details = ""
+		linked.PersonalSalt = ""
+		linked.Scrubbed = false
+	}
+
+	return Hash(utils.ToRAWJSON(linked))
+}
+
+// ScrubbedHash returns the SHA-256 over every field of the entry, the personal fields included, except Hash and
+// Signature. Scrubbing commits to it in the chain, as the hash of an entry recorded before personal digests
+// covered the fields which were scrubbed
+func (e *Entry) ScrubbedHash() string {
+	scrubbed := *e
+	scrubbed.Time = scrubbed.Time.UTC()
+	scrubbed.Hash = ""
+	scrubbed.Signature = ""
+	scrubbed.PersonalSalt = ""
+
+	return Hash(utils.ToRAWJSON(scrubbed))
+}
+
+// ComputePersonalDigest returns the SHA-256 over the PersonalSalt and the personal fields of the entry
+func (e *Entry) ComputePersonalDigest() string {
+	return Hash(utils.ToRAWJSON(struct {
+		Salt         string
+		ActorID      string
+		TargetUserID string
+		BeforeHash   string
+		AfterHash    string
+		ClientIP     string
+		Details      string
+	}{
+		Salt:         e.PersonalSalt,
+		ActorID:      e.ActorID,
+		TargetUserID: e.TargetUserID,
+		BeforeHash:   e.BeforeHash,
+		AfterHash:    e.AfterHash,
+		ClientIP:     e.ClientIP,
+		Details:      e.Details,
+	}))
+}
+
+// Query filters the audit log, zero values match every entry
+type Query struct {
+	// From and To bound the entry time, From is inclusive and To exclusive
+	From         time.Time
+	To           time.Time
+	ActorID      string
+	TargetUserID string
+	// Subject matches entries whose actor or target is the user
+	Subject string
+	Action  string
+	// BeforeSequence pages through the log, only entries with a lower sequence match
+	BeforeSequence int64
+	Limit          int
+}
+
+// Normalize applies the default limit and validates the time range
+func (q *Query) Normalize() error {
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return fmt.Errorf("%w - limit must be between 1 and %d", utils.ValidationErr, MaxLimit)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return fmt.Errorf("%w - from must be before to", utils.ValidationErr)
+	}
+
+	return nil
+}
+
+// Matches reports whether the entry satisfies the filters of the query
+func (q *Query) Matches(entry *Entry) bool {
+	switch {
+	case !q.From.IsZero() && entry.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !entry.Time.Before(q.To):
+		return false
+	case q.ActorID != "" && entry.ActorID != q.ActorID:
+		return false
+	case q.TargetUserID != "" && entry.TargetUserID != q.TargetUserID:
+		return false
+	case q.Subject != "" && entry.ActorID != q.Subject && entry.TargetUserID != q.Subject:
+		return false
+	case q.Action != "" && entry.Action != q.Action:
+		return false
+	case q.BeforeSequence > 0 && entry.Sequence >= q.BeforeSequence:
+		return false
+	}
+
+	return true
+}
+
+// Hash returns the hex encoded SHA-256 of the data, or an empty string when there is no data
+func Hash(data []byte) string {
+	if data == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// scrubEntry replaces the personal fields of the entry recorded about the user, it reports whether the entry
+// concerned the user. The client IP belongs to the actor, the hashes and details describe the target
+func scrubEntry(entry *Entry, userID, pseudonym string) bool {
+	actor := entry.ActorID == userID
+	target := entry.TargetUserID == userID
+	if !actor && !target {
+		return false
+	}
+
+	if actor {
+		entry.ActorID = pseudonym
+		entry.ClientIP = ""
+	}
+	if target {
+		entry.TargetUserID = pseudonym
+		entry.BeforeHash = ""
+		entry.AfterHash = ""
+		entry.Details = ""
+	}
+	entry.PersonalSalt = ""
+	entry.Scrubbed = true
+
+	return true
+}
+
+// setScrubbedFields copies the personal fields of the scrubbed entry, which is the only update an entry may get
+func setScrubbedFields(entry, scrubbed *Entry) {
+	entry.ActorID = scrubbed.ActorID
+	entry.ClientIP = scrubbed.ClientIP
+	entry.TargetUserID = scrubbed.TargetUserID
+	entry.BeforeHash = scrubbed.BeforeHash
+	entry.AfterHash = scrubbed.AfterHash
+	entry.Details = scrubbed.Details
+	entry.PersonalSalt = ""
+	entry.Scrubbed = true
+}
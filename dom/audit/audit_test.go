@@ -0,0 +1,191 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/jackmcguire1/UserService/pkg/sqlerr"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryNormalize(t *testing.T) {
+	query := &Query{}
+	assert.NoError(t, query.Normalize())
+	assert.Equal(t, DefaultLimit, query.Limit)
+
+	assert.ErrorIs(t, (&Query{Limit: MaxLimit + 1}).Normalize(), utils.ValidationErr)
+
+	now := time.Now()
+	assert.ErrorIs(t, (&Query{From: now, To: now}).Normalize(), utils.ValidationErr)
+	assert.NoError(t, (&Query{From: now.Add(-time.Hour), To: now}).Normalize())
+}
+
+func TestHash(t *testing.T) {
+	assert.Empty(t, Hash(nil))
+	assert.Len(t, Hash([]byte("{}")), 64)
+	assert.NotEqual(t, Hash([]byte(`{"a":1}`)), Hash([]byte(`{"a":2}`)))
+}
+
+func TestRecordStampsRequestContext(t *testing.T) {
+	ctx := utils.WithRequestID(context.Background(), "request-1")
+	ctx = utils.WithClientIP(ctx, "192.0.2.1")
+	ctx = utils.WithCallerID(ctx, "admin")
+	ctx = utils.WithCallerIsAdmin(ctx, true)
+
+	repo := NewMemoryRepo()
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+
+	assert.NoError(t, svc.Record(ctx, &Entry{Action: ActionUserDeleted, TargetUserID: "1234"}))
+	assert.NoError(t, svc.Record(ctx, &Entry{ActorID: "1234", Action: ActionUserUpdated, TargetUserID: "1234"}))
+
+	entries, err := svc.GetEntries(ctx, &Query{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	assert.Equal(t, "1234", entries[0].ActorID)
+	assert.False(t, entries[0].ActorIsAdmin, "only the caller of the request is known to be an admin")
+
+	deleted := entries[1]
+	assert.NotEmpty(t, deleted.ID)
+	assert.False(t, deleted.Time.IsZero())
+	assert.Equal(t, "admin", deleted.ActorID)
+	assert.True(t, deleted.ActorIsAdmin)
+	assert.Equal(t, "192.0.2.1", deleted.ClientIP)
+	assert.Equal(t, "request-1", deleted.RequestID)
+
+	_, err = svc.GetEntries(ctx, &Query{Limit: -1})
+	assert.ErrorIs(t, err, utils.ValidationErr)
+}
+
+func testRepository(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, entry := range []*Entry{
+		{ID: "1", ActorID: "admin", ActorIsAdmin: true, Action: ActionUserCreated, TargetUserID: "1234", AfterHash: "a"},
+		{ID: "2", ActorID: "1234", Action: ActionUserUpdated, TargetUserID: "1234", BeforeHash: "a", AfterHash: "b"},
+		{ID: "3", ActorID: "admin", ActorIsAdmin: true, Action: ActionUsersSearched, Details: `{"countryCode":"GB"}`},
+		{ID: "4", ActorID: "admin", ActorIsAdmin: true, Action: ActionUserDeleted, TargetUserID: "1234", BeforeHash: "b", AfterHash: "c", ClientIP: "192.0.2.1", RequestID: "request-1"},
+	} {
+		entry.Sequence = int64(i + 1)
+		entry.Time = start.Add(time.Duration(i) * time.Hour)
+		assert.NoError(t, repo.PutEntry(ctx, entry))
+	}
+
+	ids := func(query *Query) []string {
+		assert.NoError(t, query.Normalize())
+
+		entries, err := repo.GetEntries(ctx, query)
+		assert.NoError(t, err)
+
+		ids := []string{}
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"4", "3", "2", "1"}, ids(&Query{}), "newest first")
+	assert.Equal(t, []string{"4", "3"}, ids(&Query{Limit: 2}))
+	assert.Equal(t, []string{"4", "3", "1"}, ids(&Query{ActorID: "admin"}))
+	assert.Equal(t, []string{"4", "2", "1"}, ids(&Query{TargetUserID: "1234"}))
+	assert.Equal(t, []string{"3"}, ids(&Query{Action: ActionUsersSearched}))
+	assert.Equal(t, []string{"2", "1"}, ids(&Query{To: start.Add(2 * time.Hour)}), "to is exclusive")
+	assert.Equal(t, []string{"4", "3"}, ids(&Query{From: start.Add(2 * time.Hour)}), "from is inclusive")
+	assert.Equal(t, []string{"3"}, ids(&Query{From: start.Add(time.Hour), To: start.Add(3 * time.Hour), ActorID: "admin"}))
+	assert.Equal(t, []string{"4", "2", "1"}, ids(&Query{Subject: "1234"}), "actor or target")
+	assert.Equal(t, []string{"2", "1"}, ids(&Query{Subject: "1234", BeforeSequence: 4}))
+
+	entries, err := repo.GetEntries(ctx, &Query{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, &Entry{
+		ID:           "4",
+		Sequence:     4,
+		Time:         start.Add(3 * time.Hour),
+		ActorID:      "admin",
+		ActorIsAdmin: true,
+		Action:       ActionUserDeleted,
+		TargetUserID: "1234",
+		BeforeHash:   "b",
+		AfterHash:    "c",
+		ClientIP:     "192.0.2.1",
+		RequestID:    "request-1",
+	}, entries[0])
+
+	assert.ErrorIs(t, repo.PutEntry(ctx, &Entry{ID: "5", Sequence: 4, Time: start}), utils.VersionConflict, "sequences are unique")
+
+	last, err := repo.GetLastEntry(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "4", last.ID)
+
+	after, err := repo.GetEntriesAfter(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, after, 2)
+	assert.Equal(t, []int64{2, 3}, []int64{after[0].Sequence, after[1].Sequence})
+
+	erasure := &Entry{
+		ID:           "6",
+		Sequence:     5,
+		Time:         start.Add(4 * time.Hour),
+		ActorID:      "admin",
+		Action:       ActionUserErased,
+		TargetUserID: "erased-1",
+		Scrubs:       []*ScrubbedEntry{{Sequence: 2, Hash: "scrubbed-hash"}},
+	}
+	assert.NoError(t, repo.PutEntry(ctx, erasure))
+	entries, err = repo.GetEntries(ctx, &Query{Action: ActionUserErased, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, erasure, entries[0])
+
+	scrubbed := after[0]
+	assert.True(t, scrubEntry(scrubbed, "1234", "erased-1"))
+	assert.NoError(t, repo.PutScrubbedEntry(ctx, scrubbed))
+	assert.ErrorIs(t, repo.PutScrubbedEntry(ctx, &Entry{ID: "missing"}), utils.ErrNotFound)
+
+	assert.Equal(t, []string{"4", "1"}, ids(&Query{Subject: "1234"}), "only the given entry is scrubbed")
+	entries, err = repo.GetEntries(ctx, &Query{Subject: "erased-1", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, &Entry{
+		ID:           "2",
+		Sequence:     2,
+		Time:         start.Add(time.Hour),
+		ActorID:      "erased-1",
+		Action:       ActionUserUpdated,
+		TargetUserID: "erased-1",
+		Scrubbed:     true,
+	}, entries[1])
+}
+
+func TestScrubEntry(t *testing.T) {
+	entry := &Entry{ActorID: "admin", ClientIP: "192.0.2.1", TargetUserID: "1234", BeforeHash: "a", AfterHash: "b", Details: "d", PersonalSalt: "salt"}
+
+	assert.False(t, scrubEntry(entry, "5678", "erased-1"))
+	assert.True(t, scrubEntry(entry, "1234", "erased-1"))
+	assert.Equal(t, &Entry{ActorID: "admin", ClientIP: "192.0.2.1", TargetUserID: "erased-1", Scrubbed: true}, entry,
+		"the client IP belongs to the actor")
+}
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, NewMemoryRepo())
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "audit.db")+"?_time_format=sqlite")
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	runner, err := migrations.NewRunner(db, migrations.SQLITE, nil)
+	assert.NoError(t, err)
+	_, err = runner.Up(context.Background())
+	assert.NoError(t, err)
+
+	testRepository(t, NewSQLRepo(db, sqlerr.IsSQLiteUniqueViolation))
+}
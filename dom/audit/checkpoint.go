@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+)
+
+// DefaultCheckpointInterval is the number of entries between signed checkpoints
+const DefaultCheckpointInterval = 100
+
+// IsCheckpoint reports whether the entry at the sequence is signed when checkpoints are taken every interval entries
+func IsCheckpoint(sequence, interval int64) bool {
+	return interval > 0 && sequence%interval == 0
+}
+
+// SignCheckpoint signs the hash of the entry, the hash covers the whole chain up to the entry,
+// so rewriting the chain before a checkpoint requires the private key
+func SignCheckpoint(key ed25519.PrivateKey, entry *Entry) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(entry.Hash)))
+}
+
+// VerifyCheckpoint reports whether the signature of the entry was made by the key over its hash
+func VerifyCheckpoint(key ed25519.PublicKey, entry *Entry) bool {
+	signature, err := base64.StdEncoding.DecodeString(entry.Signature)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(key, []byte(entry.Hash), signature)
+}
+
+// ParsePrivateKeyPEM reads a PKCS #8 encoded Ed25519 private key, e.g. generated by `openssl genpkey -algorithm ed25519`
+func ParsePrivateKeyPEM(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("audit signing key is %T, not an Ed25519 key", key)
+	}
+
+	return private, nil
+}
+
+// ParsePublicKeyPEM reads a PKIX encoded Ed25519 public key, so the log can be verified without the private key
+func ParsePublicKeyPEM(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("audit verification key is %T, not an Ed25519 key", key)
+	}
+
+	return public, nil
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// MemoryRepository is a thread-safe in-memory audit log, intended for tests and local development
+type MemoryRepository struct {
+	BaseRepository
+
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+func NewMemoryRepo() *MemoryRepository {
+	return &MemoryRepository{}
+}
+
+// PutEntry only appends to the end of the chain, entries are kept in sequence order
+func (repo *MemoryRepository) PutEntry(_ context.Context, entry *Entry) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if n := len(repo.entries); n > 0 && entry.Sequence <= repo.entries[n-1].Sequence {
+		return fmt.Errorf("audit sequence %d is taken err: %w", entry.Sequence, utils.VersionConflict)
+	}
+
+	repo.entries = append(repo.entries, *entry)
+
+	return nil
+}
+
+func (repo *MemoryRepository) GetEntries(_ context.Context, query *Query) ([]*Entry, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	entries := []*Entry{}
+	for i := len(repo.entries) - 1; i >= 0 && len(entries) < query.Limit; i-- {
+		entry := repo.entries[i]
+		if query.Matches(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (repo *MemoryRepository) GetLastEntry(context.Context) (*Entry, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if len(repo.entries) == 0 {
+		return nil, utils.ErrNotFound
+	}
+
+	entry := repo.entries[len(repo.entries)-1]
+	return &entry, nil
+}
+
+func (repo *MemoryRepository) GetEntriesAfter(_ context.Context, sequence int64, limit int) ([]*Entry, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	entries := []*Entry{}
+	for _, entry := range repo.entries {
+		if len(entries) == limit {
+			break
+		}
+		if entry.Sequence > sequence {
+			entry := entry
+			entries = append(entries, &entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (repo *MemoryRepository) CountUnsequenced(context.Context) (int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	count := 0
+	for _, entry := range repo.entries {
+		if entry.Sequence == 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (repo *MemoryRepository) PutScrubbedEntry(_ context.Context, entry *Entry) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.entries {
+		if repo.entries[i].ID == entry.ID {
+			setScrubbedFields(&repo.entries[i], entry)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("audit entry %s err: %w", entry.ID, utils.ErrNotFound)
+}
@@ -0,0 +1,245 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoRepository struct {
+	BaseRepository
+
+	Collection *mongo.Collection
+}
+
+type MongoRepoParams struct {
+	Host           string
+	Database       string
+	CollectionName string
+}
+
+func NewMongoRepo(ctx context.Context, params *MongoRepoParams) (*MongoRepository, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(params.Host))
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(params.Database).Collection(params.CollectionName)
+
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// entries recorded before the log was chained have no sequence
+			Keys: bson.D{{Key: "sequence", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"sequence": bson.M{"$exists": true}}),
+		},
+		{
+			Keys: bson.D{{Key: "time", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "time", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "targetUserId", Value: 1}, {Key: "time", Value: -1}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit indexes err:%w", err)
+	}
+
+	err = backfillSequences(ctx, collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to number audit entries err:%w", err)
+	}
+
+	return &MongoRepository{Collection: collection}, nil
+}
+
+// backfillSequences numbers the entries recorded before the log was chained by time, like the chaining migration
+// of the SQL backends, so Verify walks them. Once chained entries exist the leading sequences are taken and the
+// entries are left unnumbered, Verify reports them as unsequenced. Every instance numbers the entries in the same
+// order and only sets missing sequences, so instances starting together agree
+func backfillSequences(ctx context.Context, collection *mongo.Collection) error {
+	unsequenced := bson.M{"sequence": bson.M{"$exists": false}}
+
+	count, err := collection.CountDocuments(ctx, unsequenced)
+	if err != nil || count == 0 {
+		return err
+	}
+
+	chained, err := collection.CountDocuments(ctx, bson.M{"sequence": bson.M{"$exists": true}, "hash": bson.M{"$gt": ""}})
+	if err != nil || chained > 0 {
+		return err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	sequence := int64(0)
+	for cursor.Next(ctx) {
+		sequence++
+
+		var doc struct {
+			ID string `bson:"_id"`
+		}
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": doc.ID, "sequence": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"sequence": sequence}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (repo *MongoRepository) PutEntry(ctx context.Context, entry *Entry) error {
+	_, err := repo.Collection.InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("audit sequence %d is taken err: %w", entry.Sequence, utils.VersionConflict)
+	}
+
+	return err
+}
+
+func (repo *MongoRepository) GetEntries(ctx context.Context, query *Query) ([]*Entry, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sequence", Value: -1}, {Key: "time", Value: -1}}).
+		SetLimit(int64(query.Limit))
+
+	cursor, err := repo.Collection.Find(ctx, entriesFilter(query), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*Entry{}
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to umarshal bson audit documents err:%w", err)
+	}
+
+	return entries, nil
+}
+
+func (repo *MongoRepository) GetLastEntry(ctx context.Context) (*Entry, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}})
+
+	res := repo.Collection.FindOne(ctx, bson.M{"sequence": bson.M{"$exists": true}}, opts)
+	if res.Err() != nil {
+		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, res.Err()
+	}
+
+	var entry *Entry
+	err := res.Decode(&entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to umarshal bson audit document err:%w", err)
+	}
+
+	return entry, nil
+}
+
+func (repo *MongoRepository) GetEntriesAfter(ctx context.Context, sequence int64, limit int) ([]*Entry, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sequence", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := repo.Collection.Find(ctx, bson.M{"sequence": bson.M{"$gt": sequence}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*Entry{}
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to umarshal bson audit documents err:%w", err)
+	}
+
+	return entries, nil
+}
+
+func (repo *MongoRepository) CountUnsequenced(ctx context.Context) (int, error) {
+	count, err := repo.Collection.CountDocuments(ctx, bson.M{"sequence": bson.M{"$exists": false}})
+
+	return int(count), err
+}
+
+func (repo *MongoRepository) PutScrubbedEntry(ctx context.Context, entry *Entry) error {
+	res, err := repo.Collection.UpdateOne(ctx,
+		bson.M{"_id": entry.ID},
+		bson.M{
+			"$set": bson.M{
+				"actorId":      entry.ActorID,
+				"clientIp":     entry.ClientIP,
+				"targetUserId": entry.TargetUserID,
+				"beforeHash":   entry.BeforeHash,
+				"afterHash":    entry.AfterHash,
+				"details":      entry.Details,
+				"scrubbed":     true,
+			},
+			"$unset": bson.M{"personalSalt": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("audit entry %s err: %w", entry.ID, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func entriesFilter(query *Query) bson.M {
+	filter := bson.M{}
+
+	timeRange := bson.M{}
+	if !query.From.IsZero() {
+		timeRange["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		timeRange["$lt"] = query.To
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+
+	if query.ActorID != "" {
+		filter["actorId"] = query.ActorID
+	}
+	if query.TargetUserID != "" {
+		filter["targetUserId"] = query.TargetUserID
+	}
+	if query.Subject != "" {
+		filter["$or"] = bson.A{bson.M{"actorId": query.Subject}, bson.M{"targetUserId": query.Subject}}
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	if query.BeforeSequence > 0 {
+		filter["sequence"] = bson.M{"$lt": query.BeforeSequence}
+	}
+
+	return filter
+}
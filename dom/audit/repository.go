@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+)
+
+var NotImplementedErr = fmt.Errorf("this method is not implemented")
+
+// Repository is append-only, entries are never removed and only updated by PutScrubbedEntry
+type Repository interface {
+	// PutEntry appends the entry, failing with utils.VersionConflict when another entry already took its Sequence
+	PutEntry(ctx context.Context, entry *Entry) error
+	// GetEntries returns the entries matching the query, newest first
+	GetEntries(ctx context.Context, query *Query) ([]*Entry, error)
+	// GetLastEntry returns the entry with the highest sequence or utils.ErrNotFound when the log is empty
+	GetLastEntry(ctx context.Context) (*Entry, error)
+	// GetEntriesAfter returns up to limit entries following the sequence, in sequence order
+	GetEntriesAfter(ctx context.Context, sequence int64, limit int) ([]*Entry, error)
+	// CountUnsequenced returns the number of entries recorded before the log was chained which were never
+	// numbered, GetEntriesAfter does not return them
+	CountUnsequenced(ctx context.Context) (int, error)
+	// PutScrubbedEntry overwrites the personal fields of the entry with its scrubbed values, the entry is marked
+	// Scrubbed and its PersonalSalt removed
+	PutScrubbedEntry(ctx context.Context, entry *Entry) error
+}
+
+type BaseRepository struct{}
+
+func (repo *BaseRepository) PutEntry(context.Context, *Entry) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) GetEntries(context.Context, *Query) ([]*Entry, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) GetLastEntry(context.Context) (*Entry, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) GetEntriesAfter(context.Context, int64, int) ([]*Entry, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) CountUnsequenced(context.Context) (int, error) {
+	return 0, NotImplementedErr
+}
+
+func (repo *BaseRepository) PutScrubbedEntry(context.Context, *Entry) error {
+	return NotImplementedErr
+}
@@ -0,0 +1,247 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// maxAppendAttempts bounds the retries of an entry racing other replicas for the next sequence
+const maxAppendAttempts = 5
+
+type AuditService interface {
+	// Record appends the entry to the end of the hash chain, the actor, client IP and request ID default to
+	// the request scoped values of the context
+	Record(ctx context.Context, entry *Entry) error
+	GetEntries(ctx context.Context, query *Query) ([]*Entry, error)
+	// Scrub replaces the user by the pseudonym in every entry concerning the user, the chain stays verifiable
+	Scrub(ctx context.Context, userID, pseudonym string) (int, error)
+}
+
+type Resources struct {
+	Repo Repository
+	// SigningKey signs every CheckpointInterval'th entry, optional
+	SigningKey         ed25519.PrivateKey
+	CheckpointInterval int64
+}
+
+type service struct {
+	*Resources
+
+	// appends of this replica are serialised, other replicas are detected by the repository
+	mu sync.Mutex
+}
+
+func NewService(r *Resources) (*service, error) {
+	if r.CheckpointInterval == 0 {
+		r.CheckpointInterval = DefaultCheckpointInterval
+	}
+
+	return &service{
+		Resources: r,
+	}, nil
+}
+
+func (svc *service) Record(ctx context.Context, entry *Entry) error {
+	entry.ID = uuid.NewString()
+	// mongo stores milliseconds, the hash must survive the round trip
+	entry.Time = time.Now().UTC().Truncate(time.Millisecond)
+
+	if entry.ActorID == "" {
+		entry.ActorID = utils.CallerID(ctx)
+	}
+	if entry.ActorID == utils.CallerID(ctx) {
+		entry.ActorIsAdmin = utils.CallerIsAdmin(ctx)
+	}
+	if entry.ClientIP == "" {
+		entry.ClientIP = utils.ClientIP(ctx)
+	}
+	if entry.RequestID == "" {
+		entry.RequestID = utils.RequestID(ctx)
+	}
+
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return fmt.Errorf("failed to generate audit salt err:%w", err)
+	}
+	entry.PersonalSalt = hex.EncodeToString(salt)
+	entry.PersonalDigest = entry.ComputePersonalDigest()
+
+	err = svc.appendEntry(ctx, entry)
+	if err != nil {
+		utils.ContextLogger(ctx, slog.Default()).
+			With("error", err).
+			With("action", entry.Action).
+			With("target-user-id", entry.TargetUserID).
+			Error("failed to put audit entry into repository")
+
+		return err
+	}
+
+	return nil
+}
+
+// appendEntry links the entry to the last entry of the chain, retrying when another replica appended first
+func (svc *service) appendEntry(ctx context.Context, entry *Entry) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	var (
+		last *Entry
+		err  error
+	)
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		entry.Sequence = 1
+		entry.PrevHash = ""
+
+		last, err = svc.Repo.GetLastEntry(ctx)
+		if err != nil && !errors.Is(err, utils.ErrNotFound) {
+			return err
+		}
+		if last != nil {
+			entry.Sequence = last.Sequence + 1
+			entry.PrevHash = last.Hash
+		}
+
+		entry.Hash = entry.ComputeHash()
+		entry.Signature = ""
+		if svc.SigningKey != nil && IsCheckpoint(entry.Sequence, svc.CheckpointInterval) {
+			entry.Signature = SignCheckpoint(svc.SigningKey, entry)
+		}
+
+		err = svc.Repo.PutEntry(ctx, entry)
+		if !errors.Is(err, utils.VersionConflict) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("failed to append audit entry after %d attempts err: %w", maxAppendAttempts, err)
+}
+
+func (svc *service) GetEntries(ctx context.Context, query *Query) ([]*Entry, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	return svc.Repo.GetEntries(ctx, query)
+}
+
+// Scrub replaces the user by the pseudonym in every entry concerning the user. The hash every entry has once
+// scrubbed is committed to by a chained USER_ERASED entry, recorded before the entries are rewritten, so Verify
+// tells scrubbing apart from tampering. Entries which no longer match their hash are not scrubbed, as that would
+// cover up the tampering, and a scrub interrupted before every entry was rewritten is completed by scrubbing again
+func (svc *service) Scrub(ctx context.Context, userID, pseudonym string) (int, error) {
+	if userID == "" || pseudonym == "" {
+		return 0, fmt.Errorf("%w - user id and pseudonym are required", utils.ValidationErr)
+	}
+
+	entries, err := svc.pageEntries(ctx, &Query{Subject: userID})
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	listings, err := svc.scrubListings(ctx, entries)
+	if err != nil {
+		return 0, err
+	}
+
+	erasure := &Entry{Action: ActionUserErased, TargetUserID: pseudonym, Scrubs: []*ScrubbedEntry{}}
+	// oldest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !intactBeforeScrubbing(entry, listings) {
+			return 0, fmt.Errorf("audit entry %d was modified after it was recorded, run verify-audit", entry.Sequence)
+		}
+
+		scrubEntry(entry, userID, pseudonym)
+		// entries recorded before the log was chained have no sequence and are not verified
+		if entry.Sequence > 0 {
+			erasure.Scrubs = append(erasure.Scrubs, &ScrubbedEntry{Sequence: entry.Sequence, Hash: entry.ScrubbedHash()})
+		}
+	}
+
+	err = svc.Record(ctx, erasure)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		err = svc.Repo.PutScrubbedEntry(ctx, entry)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scrub audit entry %d err:%w", entry.Sequence, err)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// pageEntries returns every entry matching the filters of the query, newest first
+func (svc *service) pageEntries(ctx context.Context, query *Query) ([]*Entry, error) {
+	query.Limit = MaxLimit
+
+	entries := []*Entry{}
+	for {
+		page, err := svc.Repo.GetEntries(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+
+		if len(page) < query.Limit || page[len(page)-1].Sequence == 0 {
+			return entries, nil
+		}
+		query.BeforeSequence = page[len(page)-1].Sequence
+	}
+}
+
+// scrubListings returns the hash the latest erasure committed to for every entry which was scrubbed before,
+// only read when one of the entries was already scrubbed, e.g. when erasing an admin who updated an erased user
+func (svc *service) scrubListings(ctx context.Context, entries []*Entry) (map[int64]string, error) {
+	listings := map[int64]string{}
+
+	for _, entry := range entries {
+		if !entry.Scrubbed {
+			continue
+		}
+
+		erasures, err := svc.pageEntries(ctx, &Query{Action: ActionUserErased})
+		if err != nil {
+			return nil, err
+		}
+		for _, erasure := range erasures {
+			for _, scrub := range erasure.Scrubs {
+				if _, ok := listings[scrub.Sequence]; !ok {
+					listings[scrub.Sequence] = scrub.Hash
+				}
+			}
+		}
+
+		return listings, nil
+	}
+
+	return listings, nil
+}
+
+// intactBeforeScrubbing reports whether the entry matches its hash or, once scrubbed, the hash of its latest erasure
+func intactBeforeScrubbing(entry *Entry, listings map[int64]string) bool {
+	switch {
+	case entry.Hash == "":
+		return true
+	case !entry.Scrubbed:
+		return hashIntact(entry)
+	case entry.PersonalDigest != "" && entry.ComputeHash() != entry.Hash:
+		return false
+	}
+
+	return listings[entry.Sequence] == entry.ScrubbedHash()
+}
@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const entryColumns = `id, seq, time, actor_id, actor_is_admin, action, target_user_id, before_hash, after_hash, client_ip, request_id, details, prev_hash, hash, signature, personal_digest, personal_salt, scrubbed, scrubs`
+
+// SQLRepository stores the audit log in the 'audit_log' table, the unique 'seq' column orders the chain
+type SQLRepository struct {
+	BaseRepository
+
+	DB *sql.DB
+
+	// isUniqueViolation reports whether the driver error is a unique constraint violation
+	isUniqueViolation func(error) bool
+}
+
+// NewSQLRepo stores the audit log in db, isUniqueViolation recognises the unique violations of its driver,
+// e.g. sqlerr.IsPostgresUniqueViolation
+func NewSQLRepo(db *sql.DB, isUniqueViolation func(error) bool) *SQLRepository {
+	return &SQLRepository{DB: db, isUniqueViolation: isUniqueViolation}
+}
+
+func (repo *SQLRepository) PutEntry(ctx context.Context, entry *Entry) error {
+	_, err := repo.DB.ExecContext(ctx, `INSERT INTO audit_log (`+entryColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		entry.ID,
+		entry.Sequence,
+		entry.Time,
+		entry.ActorID,
+		entry.ActorIsAdmin,
+		entry.Action,
+		entry.TargetUserID,
+		entry.BeforeHash,
+		entry.AfterHash,
+		entry.ClientIP,
+		entry.RequestID,
+		entry.Details,
+		entry.PrevHash,
+		entry.Hash,
+		entry.Signature,
+		entry.PersonalDigest,
+		entry.PersonalSalt,
+		entry.Scrubbed,
+		scrubsColumn(entry.Scrubs),
+	)
+	if repo.isUniqueViolation(err) {
+		return fmt.Errorf("audit sequence %d is taken err: %w", entry.Sequence, utils.VersionConflict)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *SQLRepository) GetEntries(ctx context.Context, query *Query) ([]*Entry, error) {
+	where, args := entriesWhere(query)
+
+	args = append(args, query.Limit)
+	statement := fmt.Sprintf(`SELECT %s FROM audit_log %s ORDER BY seq DESC LIMIT $%d`,
+		entryColumns, where, len(args),
+	)
+
+	return repo.queryEntries(ctx, statement, args...)
+}
+
+func (repo *SQLRepository) GetLastEntry(ctx context.Context) (*Entry, error) {
+	entries, err := repo.queryEntries(ctx, `SELECT `+entryColumns+` FROM audit_log ORDER BY seq DESC LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, utils.ErrNotFound
+	}
+
+	return entries[0], nil
+}
+
+func (repo *SQLRepository) GetEntriesAfter(ctx context.Context, sequence int64, limit int) ([]*Entry, error) {
+	return repo.queryEntries(ctx, `SELECT `+entryColumns+` FROM audit_log WHERE seq > $1 ORDER BY seq LIMIT $2`, sequence, limit)
+}
+
+// CountUnsequenced is zero once the chaining migration numbered the existing entries
+func (repo *SQLRepository) CountUnsequenced(ctx context.Context) (int, error) {
+	var count int
+	err := repo.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log WHERE seq IS NULL`).Scan(&count)
+
+	return count, err
+}
+
+func (repo *SQLRepository) PutScrubbedEntry(ctx context.Context, entry *Entry) error {
+	res, err := repo.DB.ExecContext(ctx, `UPDATE audit_log SET
+		actor_id = $2,
+		client_ip = $3,
+		target_user_id = $4,
+		before_hash = $5,
+		after_hash = $6,
+		details = $7,
+		personal_salt = '',
+		scrubbed = TRUE
+		WHERE id = $1`,
+		entry.ID,
+		entry.ActorID,
+		entry.ClientIP,
+		entry.TargetUserID,
+		entry.BeforeHash,
+		entry.AfterHash,
+		entry.Details,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("audit entry %s err: %w", entry.ID, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+// scrubsColumn stores the scrubbed entries listed by an erasure as JSON, other entries store an empty string
+func scrubsColumn(scrubs []*ScrubbedEntry) string {
+	if len(scrubs) == 0 {
+		return ""
+	}
+
+	return utils.ToJSON(scrubs)
+}
+
+func (repo *SQLRepository) queryEntries(ctx context.Context, statement string, args ...any) ([]*Entry, error) {
+	rows, err := repo.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*Entry{}
+	for rows.Next() {
+		entry := &Entry{}
+		var scrubs string
+		err := rows.Scan(
+			&entry.ID,
+			&entry.Sequence,
+			&entry.Time,
+			&entry.ActorID,
+			&entry.ActorIsAdmin,
+			&entry.Action,
+			&entry.TargetUserID,
+			&entry.BeforeHash,
+			&entry.AfterHash,
+			&entry.ClientIP,
+			&entry.RequestID,
+			&entry.Details,
+			&entry.PrevHash,
+			&entry.Hash,
+			&entry.Signature,
+			&entry.PersonalDigest,
+			&entry.PersonalSalt,
+			&entry.Scrubbed,
+			&scrubs,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit row err:%w", err)
+		}
+		if scrubs != "" {
+			err = json.Unmarshal([]byte(scrubs), &entry.Scrubs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal scrubbed entries of audit row err:%w", err)
+			}
+		}
+		entry.Time = entry.Time.UTC()
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// entriesWhere builds the WHERE clause and its arguments for the query filters
+func entriesWhere(query *Query) (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !query.From.IsZero() {
+		conditions = append(conditions, "time >= "+arg(query.From.UTC()))
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "time < "+arg(query.To.UTC()))
+	}
+	if query.ActorID != "" {
+		conditions = append(conditions, "actor_id = "+arg(query.ActorID))
+	}
+	if query.TargetUserID != "" {
+		conditions = append(conditions, "target_user_id = "+arg(query.TargetUserID))
+	}
+	if query.Subject != "" {
+		subject := arg(query.Subject)
+		conditions = append(conditions, "(actor_id = "+subject+" OR target_user_id = "+subject+")")
+	}
+	if query.Action != "" {
+		conditions = append(conditions, "action = "+arg(query.Action))
+	}
+	if query.BeforeSequence > 0 {
+		conditions = append(conditions, "seq < "+arg(query.BeforeSequence))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
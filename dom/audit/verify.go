@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"slices"
+)
+
+const verifyBatchSize = 500
+
+// VerifyOptions configure the checks of Verify beyond the hash chain
+type VerifyOptions struct {
+	// PublicKey verifies checkpoint signatures, without it signatures are not checked
+	PublicKey ed25519.PublicKey
+	// CheckpointInterval requires a signature on every checkpoint entry when a PublicKey is given,
+	// it must match the interval the entries were recorded with
+	CheckpointInterval int64
+}
+
+// BrokenLink is the first entry of the chain which fails verification
+type BrokenLink struct {
+	Sequence int64  `json:"sequence"`
+	EntryID  string `json:"entryId"`
+	Reason   string `json:"reason"`
+}
+
+type VerifyReport struct {
+	// Verified is the number of chained entries whose hash and link are intact
+	Verified int `json:"verified"`
+	// Unchained is the number of leading entries recorded before the log was chained
+	Unchained int `json:"unchained"`
+	// Unsequenced is the number of entries recorded before the log was chained which were never numbered,
+	// they are not part of the walk and are not verified
+	Unsequenced int `json:"unsequenced"`
+	// Checkpoints is the number of verified signatures
+	Checkpoints int `json:"checkpoints"`
+	// Scrubbed is the number of entries whose personal fields were scrubbed, they are checked against the hash
+	// committed to by the erasure which scrubbed them as their digest no longer matches
+	Scrubbed int `json:"scrubbed"`
+	// HeadSequence and HeadHash identify the last verified entry, recording them elsewhere
+	// detects entries removed from the end of the log
+	HeadSequence int64       `json:"headSequence"`
+	HeadHash     string      `json:"headHash"`
+	Broken       *BrokenLink `json:"broken,omitempty"`
+}
+
+// Verify walks the chain in sequence order and stops at the first broken link, an entry which was modified,
+// removed, reordered or lost its checkpoint signature breaks the chain. Scrubbed entries must be listed by a later
+// USER_ERASED entry and still have the hash it committed to, see AuditService.Scrub
+func Verify(ctx context.Context, repo Repository, opts VerifyOptions) (*VerifyReport, error) {
+	unsequenced, err := repo.CountUnsequenced(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Unsequenced: unsequenced}
+
+	var (
+		previous *Entry
+		chained  bool
+		scrubbed = []*Entry{}
+		listings = map[int64]*scrubListing{}
+	)
+	for {
+		after := int64(0)
+		if previous != nil {
+			after = previous.Sequence
+		}
+
+		entries, err := repo.GetEntriesAfter(ctx, after, verifyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			reason := checkLink(previous, entry, chained, opts)
+			if reason == "" {
+				reason = listScrubs(entry, listings)
+			}
+			if reason != "" {
+				report.Broken = &BrokenLink{Sequence: entry.Sequence, EntryID: entry.ID, Reason: reason}
+				return report, nil
+			}
+
+			if entry.Hash == "" {
+				report.Unchained++
+			} else {
+				chained = true
+				report.Verified++
+				report.HeadSequence = entry.Sequence
+				report.HeadHash = entry.Hash
+				if entry.Signature != "" && opts.PublicKey != nil {
+					report.Checkpoints++
+				}
+			}
+			if entry.Scrubbed {
+				scrubbed = append(scrubbed, entry)
+			}
+			previous = entry
+		}
+
+		if len(entries) < verifyBatchSize {
+			break
+		}
+	}
+
+	// an erasure follows the entries it scrubbed, so they are only checked once the whole chain was read
+	for _, entry := range scrubbed {
+		if reason := checkScrubbed(entry, listings[entry.Sequence]); reason != "" {
+			report.Broken = &BrokenLink{Sequence: entry.Sequence, EntryID: entry.ID, Reason: reason}
+			return report, nil
+		}
+		report.Scrubbed++
+	}
+	unscrubbed := int64(0)
+	for sequence, listing := range listings {
+		if !listing.seen && (unscrubbed == 0 || sequence < unscrubbed) {
+			unscrubbed = sequence
+		}
+	}
+	if unscrubbed > 0 {
+		report.Broken = &BrokenLink{Sequence: unscrubbed, Reason: "entry listed by an erasure is not scrubbed"}
+	}
+
+	return report, nil
+}
+
+// scrubListing is what the erasures listing a scrubbed entry committed to
+type scrubListing struct {
+	// hash is the hash committed to by the latest erasure, the entry may have been scrubbed again since the earlier
+	hash       string
+	pseudonyms []string
+	seen       bool
+}
+
+// listScrubs records the entries scrubbed by the erasure, returning why the erasure is invalid if it is
+func listScrubs(entry *Entry, listings map[int64]*scrubListing) string {
+	if len(entry.Scrubs) > 0 && entry.Action != ActionUserErased {
+		return "only erasures list scrubbed entries"
+	}
+
+	for _, scrub := range entry.Scrubs {
+		if scrub.Sequence <= 0 || scrub.Sequence >= entry.Sequence {
+			return fmt.Sprintf("erasure lists entry %d which does not precede it", scrub.Sequence)
+		}
+
+		listing, ok := listings[scrub.Sequence]
+		if !ok {
+			listing = &scrubListing{}
+			listings[scrub.Sequence] = listing
+		}
+		listing.hash = scrub.Hash
+		listing.pseudonyms = append(listing.pseudonyms, entry.TargetUserID)
+	}
+
+	return ""
+}
+
+// checkScrubbed returns why the scrubbed entry does not match what its erasures committed to, if it does not
+func checkScrubbed(entry *Entry, listing *scrubListing) string {
+	if listing == nil {
+		return "scrubbed entry is not listed by an erasure"
+	}
+	listing.seen = true
+
+	actor := slices.Contains(listing.pseudonyms, entry.ActorID)
+	target := slices.Contains(listing.pseudonyms, entry.TargetUserID)
+	switch {
+	case entry.ScrubbedHash() != listing.hash:
+		return "scrubbed entry was modified after it was scrubbed"
+	case !actor && !target:
+		return "scrubbed entry does not carry the pseudonym of its erasure"
+	case actor && entry.ClientIP != "":
+		return "scrubbed entry kept the client IP of the erased actor"
+	case target && (entry.BeforeHash != "" || entry.AfterHash != "" || entry.Details != ""):
+		return "scrubbed entry kept the personal fields of the erased target"
+	}
+
+	return ""
+}
+
+// checkLink returns why the entry does not follow the previous entry of the chain, if it does not
+func checkLink(previous, entry *Entry, chained bool, opts VerifyOptions) string {
+	expected := int64(1)
+	prevHash := ""
+	if previous != nil {
+		expected = previous.Sequence + 1
+		prevHash = previous.Hash
+	}
+
+	switch {
+	case entry.Sequence != expected:
+		return fmt.Sprintf("expected sequence %d, entries are missing", expected)
+	case entry.Hash == "" && chained:
+		return "entry is not chained"
+	case entry.Hash == "":
+		// recorded before the log was chained
+		return ""
+	case entry.PrevHash != prevHash:
+		return "entry does not link to the previous entry"
+	case !hashIntact(entry):
+		return "entry was modified after it was recorded"
+	case opts.PublicKey == nil:
+		return ""
+	case entry.Signature != "" && !VerifyCheckpoint(opts.PublicKey, entry):
+		return "checkpoint signature is invalid"
+	case entry.Signature == "" && IsCheckpoint(entry.Sequence, opts.CheckpointInterval):
+		return "checkpoint is not signed"
+	}
+
+	return ""
+}
+
+// hashIntact reports whether the entry still matches its hash and personal digest, scrubbed entries are
+// matched against the hash their erasure committed to by checkScrubbed
+func hashIntact(entry *Entry) bool {
+	switch {
+	case entry.Scrubbed && entry.PersonalDigest == "":
+		// recorded before personal digests, the hash covered the fields which were scrubbed
+		return true
+	case entry.ComputeHash() != entry.Hash:
+		return false
+	case entry.PersonalDigest != "" && !entry.Scrubbed:
+		return entry.ComputePersonalDigest() == entry.PersonalDigest
+	}
+
+	return true
+}
@@ -0,0 +1,441 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/jackmcguire1/UserService/pkg/sqlerr"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newChainedService(t *testing.T, repo Repository, key ed25519.PrivateKey) *service {
+	svc, err := NewService(&Resources{Repo: repo, SigningKey: key, CheckpointInterval: 2})
+	assert.NoError(t, err)
+
+	for _, target := range []string{"1", "2", "3", "4", "5"} {
+		assert.NoError(t, svc.Record(context.Background(), &Entry{ActorID: "admin", Action: ActionUserUpdated, TargetUserID: target}))
+	}
+
+	return svc
+}
+
+func TestVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	opts := VerifyOptions{PublicKey: public, CheckpointInterval: 2}
+
+	tests := []struct {
+		name     string
+		tamper   func(repo *MemoryRepository)
+		opts     VerifyOptions
+		broken   int64
+		reason   string
+		verified int
+	}{
+		{name: "intact", tamper: func(*MemoryRepository) {}, opts: opts},
+		{name: "intact without key", tamper: func(*MemoryRepository) {}},
+		{
+			name:     "modified",
+			tamper:   func(repo *MemoryRepository) { repo.entries[2].ActorID = "someone else" },
+			opts:     opts,
+			broken:   3,
+			reason:   "entry was modified after it was recorded",
+			verified: 2,
+		},
+		{
+			name: "rehashed",
+			tamper: func(repo *MemoryRepository) {
+				repo.entries[2].TargetUserID = "6"
+				repo.entries[2].PersonalDigest = repo.entries[2].ComputePersonalDigest()
+				repo.entries[2].Hash = repo.entries[2].ComputeHash()
+			},
+			opts:     opts,
+			broken:   4,
+			reason:   "entry does not link to the previous entry",
+			verified: 3,
+		},
+		{
+			name: "marked scrubbed",
+			tamper: func(repo *MemoryRepository) {
+				repo.entries[2].TargetUserID = "someone else"
+				repo.entries[2].PersonalSalt = ""
+				repo.entries[2].Scrubbed = true
+			},
+			opts:     opts,
+			broken:   3,
+			reason:   "scrubbed entry is not listed by an erasure",
+			verified: 5,
+		},
+		{
+			name: "scrubbed without salt",
+			tamper: func(repo *MemoryRepository) {
+				repo.entries[2].TargetUserID = "erased-1"
+				repo.entries[2].PersonalSalt = ""
+			},
+			opts:     opts,
+			broken:   3,
+			reason:   "entry was modified after it was recorded",
+			verified: 2,
+		},
+		{
+			name:     "removed",
+			tamper:   func(repo *MemoryRepository) { repo.entries = append(repo.entries[:1], repo.entries[2:]...) },
+			opts:     opts,
+			broken:   3,
+			reason:   "expected sequence 2, entries are missing",
+			verified: 1,
+		},
+		{
+			name:     "unsigned checkpoint",
+			tamper:   func(repo *MemoryRepository) { repo.entries[3].Signature = "" },
+			opts:     opts,
+			broken:   4,
+			reason:   "checkpoint is not signed",
+			verified: 3,
+		},
+		{
+			name:     "forged checkpoint",
+			tamper:   func(repo *MemoryRepository) { repo.entries[1].Signature = SignCheckpoint(otherKey, &repo.entries[1]) },
+			opts:     opts,
+			broken:   2,
+			reason:   "checkpoint signature is invalid",
+			verified: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewMemoryRepo()
+			newChainedService(t, repo, private)
+			tc.tamper(repo)
+
+			report, err := Verify(context.Background(), repo, tc.opts)
+			assert.NoError(t, err)
+
+			if tc.reason == "" {
+				assert.Nil(t, report.Broken)
+				assert.Equal(t, 5, report.Verified)
+				assert.Equal(t, int64(5), report.HeadSequence)
+				assert.Equal(t, repo.entries[4].Hash, report.HeadHash)
+				if tc.opts.PublicKey != nil {
+					assert.Equal(t, 2, report.Checkpoints)
+				}
+				return
+			}
+
+			assert.NotNil(t, report.Broken)
+			assert.Equal(t, tc.broken, report.Broken.Sequence)
+			assert.Equal(t, tc.reason, report.Broken.Reason)
+			assert.Equal(t, tc.verified, report.Verified, "entries before the broken link are verified")
+		})
+	}
+}
+
+func TestVerifySkipsEntriesRecordedBeforeChaining(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	assert.NoError(t, repo.PutEntry(ctx, &Entry{ID: "legacy", Sequence: 1, Action: ActionUserCreated}))
+
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.Record(ctx, &Entry{ActorID: "admin", Action: ActionUserUpdated}))
+
+	report, err := Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, 1, report.Unchained)
+	assert.Equal(t, 1, report.Verified)
+
+	assert.NoError(t, repo.PutEntry(ctx, &Entry{ID: "unchained", Sequence: 3, Action: ActionUserCreated}))
+	report, err = Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &BrokenLink{Sequence: 3, EntryID: "unchained", Reason: "entry is not chained"}, report.Broken)
+}
+
+func TestVerifyReportsUnsequencedEntries(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	// entries recorded on mongo before chaining have no sequence once chained entries took the leading sequences
+	repo.entries = append(repo.entries,
+		Entry{ID: "legacy-1", Action: ActionUserCreated},
+		Entry{ID: "legacy-2", Action: ActionUserUpdated},
+	)
+
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.Record(ctx, &Entry{ActorID: "admin", Action: ActionUserUpdated}))
+
+	report, err := Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, 1, report.Verified)
+	assert.Equal(t, 0, report.Unchained)
+	assert.Equal(t, 2, report.Unsequenced, "entries without a sequence are reported as not verified")
+}
+
+// TestMongoBackfillSequences runs against the replica set at MONGO_TEST_HOST with a throwaway database
+func TestMongoBackfillSequences(t *testing.T) {
+	host := os.Getenv("MONGO_TEST_HOST")
+	if host == "" {
+		t.Skip("MONGO_TEST_HOST is not set")
+	}
+
+	ctx := context.Background()
+	params := &MongoRepoParams{Host: host, Database: "audit_test_" + uuid.NewString()[:8], CollectionName: "audit"}
+	repo, err := NewMongoRepo(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		repo.Collection.Database().Drop(context.Background())
+	})
+
+	// entries recorded before chaining have no sequence field
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for i, id := range []string{"legacy-2", "legacy-1"} {
+		_, err = repo.Collection.InsertOne(ctx, bson.M{"_id": id, "time": start.Add(-time.Duration(i) * time.Minute), "action": ActionUserCreated})
+		assert.NoError(t, err)
+	}
+
+	repo, err = NewMongoRepo(ctx, params)
+	assert.NoError(t, err)
+
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.Record(ctx, &Entry{ActorID: "admin", Action: ActionUserUpdated}))
+
+	report, err := Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, 2, report.Unchained, "legacy entries are numbered by time")
+	assert.Equal(t, 0, report.Unsequenced)
+	assert.Equal(t, 1, report.Verified)
+
+	entries, err := repo.GetEntriesAfter(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy-1", entries[0].ID)
+	assert.Equal(t, "legacy-2", entries[1].ID)
+
+	// once the chain started later legacy entries can't be numbered and are reported
+	_, err = repo.Collection.InsertOne(ctx, bson.M{"_id": "legacy-3", "time": start.Add(-time.Hour), "action": ActionUserCreated})
+	assert.NoError(t, err)
+	repo, err = NewMongoRepo(ctx, params)
+	assert.NoError(t, err)
+
+	report, err = Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, 1, report.Unsequenced)
+}
+
+func TestVerifySQLiteChain(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "audit.db")+"?_time_format=sqlite&_pragma=busy_timeout(5000)")
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	runner, err := migrations.NewRunner(db, migrations.SQLITE, nil)
+	assert.NoError(t, err)
+	_, err = runner.Up(context.Background())
+	assert.NoError(t, err)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	repo := NewSQLRepo(db, sqlerr.IsSQLiteUniqueViolation)
+	newChainedService(t, repo, private)
+
+	// replicas race for the next sequence
+	replicas := []*service{}
+	for i := 0; i < 2; i++ {
+		svc, err := NewService(&Resources{Repo: repo, SigningKey: private, CheckpointInterval: 2})
+		assert.NoError(t, err)
+		replicas = append(replicas, svc)
+	}
+
+	var wg sync.WaitGroup
+	for _, svc := range replicas {
+		wg.Add(1)
+		go func(svc *service) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				assert.NoError(t, svc.Record(context.Background(), &Entry{ActorID: "admin", Action: ActionUsersSearched}))
+			}
+		}(svc)
+	}
+	wg.Wait()
+
+	report, err := Verify(context.Background(), repo, VerifyOptions{PublicKey: public, CheckpointInterval: 2})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken, "times and hashes survive the round trip")
+	assert.Equal(t, 15, report.Verified)
+	assert.Equal(t, 7, report.Checkpoints)
+
+	scrubbed, err := replicas[0].Scrub(context.Background(), "admin", "erased-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 15, scrubbed)
+
+	report, err = Verify(context.Background(), repo, VerifyOptions{PublicKey: public, CheckpointInterval: 2})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken, "scrubbing keeps the chain intact")
+	assert.Equal(t, 16, report.Verified, "the erasure is chained")
+	assert.Equal(t, 15, report.Scrubbed)
+
+	_, err = db.Exec(`UPDATE audit_log SET action = 'tampered' WHERE seq = 12`)
+	assert.NoError(t, err)
+
+	report, err = Verify(context.Background(), repo, VerifyOptions{PublicKey: public, CheckpointInterval: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), report.Broken.Sequence)
+}
+
+func TestVerifyScrubbedEntries(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		tamper func(repo *MemoryRepository, original []Entry)
+		broken int64
+		reason string
+	}{
+		{name: "intact", tamper: func(*MemoryRepository, []Entry) {}},
+		{
+			name:   "modified after scrubbing",
+			tamper: func(repo *MemoryRepository, _ []Entry) { repo.entries[2].ActorID = "erased-1" },
+			broken: 3,
+			reason: "scrubbed entry was modified after it was scrubbed",
+		},
+		{
+			name:   "scrubbing interrupted",
+			tamper: func(repo *MemoryRepository, original []Entry) { repo.entries[2] = original[2] },
+			broken: 3,
+			reason: "entry listed by an erasure is not scrubbed",
+		},
+		{
+			name: "erasure modified",
+			tamper: func(repo *MemoryRepository, _ []Entry) {
+				repo.entries[5].Scrubs[0].Hash = repo.entries[4].ScrubbedHash()
+			},
+			broken: 6,
+			reason: "entry was modified after it was recorded",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewMemoryRepo()
+			svc := newChainedService(t, repo, nil)
+			original := append([]Entry{}, repo.entries...)
+
+			scrubbed, err := svc.Scrub(ctx, "3", "erased-1")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, scrubbed)
+			assert.Equal(t, ActionUserErased, repo.entries[5].Action)
+			assert.Equal(t, []*ScrubbedEntry{{Sequence: 3, Hash: repo.entries[2].ScrubbedHash()}}, repo.entries[5].Scrubs)
+
+			tc.tamper(repo, original)
+
+			report, err := Verify(ctx, repo, VerifyOptions{})
+			assert.NoError(t, err)
+			if tc.reason == "" {
+				assert.Nil(t, report.Broken)
+				assert.Equal(t, 6, report.Verified)
+				assert.Equal(t, 1, report.Scrubbed)
+				return
+			}
+			assert.NotNil(t, report.Broken)
+			assert.Equal(t, tc.broken, report.Broken.Sequence)
+			assert.Equal(t, tc.reason, report.Broken.Reason)
+		})
+	}
+}
+
+func TestScrubTwice(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	svc := newChainedService(t, repo, nil)
+
+	scrubbed, err := svc.Scrub(ctx, "3", "erased-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, scrubbed)
+
+	// the admin is the actor of every entry but the first erasure
+	scrubbed, err = svc.Scrub(ctx, "admin", "erased-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, scrubbed)
+	assert.Equal(t, "erased-2", repo.entries[2].ActorID)
+	assert.Equal(t, "erased-1", repo.entries[2].TargetUserID)
+
+	report, err := Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, 7, report.Verified)
+	assert.Equal(t, 5, report.Scrubbed)
+}
+
+func TestScrubRefusesModifiedEntries(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	svc := newChainedService(t, repo, nil)
+
+	repo.entries[2].Details = "tampered"
+
+	_, err := svc.Scrub(ctx, "3", "erased-1")
+	assert.ErrorContains(t, err, "audit entry 3 was modified")
+	assert.Len(t, repo.entries, 5, "no erasure is recorded")
+	assert.Equal(t, "3", repo.entries[2].TargetUserID)
+}
+
+func TestVerifyScrubbedEntriesRecordedBeforeDigests(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+
+	entry := &Entry{ID: "1", Sequence: 1, ActorID: "1234", Action: ActionUserUpdated, TargetUserID: "1234", ClientIP: "192.0.2.1"}
+	entry.Hash = entry.ComputeHash()
+	assert.NoError(t, repo.PutEntry(ctx, entry))
+
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+	scrubbed, err := svc.Scrub(ctx, "1234", "erased-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, scrubbed)
+
+	report, err := Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, 2, report.Verified)
+	assert.Equal(t, 1, report.Scrubbed)
+
+	// the hash of the entry no longer matches its scrubbed fields, only the erasure vouches for them
+	repo.entries[0].Action = ActionUserDeleted
+	report, err = Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &BrokenLink{Sequence: 1, EntryID: "1", Reason: "scrubbed entry was modified after it was scrubbed"}, report.Broken)
+}
+
+func TestVerifyUnlistedScrubbedEntryRecordedBeforeDigests(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+
+	entry := &Entry{ID: "1", Sequence: 1, ActorID: "1234", Action: ActionUserUpdated, TargetUserID: "1234"}
+	entry.Hash = entry.ComputeHash()
+	assert.NoError(t, repo.PutEntry(ctx, entry))
+
+	repo.entries[0].TargetUserID = "5678"
+	repo.entries[0].Scrubbed = true
+
+	report, err := Verify(ctx, repo, VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &BrokenLink{Sequence: 1, EntryID: "1", Reason: "scrubbed entry is not listed by an erasure"}, report.Broken)
+}
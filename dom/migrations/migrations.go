@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackmcguire1/UserService/pkg/migrate"
+)
+
+const (
+	POSTGRES = "postgres"
+	SQLITE   = "sqlite"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// NewRunner returns the migration runner with the embedded schema migrations of the dialect
+func NewRunner(db *sql.DB, dialect string, logger *slog.Logger) (*migrate.Runner, error) {
+	runner := &migrate.Runner{DB: db, Logger: logger}
+
+	switch dialect {
+	case POSTGRES:
+		// serialises replicas migrating the same database on startup
+		runner.Lock = `SELECT pg_advisory_xact_lock(7755)`
+	case SQLITE:
+		// sqlite allows a single writer, the repository opens every transaction with BEGIN IMMEDIATE
+	default:
+		return nil, fmt.Errorf("unknown migration dialect %q", dialect)
+	}
+
+	migrations, err := migrate.Load(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+	runner.Migrations = migrations
+
+	return runner, nil
+}
@@ -0,0 +1,4 @@
+-- populated on write and by the backfill-emails command, NULL until a legacy user is backfilled
+ALTER TABLE users ADD COLUMN normalized_email TEXT;
+
+CREATE UNIQUE INDEX users_normalized_email_idx ON users (normalized_email);
@@ -0,0 +1,18 @@
+-- append-only log of user mutations and administrative actions
+CREATE TABLE audit_log (
+    id TEXT PRIMARY KEY,
+    time TIMESTAMPTZ NOT NULL,
+    actor_id TEXT NOT NULL,
+    actor_is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    action TEXT NOT NULL,
+    target_user_id TEXT NOT NULL DEFAULT '',
+    before_hash TEXT NOT NULL DEFAULT '',
+    after_hash TEXT NOT NULL DEFAULT '',
+    client_ip TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_time_idx ON audit_log (time, id);
+CREATE INDEX audit_log_actor_idx ON audit_log (actor_id, time);
+CREATE INDEX audit_log_target_idx ON audit_log (target_user_id, time);
@@ -0,0 +1,14 @@
+-- audit entries are chained in seq order, entries recorded before chaining are numbered by time
+-- and keep empty hashes
+ALTER TABLE audit_log ADD COLUMN seq BIGINT;
+ALTER TABLE audit_log ADD COLUMN prev_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN signature TEXT NOT NULL DEFAULT '';
+
+UPDATE audit_log SET seq = (
+    SELECT COUNT(*) FROM audit_log AS earlier
+    WHERE earlier.time < audit_log.time OR (earlier.time = audit_log.time AND earlier.id <= audit_log.id)
+);
+
+ALTER TABLE audit_log ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX audit_log_seq_idx ON audit_log (seq);
@@ -0,0 +1,2 @@
+-- the wrapped data key the personal fields of the user are encrypted with, empty while they are stored in plaintext
+ALTER TABLE users ADD COLUMN data_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- the user an event is about, so the events of an erased user can be removed
+ALTER TABLE user_events ADD COLUMN subject TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_events_dead_letters ADD COLUMN subject TEXT NOT NULL DEFAULT '';
+
+UPDATE user_events SET subject = COALESCE(payload::jsonb->>'UserID', '');
+UPDATE user_events_dead_letters SET subject = COALESCE(payload::jsonb->>'UserID', '');
+
+CREATE INDEX user_events_subject_idx ON user_events (subject);
+CREATE INDEX user_events_dead_letters_subject_idx ON user_events_dead_letters (subject);
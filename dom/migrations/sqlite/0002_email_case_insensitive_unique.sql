@@ -0,0 +1,3 @@
+DROP INDEX users_email_idx;
+
+CREATE UNIQUE INDEX users_email_lower_idx ON users (lower(email));
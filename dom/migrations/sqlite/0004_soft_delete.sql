@@ -0,0 +1,5 @@
+-- soft deleted users keep their row until the retention purger removes them
+ALTER TABLE users ADD COLUMN deleted_at TEXT;
+ALTER TABLE users ADD COLUMN deleted_by TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at);
@@ -0,0 +1,5 @@
+-- personal fields are chained through a salted digest so erased users can be scrubbed from the log,
+-- entries recorded before keep an empty digest
+ALTER TABLE audit_log ADD COLUMN personal_digest TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN personal_salt TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN scrubbed BOOLEAN NOT NULL DEFAULT FALSE;
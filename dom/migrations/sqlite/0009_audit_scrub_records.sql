@@ -0,0 +1,2 @@
+-- erasures list the entries they scrubbed together with the hash each entry had right after scrubbing
+ALTER TABLE audit_log ADD COLUMN scrubs TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,171 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Publisher delivers a message to its consumers,
+// returning an error schedules a retry
+type Publisher interface {
+	Publish(*Message) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(*Message) error
+
+func (f PublisherFunc) Publish(msg *Message) error {
+	return f(msg)
+}
+
+// MultiPublisher publishes to every publisher, failing if any of them fail
+type MultiPublisher []Publisher
+
+func (publishers MultiPublisher) Publish(msg *Message) error {
+	errs := []error{}
+	for _, p := range publishers {
+		if err := p.Publish(msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Dispatcher polls the outbox and publishes due messages,
+// failed deliveries are retried with exponential backoff until MaxAttempts is reached
+type Dispatcher struct {
+	Repo      Repository
+	Publisher Publisher
+	Logger    *slog.Logger
+
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease is how long a claimed message is hidden from other dispatchers
+	Lease       time.Duration
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func NewDispatcher(repo Repository, publisher Publisher, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		Repo:         repo,
+		Publisher:    publisher,
+		Logger:       logger,
+		PollInterval: time.Second,
+		BatchSize:    50,
+		Lease:        time.Minute,
+		MaxAttempts:  10,
+		BaseBackoff:  time.Second,
+		MaxBackoff:   time.Hour,
+	}
+}
+
+// Run dispatches messages until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// keep draining while full batches are being claimed
+		for {
+			n, err := d.DispatchOnce()
+			if err != nil {
+				d.Logger.
+					With("error", err).
+					Error("failed to dispatch outbox messages")
+			}
+			if err != nil || n < d.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchOnce claims and publishes a single batch, returning the number of claimed messages
+func (d *Dispatcher) DispatchOnce() (int, error) {
+	msgs, err := d.Repo.ClaimMessages(d.BatchSize, d.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range msgs {
+		d.dispatch(msg)
+	}
+
+	return len(msgs), nil
+}
+
+func (d *Dispatcher) dispatch(msg *Message) {
+	logEntry := d.Logger.
+		With("event-id", msg.ID).
+		With("event-type", msg.Type)
+
+	err := d.Publisher.Publish(msg)
+	if err == nil {
+		logEntry.Debug("published outbox message")
+
+		err = d.Repo.AckMessage(msg.ID)
+		if err != nil {
+			logEntry.
+				With("error", err).
+				Error("failed to ack outbox message")
+		}
+		return
+	}
+
+	msg.Attempts++
+	msg.LastError = err.Error()
+
+	if msg.Attempts >= d.MaxAttempts {
+		logEntry.
+			With("error", err).
+			With("attempts", msg.Attempts).
+			Error("outbox message exhausted its attempts, moving to dead letters")
+
+		err = d.Repo.DeadLetterMessage(msg)
+		if err != nil {
+			logEntry.
+				With("error", err).
+				Error("failed to dead letter outbox message")
+		}
+		return
+	}
+
+	msg.NextAttemptAt = time.Now().UTC().Add(d.Backoff(msg.Attempts))
+
+	logEntry.
+		With("error", err).
+		With("attempts", msg.Attempts).
+		With("next-attempt-at", msg.NextAttemptAt).
+		Warn("failed to publish outbox message, retrying")
+
+	err = d.Repo.RetryMessage(msg)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to schedule outbox message retry")
+	}
+}
+
+// Backoff returns the delay before the next attempt, doubling from BaseBackoff up to MaxBackoff
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	backoff := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+
+	return backoff
+}
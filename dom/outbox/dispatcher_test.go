@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+func newTestMessage(id string) *Message {
+	now := time.Now().UTC()
+	return &Message{ID: id, Type: "UPDATE", Payload: `{"ID":"` + id + `"}`, CreatedAt: now, NextAttemptAt: now}
+}
+
+func TestDispatchAcksDeliveredMessages(t *testing.T) {
+	repo := NewMemoryRepo()
+	assert.NoError(t, repo.PutMessage(newTestMessage("1")))
+	assert.NoError(t, repo.PutMessage(newTestMessage("2")))
+
+	published := []string{}
+	d := NewDispatcher(repo, PublisherFunc(func(msg *Message) error {
+		published = append(published, msg.ID)
+		return nil
+	}), testLogger)
+
+	n, err := d.DispatchOnce()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.ElementsMatch(t, []string{"1", "2"}, published)
+	assert.Empty(t, repo.Messages())
+}
+
+func TestDispatchRetriesWithBackoff(t *testing.T) {
+	repo := NewMemoryRepo()
+	assert.NoError(t, repo.PutMessage(newTestMessage("1")))
+
+	d := NewDispatcher(repo, PublisherFunc(func(msg *Message) error {
+		return fmt.Errorf("endpoint unavailable")
+	}), testLogger)
+
+	_, err := d.DispatchOnce()
+	assert.NoError(t, err)
+
+	msgs := repo.Messages()
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, 1, msgs[0].Attempts)
+	assert.Equal(t, "endpoint unavailable", msgs[0].LastError)
+	assert.True(t, msgs[0].NextAttemptAt.After(time.Now().UTC()))
+
+	// the message is not due again until its backoff has elapsed
+	n, err := d.DispatchOnce()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDispatchDeadLettersExhaustedMessages(t *testing.T) {
+	repo := NewMemoryRepo()
+	assert.NoError(t, repo.PutMessage(newTestMessage("1")))
+
+	d := NewDispatcher(repo, PublisherFunc(func(msg *Message) error {
+		return fmt.Errorf("endpoint unavailable")
+	}), testLogger)
+	d.MaxAttempts = 3
+	d.BaseBackoff = 0
+
+	for i := 0; i < 3; i++ {
+		_, err := d.DispatchOnce()
+		assert.NoError(t, err)
+	}
+
+	assert.Empty(t, repo.Messages())
+
+	deadLetters, err := repo.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 1)
+	assert.Equal(t, 3, deadLetters[0].Attempts)
+}
+
+func TestClaimMessagesLeasesMessages(t *testing.T) {
+	repo := NewMemoryRepo()
+	assert.NoError(t, repo.PutMessage(newTestMessage("1")))
+
+	msgs, err := repo.ClaimMessages(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	msgs, err = repo.ClaimMessages(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs, "leased messages are hidden from other dispatchers")
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, d.Backoff(1))
+	assert.Equal(t, 2*time.Second, d.Backoff(2))
+	assert.Equal(t, 8*time.Second, d.Backoff(4))
+	assert.Equal(t, 10*time.Second, d.Backoff(5))
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	var (
+		gotID   string
+		gotBody string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(EVENT_ID_HEADER)
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	msg := newTestMessage("1")
+	assert.NoError(t, NewHTTPPublisher(srv.URL).Publish(msg))
+	assert.Equal(t, "1", gotID)
+	assert.Equal(t, msg.Payload, gotBody)
+
+	assert.Error(t, NewHTTPPublisher(srv.URL+"/fail").Publish(msg))
+}
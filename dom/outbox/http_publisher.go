@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	EVENT_ID_HEADER   = "X-Event-ID"
+	EVENT_TYPE_HEADER = "X-Event-Type"
+)
+
+// HTTPPublisher POSTs the message payload to a single URL,
+// any non 2xx response is treated as a failed delivery
+type HTTPPublisher struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPPublisher(url string) *HTTPPublisher {
+	return &HTTPPublisher{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *HTTPPublisher) Publish(msg *Message) error {
+	req, err := http.NewRequest(http.MethodPost, p.URL, strings.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_ID_HEADER, msg.ID)
+	req.Header.Set(EVENT_TYPE_HEADER, msg.Type)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, p.URL)
+	}
+
+	return nil
+}
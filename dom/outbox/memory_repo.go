@@ -0,0 +1,176 @@
+package outbox
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// MemoryRepository is a thread-safe in-memory outbox, intended for tests and local development
+type MemoryRepository struct {
+	BaseRepository
+
+	mu          sync.Mutex
+	messages    map[string]Message
+	deadLetters map[string]Message
+}
+
+func NewMemoryRepo() *MemoryRepository {
+	return &MemoryRepository{
+		messages:    map[string]Message{},
+		deadLetters: map[string]Message{},
+	}
+}
+
+func (repo *MemoryRepository) PutMessage(msg *Message) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.messages[msg.ID]; ok {
+		return fmt.Errorf("outbox message %s %w", msg.ID, utils.AlreadyExists)
+	}
+	repo.messages[msg.ID] = *msg
+
+	return nil
+}
+
+func (repo *MemoryRepository) ClaimMessages(limit int, lease time.Duration) ([]*Message, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	due := []Message{}
+	for _, msg := range repo.messages {
+		if !msg.NextAttemptAt.After(now) && !msg.LockedUntil.After(now) {
+			due = append(due, msg)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+
+	msgs := []*Message{}
+	for _, msg := range due {
+		if len(msgs) == limit {
+			break
+		}
+
+		msg.LockedUntil = now.Add(lease)
+		repo.messages[msg.ID] = msg
+
+		claimed := msg
+		msgs = append(msgs, &claimed)
+	}
+
+	return msgs, nil
+}
+
+func (repo *MemoryRepository) AckMessage(id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.messages[id]; !ok {
+		return fmt.Errorf("failed to ack outbox message %w", utils.ErrNotFound)
+	}
+	delete(repo.messages, id)
+
+	return nil
+}
+
+func (repo *MemoryRepository) RetryMessage(msg *Message) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, ok := repo.messages[msg.ID]
+	if !ok {
+		return fmt.Errorf("failed to retry outbox message %w", utils.ErrNotFound)
+	}
+
+	stored.Attempts = msg.Attempts
+	stored.NextAttemptAt = msg.NextAttemptAt
+	stored.LockedUntil = time.Time{}
+	stored.LastError = msg.LastError
+	repo.messages[msg.ID] = stored
+
+	return nil
+}
+
+func (repo *MemoryRepository) DeadLetterMessage(msg *Message) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.messages[msg.ID]; !ok {
+		return fmt.Errorf("failed to dead letter outbox message %w", utils.ErrNotFound)
+	}
+	delete(repo.messages, msg.ID)
+	repo.deadLetters[msg.ID] = *msg
+
+	return nil
+}
+
+func (repo *MemoryRepository) GetDeadLetters() ([]*Message, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	msgs := []*Message{}
+	for _, msg := range repo.deadLetters {
+		msg := msg
+		msgs = append(msgs, &msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].CreatedAt.Before(msgs[j].CreatedAt) })
+
+	return msgs, nil
+}
+
+func (repo *MemoryRepository) GetSubjectMessages(subject string) ([]*Message, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	msgs := []*Message{}
+	for _, store := range []map[string]Message{repo.messages, repo.deadLetters} {
+		for _, msg := range store {
+			if msg.Subject == subject {
+				msg := msg
+				msgs = append(msgs, &msg)
+			}
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].CreatedAt.Before(msgs[j].CreatedAt) })
+
+	return msgs, nil
+}
+
+func (repo *MemoryRepository) DeleteSubjectMessages(subject string, types []string) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	deleted := 0
+	for _, store := range []map[string]Message{repo.messages, repo.deadLetters} {
+		for id, msg := range store {
+			if msg.Subject == subject && slices.Contains(types, msg.Type) {
+				delete(store, id)
+				deleted++
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+// Messages returns every pending message, ordered by creation time
+func (repo *MemoryRepository) Messages() []*Message {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	msgs := []*Message{}
+	for _, msg := range repo.messages {
+		msg := msg
+		msgs = append(msgs, &msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].CreatedAt.Before(msgs[j].CreatedAt) })
+
+	return msgs
+}
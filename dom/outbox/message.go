@@ -0,0 +1,21 @@
+package outbox
+
+import (
+	"time"
+)
+
+// Message is an event waiting to be delivered at least once,
+// consumers should use the ID to deduplicate redeliveries
+type Message struct {
+	ID   string `json:"id" bson:"_id"`
+	Type string `json:"type" bson:"type"`
+	// Subject is the ID of what the message is about, e.g. the user of a user event,
+	// so every message about it can be found and erased
+	Subject       string    `json:"subject" bson:"subject"`
+	Payload       string    `json:"payload" bson:"payload"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+	Attempts      int       `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LockedUntil   time.Time `json:"lockedUntil" bson:"lockedUntil"`
+	LastError     string    `json:"lastError,omitempty" bson:"lastError,omitempty"`
+}
@@ -0,0 +1,225 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoRepository struct {
+	BaseRepository
+
+	Collection           *mongo.Collection
+	DeadLetterCollection *mongo.Collection
+}
+
+type MongoRepoParams struct {
+	Host                     string
+	Database                 string
+	CollectionName           string
+	DeadLetterCollectionName string
+}
+
+func NewMongoRepo(ctx context.Context, params *MongoRepoParams) (*MongoRepository, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(params.Host))
+	if err != nil {
+		return nil, err
+	}
+	database := client.Database(params.Database)
+
+	collection := database.Collection(params.CollectionName)
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "nextAttemptAt", Value: 1}, {Key: "lockedUntil", Value: 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox indexes err:%w", err)
+	}
+
+	deadLetters := database.Collection(params.DeadLetterCollectionName)
+	for _, c := range []*mongo.Collection{collection, deadLetters} {
+		_, err = c.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "subject", Value: 1}}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create outbox subject index err:%w", err)
+		}
+	}
+
+	return &MongoRepository{
+		Collection:           collection,
+		DeadLetterCollection: deadLetters,
+	}, nil
+}
+
+func (repo *MongoRepository) PutMessage(msg *Message) error {
+	_, err := repo.Collection.InsertOne(context.Background(), msg)
+	return err
+}
+
+func (repo *MongoRepository) ClaimMessages(limit int, lease time.Duration) ([]*Message, error) {
+	now := time.Now().UTC()
+
+	filter := bson.M{
+		"nextAttemptAt": bson.M{"$lte": now},
+		"lockedUntil":   bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"lockedUntil": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	msgs := []*Message{}
+	for len(msgs) < limit {
+		res := repo.Collection.FindOneAndUpdate(context.Background(), filter, update, opts)
+		if res.Err() != nil {
+			if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+				break
+			}
+			return msgs, res.Err()
+		}
+
+		var msg *Message
+		err := res.Decode(&msg)
+		if err != nil {
+			return msgs, fmt.Errorf("failed to umarshal bson outbox document err:%w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+func (repo *MongoRepository) AckMessage(id string) error {
+	res, err := repo.Collection.DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount != 1 {
+		return fmt.Errorf("failed to ack outbox message count:%d %w", res.DeletedCount, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *MongoRepository) RetryMessage(msg *Message) error {
+	update := bson.M{"$set": bson.M{
+		"attempts":      msg.Attempts,
+		"nextAttemptAt": msg.NextAttemptAt,
+		"lockedUntil":   time.Time{},
+		"lastError":     msg.LastError,
+	}}
+
+	res, err := repo.Collection.UpdateByID(context.Background(), msg.ID, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return fmt.Errorf("failed to retry outbox message count:%d %w", res.MatchedCount, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+// DeadLetterMessage moves the message in a transaction, so a message removed by DeleteSubjectMessages
+// while it was being delivered is not brought back as a dead letter
+func (repo *MongoRepository) DeadLetterMessage(msg *Message) error {
+	sess, err := repo.Collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.Background())
+
+	_, err = sess.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (any, error) {
+		res, err := repo.Collection.DeleteOne(ctx, bson.M{"_id": msg.ID})
+		if err != nil {
+			return nil, err
+		}
+		if res.DeletedCount != 1 {
+			return nil, fmt.Errorf("failed to dead letter outbox message count:%d %w", res.DeletedCount, utils.ErrNotFound)
+		}
+
+		opts := options.Replace().SetUpsert(true)
+		_, err = repo.DeadLetterCollection.ReplaceOne(ctx, bson.M{"_id": msg.ID}, msg, opts)
+
+		return nil, err
+	})
+
+	return err
+}
+
+func (repo *MongoRepository) GetDeadLetters() ([]*Message, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := repo.DeadLetterCollection.Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	msgs := []*Message{}
+	err = cursor.All(context.Background(), &msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+func (repo *MongoRepository) GetSubjectMessages(subject string) ([]*Message, error) {
+	ctx := context.Background()
+
+	msgs := []*Message{}
+	for _, c := range []*mongo.Collection{repo.Collection, repo.DeadLetterCollection} {
+		cursor, err := c.Find(ctx, subjectFilter(subject))
+		if err != nil {
+			return nil, err
+		}
+
+		found := []*Message{}
+		err = cursor.All(ctx, &found)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, found...)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].CreatedAt.Before(msgs[j].CreatedAt) })
+
+	return msgs, nil
+}
+
+func (repo *MongoRepository) DeleteSubjectMessages(subject string, types []string) (int, error) {
+	filter := subjectFilter(subject)
+	filter["type"] = bson.M{"$in": types}
+
+	deleted := 0
+	for _, c := range []*mongo.Collection{repo.Collection, repo.DeadLetterCollection} {
+		res, err := c.DeleteMany(context.Background(), filter)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(res.DeletedCount)
+	}
+
+	return deleted, nil
+}
+
+// subjectFilter matches the messages about the subject, messages stored before they had a subject
+// are matched by the user ID in their payload
+func subjectFilter(subject string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"subject": subject},
+		bson.M{
+			"subject": bson.M{"$exists": false},
+			"payload": bson.M{"$regex": `"UserID":"` + regexp.QuoteMeta(subject) + `"`},
+		},
+	}}
+}
@@ -0,0 +1,61 @@
+package outbox
+
+import (
+	"fmt"
+	"time"
+)
+
+var NotImplementedErr = fmt.Errorf("this method is not implemented")
+
+type Repository interface {
+	// PutMessage enqueues a message outside of a user write, e.g. for replays
+	PutMessage(*Message) error
+	// ClaimMessages leases up to limit due messages so no other dispatcher delivers them concurrently
+	ClaimMessages(limit int, lease time.Duration) ([]*Message, error)
+	// AckMessage removes a delivered message
+	AckMessage(string) error
+	// RetryMessage stores the attempt count, next attempt time and error of a failed delivery
+	RetryMessage(*Message) error
+	// DeadLetterMessage moves a message that exhausted its attempts into the dead letter store
+	DeadLetterMessage(*Message) error
+	GetDeadLetters() ([]*Message, error)
+	// GetSubjectMessages returns the pending messages and dead letters about the subject, oldest first
+	GetSubjectMessages(subject string) ([]*Message, error)
+	// DeleteSubjectMessages removes the pending messages and dead letters about the subject
+	// of any of the types and returns how many were removed
+	DeleteSubjectMessages(subject string, types []string) (int, error)
+}
+
+type BaseRepository struct{}
+
+func (repo *BaseRepository) PutMessage(*Message) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) ClaimMessages(int, time.Duration) ([]*Message, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) AckMessage(string) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) RetryMessage(*Message) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) DeadLetterMessage(*Message) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) GetDeadLetters() ([]*Message, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) GetSubjectMessages(string) ([]*Message, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) DeleteSubjectMessages(string, []string) (int, error) {
+	return 0, NotImplementedErr
+}
@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSubjectMessages(t *testing.T, repo Repository) {
+	now := time.Now().UTC()
+	for i, msg := range []*Message{
+		{ID: "1", Type: "CREATED", Subject: "user-1"},
+		{ID: "2", Type: "UPDATED", Subject: "user-1"},
+		{ID: "3", Type: "CREATED", Subject: "user-2"},
+		{ID: "4", Type: "ERASED", Subject: "user-1"},
+	} {
+		msg.Payload = `{"UserID":"` + msg.Subject + `"}`
+		msg.CreatedAt = now.Add(time.Duration(i) * time.Second)
+		msg.NextAttemptAt = msg.CreatedAt
+		assert.NoError(t, repo.PutMessage(msg))
+	}
+
+	claimed, err := repo.ClaimMessages(1, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", claimed[0].ID)
+	assert.NoError(t, repo.DeadLetterMessage(claimed[0]))
+
+	msgs, err := repo.GetSubjectMessages("user-1")
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	assert.Equal(t, []string{"1", "2", "4"}, ids, "dead letters are included")
+
+	deleted, err := repo.DeleteSubjectMessages("user-1", []string{"CREATED", "UPDATED"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	msgs, err = repo.GetSubjectMessages("user-1")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "ERASED", msgs[0].Type)
+
+	deadLetters, err := repo.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+
+	msgs, err = repo.GetSubjectMessages("user-2")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1, "messages about other subjects are kept")
+}
+
+func TestMemorySubjectMessages(t *testing.T) {
+	testSubjectMessages(t, NewMemoryRepo())
+}
+
+func TestSQLiteSubjectMessages(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "outbox.db")+"?_time_format=sqlite")
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	runner, err := migrations.NewRunner(db, migrations.SQLITE, nil)
+	assert.NoError(t, err)
+	_, err = runner.Up(context.Background())
+	assert.NoError(t, err)
+
+	testSubjectMessages(t, NewSQLRepo(db, ""))
+}
@@ -0,0 +1,221 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// POSTGRES_CLAIM_LOCK stops concurrent dispatchers from claiming the same rows
+const POSTGRES_CLAIM_LOCK = "FOR UPDATE SKIP LOCKED"
+
+const messageColumns = `id, type, subject, payload, created_at, attempts, next_attempt_at, locked_until, last_error`
+
+// SQLRepository stores messages in the 'user_events' and 'user_events_dead_letters' tables,
+// the same tables the SQL user repositories enqueue into
+type SQLRepository struct {
+	BaseRepository
+
+	DB *sql.DB
+	// ClaimLock is appended to the claim sub-select, e.g. POSTGRES_CLAIM_LOCK
+	ClaimLock string
+}
+
+func NewSQLRepo(db *sql.DB, claimLock string) *SQLRepository {
+	return &SQLRepository{DB: db, ClaimLock: claimLock}
+}
+
+func (repo *SQLRepository) PutMessage(msg *Message) error {
+	return repo.insert(context.Background(), repo.DB, "user_events", msg)
+}
+
+// execer is either the connection pool or a transaction
+type execer interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}
+
+func (repo *SQLRepository) insert(ctx context.Context, db execer, table string, msg *Message) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO `+table+` (`+messageColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		msg.ID,
+		msg.Type,
+		msg.Subject,
+		msg.Payload,
+		msg.CreatedAt,
+		msg.Attempts,
+		msg.NextAttemptAt,
+		msg.LockedUntil,
+		msg.LastError,
+	)
+
+	return err
+}
+
+func (repo *SQLRepository) ClaimMessages(limit int, lease time.Duration) ([]*Message, error) {
+	now := time.Now().UTC()
+
+	return repo.queryMessages(context.Background(), `UPDATE user_events SET locked_until = $1
+		WHERE id IN (
+			SELECT id FROM user_events
+			WHERE next_attempt_at <= $2 AND locked_until <= $2
+			ORDER BY next_attempt_at
+			LIMIT $3 `+repo.ClaimLock+`
+		)
+		RETURNING `+messageColumns,
+		now.Add(lease), now, limit,
+	)
+}
+
+func (repo *SQLRepository) AckMessage(id string) error {
+	res, err := repo.DB.ExecContext(context.Background(), `DELETE FROM user_events WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("failed to ack outbox message count:%d %w", count, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *SQLRepository) RetryMessage(msg *Message) error {
+	res, err := repo.DB.ExecContext(context.Background(),
+		`UPDATE user_events SET attempts = $1, next_attempt_at = $2, locked_until = $3, last_error = $4 WHERE id = $5`,
+		msg.Attempts, msg.NextAttemptAt, time.Time{}, msg.LastError, msg.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("failed to retry outbox message count:%d %w", count, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *SQLRepository) DeadLetterMessage(msg *Message) error {
+	ctx := context.Background()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM user_events WHERE id = $1`, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("failed to dead letter outbox message count:%d %w", count, utils.ErrNotFound)
+	}
+
+	err = repo.insert(ctx, tx, "user_events_dead_letters", msg)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo *SQLRepository) GetDeadLetters() ([]*Message, error) {
+	return repo.queryMessages(context.Background(),
+		`SELECT `+messageColumns+` FROM user_events_dead_letters ORDER BY created_at`,
+	)
+}
+
+func (repo *SQLRepository) GetSubjectMessages(subject string) ([]*Message, error) {
+	return repo.queryMessages(context.Background(), `SELECT `+messageColumns+` FROM user_events WHERE subject = $1
+		UNION ALL
+		SELECT `+messageColumns+` FROM user_events_dead_letters WHERE subject = $1
+		ORDER BY created_at`,
+		subject,
+	)
+}
+
+func (repo *SQLRepository) DeleteSubjectMessages(subject string, types []string) (int, error) {
+	if len(types) == 0 {
+		return 0, nil
+	}
+
+	ctx := context.Background()
+
+	args := []any{subject}
+	placeholders := make([]string, len(types))
+	for i, t := range types {
+		args = append(args, t)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+	filter := `WHERE subject = $1 AND type IN (` + strings.Join(placeholders, ", ") + `)`
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	deleted := 0
+	for _, table := range []string{"user_events", "user_events_dead_letters"} {
+		res, err := tx.ExecContext(ctx, `DELETE FROM `+table+` `+filter, args...)
+		if err != nil {
+			return 0, err
+		}
+
+		count, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += int(count)
+	}
+
+	return deleted, tx.Commit()
+}
+
+func (repo *SQLRepository) queryMessages(ctx context.Context, statement string, args ...any) ([]*Message, error) {
+	rows, err := repo.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []*Message{}
+	for rows.Next() {
+		msg := &Message{}
+		err := rows.Scan(
+			&msg.ID,
+			&msg.Type,
+			&msg.Subject,
+			&msg.Payload,
+			&msg.CreatedAt,
+			&msg.Attempts,
+			&msg.NextAttemptAt,
+			&msg.LockedUntil,
+			&msg.LastError,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message row err:%w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, rows.Err()
+}
@@ -0,0 +1,230 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/dom/session"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// PseudonymPrefix starts the pseudonym replacing an erased user in the audit log
+const PseudonymPrefix = "erased-"
+
+// Export is the portable copy of everything stored about a user
+type Export struct {
+	User *user.User `json:"user"`
+	// AuditEntries are the entries the user is the actor or target of, newest first
+	AuditEntries []*audit.Entry     `json:"auditEntries"`
+	Sessions     []*session.Session `json:"sessions"`
+	// Events are the events about the user which have not been delivered yet, dead letters included
+	Events     []*outbox.Message `json:"events"`
+	ExportedAt time.Time         `json:"exportedAt"`
+}
+
+// ErasureReport describes what was removed, Pseudonym replaces the user ID in the audit log
+type ErasureReport struct {
+	UserID    string `json:"userId"`
+	Pseudonym string `json:"pseudonym"`
+	// UserDeleted is false when only sessions or audit entries of an already removed user were left
+	UserDeleted          bool `json:"userDeleted"`
+	SessionsDeleted      int  `json:"sessionsDeleted"`
+	EventsDeleted        int  `json:"eventsDeleted"`
+	AuditEntriesScrubbed int  `json:"auditEntriesScrubbed"`
+}
+
+func (svc *service) Export(ctx context.Context, userID string) (*Export, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("user-id", userID)
+	logEntry.Info("call Export")
+
+	u, err := svc.Users.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := svc.Sessions.GetUserSessions(ctx, userID)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to get user sessions from repository")
+
+		return nil, err
+	}
+
+	entries, err := svc.auditEntries(ctx, userID)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to get audit entries of user")
+
+		return nil, err
+	}
+
+	events, err := svc.events(userID)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to get undelivered events of user")
+
+		return nil, err
+	}
+
+	if svc.Audit != nil {
+		// failing to record the export is logged by the AuditService
+		_ = svc.Audit.Record(ctx, &audit.Entry{Action: audit.ActionUserExported, TargetUserID: userID})
+	}
+
+	return &Export{
+		User:         u,
+		AuditEntries: entries,
+		Sessions:     sessions,
+		Events:       events,
+		ExportedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// events returns the undelivered events about the user, decrypted when they are stored encrypted
+func (svc *service) events(userID string) ([]*outbox.Message, error) {
+	if svc.Events == nil {
+		return []*outbox.Message{}, nil
+	}
+
+	msgs, err := svc.Events.GetSubjectMessages(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if svc.Decrypter != nil {
+		for i, msg := range msgs {
+			msgs[i], err = svc.Decrypter.Decrypt(msg)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return msgs, nil
+}
+
+// auditEntries pages through every audit entry concerning the user
+func (svc *service) auditEntries(ctx context.Context, userID string) ([]*audit.Entry, error) {
+	entries := []*audit.Entry{}
+	if svc.Audit == nil {
+		return entries, nil
+	}
+
+	query := &audit.Query{Subject: userID, Limit: audit.MaxLimit}
+	for {
+		page, err := svc.Audit.GetEntries(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+
+		if len(page) < query.Limit || page[len(page)-1].Sequence == 0 {
+			return entries, nil
+		}
+		query.BeforeSequence = page[len(page)-1].Sequence
+	}
+}
+
+// Erase removes the user regardless of its version or soft deletion, the ERASED event is enqueued together
+// with the removal and every other undelivered event about the user is deleted, dead letters included. Sessions and audit entries are erased even when the user itself is already gone,
+// e.g. purged or erased by an earlier attempt, so a failed erasure can be retried
+func (svc *service) Erase(ctx context.Context, userID string) (*ErasureReport, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("user-id", userID)
+	logEntry.Info("call Erase")
+
+	// pseudonyms identify erasures in the audit log, which must stay verifiable
+	if strings.HasPrefix(userID, PseudonymPrefix) {
+		return nil, fmt.Errorf("%w - %s is the pseudonym of an erased user", utils.ValidationErr, userID)
+	}
+
+	u, err := svc.Users.GetUser(ctx, userID)
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		return nil, err
+	}
+
+	report := &ErasureReport{UserID: userID, Pseudonym: PseudonymPrefix + uuid.NewString()}
+
+	sessions, err := svc.Sessions.GetUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = svc.Sessions.DeleteUserSessions(ctx, userID)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to delete user sessions from repository")
+
+		return nil, err
+	}
+	report.SessionsDeleted = len(sessions)
+
+	if u != nil {
+		err = svc.Users.DeleteUser(ctx, userID, user.NewUserErasure(u, utils.CallerID(ctx)))
+		if err != nil {
+			logEntry.
+				With("error", err).
+				Error("failed to erase user in repository")
+
+			return nil, err
+		}
+		report.UserDeleted = true
+	}
+
+	// the ERASED event just enqueued is kept, consumers must still learn about the erasure
+	if svc.Events != nil {
+		report.EventsDeleted, err = svc.Events.DeleteSubjectMessages(userID, erasableEvents())
+		if err != nil {
+			logEntry.
+				With("error", err).
+				Error("failed to delete undelivered events of user")
+
+			return nil, err
+		}
+	}
+
+	if svc.Audit != nil {
+		report.AuditEntriesScrubbed, err = svc.Audit.Scrub(ctx, userID, report.Pseudonym)
+		if err != nil {
+			logEntry.
+				With("error", err).
+				Error("failed to scrub user from audit log")
+
+			return nil, err
+		}
+	}
+
+	if !report.UserDeleted && report.SessionsDeleted == 0 && report.EventsDeleted == 0 && report.AuditEntriesScrubbed == 0 {
+		return nil, fmt.Errorf("nothing is stored about user %s err: %w", userID, utils.ErrNotFound)
+	}
+
+	// scrubbing records the erasure together with the entries it scrubbed
+	if svc.Audit != nil && report.AuditEntriesScrubbed == 0 {
+		// failing to record the erasure is logged by the AuditService
+		_ = svc.Audit.Record(ctx, &audit.Entry{Action: audit.ActionUserErased, TargetUserID: report.Pseudonym})
+	}
+
+	return report, nil
+}
+
+// erasableEvents are the types of the events an erasure removes, every type but ERASED
+func erasableEvents() []string {
+	types := []string{}
+	for _, t := range user.EventTypes {
+		if t != user.EventErased {
+			types = append(types, t)
+		}
+	}
+
+	return types
+}
@@ -0,0 +1,167 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/dom/session"
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixture struct {
+	svc      *service
+	events   *outbox.MemoryRepository
+	auditLog *audit.MemoryRepository
+	sessions *session.MemoryRepository
+	users    user.UserService
+	user     *user.User
+}
+
+func newFixture(t *testing.T) *fixture {
+	ctx := utils.WithCallerID(context.Background(), "admin")
+	f := &fixture{
+		events:   outbox.NewMemoryRepo(),
+		auditLog: audit.NewMemoryRepo(),
+		sessions: session.NewMemoryRepo(),
+	}
+
+	auditService, err := audit.NewService(&audit.Resources{Repo: f.auditLog})
+	assert.NoError(t, err)
+
+	userRepo := user.NewMemoryRepo(f.events)
+	f.users, err = user.NewService(&user.Resources{Repo: userRepo, Audit: auditService})
+	assert.NoError(t, err)
+
+	f.user, err = f.users.PutUser(ctx, &user.User{FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.NoError(t, err)
+	_, err = f.users.PutUser(utils.WithCallerID(context.Background(), f.user.ID),
+		&user.User{ID: f.user.ID, FirstName: "Johnny", LastName: "Doe", CountryCode: "GB", Email: "john@example.com", Version: 1},
+	)
+	assert.NoError(t, err)
+	_, err = f.users.PutUser(ctx, &user.User{FirstName: "Jane", LastName: "Doe", CountryCode: "GB", Email: "jane@example.com"})
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	for _, id := range []string{"1", "2"} {
+		assert.NoError(t, f.sessions.PutSession(context.Background(), &session.Session{ID: id, UserID: f.user.ID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	}
+	assert.NoError(t, f.sessions.PutSession(context.Background(), &session.Session{ID: "3", UserID: "someone else", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	f.svc, err = NewService(&Resources{Users: userRepo, Sessions: f.sessions, Audit: auditService, Events: f.events})
+	assert.NoError(t, err)
+
+	return f
+}
+
+func TestExport(t *testing.T) {
+	f := newFixture(t)
+	ctx := utils.WithCallerID(context.Background(), f.user.ID)
+
+	export, err := f.svc.Export(ctx, f.user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Johnny", export.User.FirstName)
+	assert.Len(t, export.Sessions, 2)
+	assert.Len(t, export.AuditEntries, 2, "entries of other users are not exported")
+	assert.Equal(t, audit.ActionUserUpdated, export.AuditEntries[0].Action)
+	assert.NotContains(t, string(utils.ToRAWJSON(export)), "password")
+	assert.Len(t, export.Events, 2, "undelivered events of other users are not exported")
+	assert.Equal(t, user.EventCreated, export.Events[0].Type)
+	assert.Contains(t, export.Events[1].Payload, "Johnny")
+
+	entries, err := f.auditLog.GetEntries(ctx, &audit.Query{Action: audit.ActionUserExported, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, f.user.ID, entries[0].ActorID)
+
+	_, err = f.svc.Export(ctx, "missing")
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+}
+
+func TestErase(t *testing.T) {
+	f := newFixture(t)
+	ctx := utils.WithCallerID(context.Background(), "admin")
+
+	// the creation of the user could not be delivered
+	claimed, err := f.events.ClaimMessages(1, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, f.user.ID, claimed[0].Subject)
+	assert.NoError(t, f.events.DeadLetterMessage(claimed[0]))
+
+	report, err := f.svc.Erase(ctx, f.user.ID)
+	assert.NoError(t, err)
+	assert.True(t, report.UserDeleted)
+	assert.Equal(t, 2, report.SessionsDeleted)
+	assert.Equal(t, 2, report.EventsDeleted)
+	assert.Equal(t, 2, report.AuditEntriesScrubbed)
+	assert.True(t, strings.HasPrefix(report.Pseudonym, PseudonymPrefix))
+
+	_, err = f.users.GetUser(ctx, f.user.ID)
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+	sessions, err := f.sessions.GetUserSessions(context.Background(), f.user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+	_, err = f.sessions.GetSession(context.Background(), "3")
+	assert.NoError(t, err, "sessions of other users are kept")
+
+	entries, err := f.auditLog.GetEntries(ctx, &audit.Query{Limit: 10})
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotEqual(t, f.user.ID, entry.ActorID)
+		assert.NotEqual(t, f.user.ID, entry.TargetUserID)
+	}
+	assert.Equal(t, audit.ActionUserErased, entries[0].Action)
+	assert.Equal(t, report.Pseudonym, entries[0].TargetUserID)
+	assert.Len(t, entries[0].Scrubs, 2, "the erasure lists the scrubbed entries")
+	erasures, err := f.auditLog.GetEntries(ctx, &audit.Query{Action: audit.ActionUserErased, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, erasures, 1)
+
+	verified, err := audit.Verify(ctx, f.auditLog, audit.VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, verified.Broken, "scrubbing keeps the chain intact")
+	assert.Equal(t, 2, verified.Scrubbed)
+
+	deadLetters, err := f.events.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+
+	msgs := f.events.Messages()
+	assert.Len(t, msgs, 2, "only the creation of the other user and the erasure are left")
+	assert.NotEqual(t, f.user.ID, msgs[0].Subject)
+	erased := msgs[len(msgs)-1]
+	assert.Equal(t, user.EventErased, erased.Type)
+
+	var event user.UserUpdate
+	assert.NoError(t, json.Unmarshal([]byte(erased.Payload), &event))
+	assert.Equal(t, f.user.ID, event.UserID)
+	assert.Equal(t, "admin", event.ActorID)
+	assert.Nil(t, event.User)
+	assert.Nil(t, event.Previous, "the event carries no personal data")
+	assert.NotContains(t, erased.Payload, "john@example.com")
+
+	_, err = f.svc.Erase(ctx, f.user.ID)
+	assert.ErrorIs(t, err, utils.ErrNotFound, "nothing is left to erase")
+	_, err = f.svc.Erase(ctx, report.Pseudonym)
+	assert.ErrorIs(t, err, utils.ValidationErr, "erasures can not be scrubbed")
+}
+
+func TestEraseWhatIsLeftOfRemovedUser(t *testing.T) {
+	f := newFixture(t)
+	ctx := utils.WithCallerID(context.Background(), "admin")
+
+	assert.NoError(t, f.svc.Users.DeleteUser(ctx, f.user.ID, nil))
+
+	report, err := f.svc.Erase(ctx, f.user.ID)
+	assert.NoError(t, err)
+	assert.False(t, report.UserDeleted)
+	assert.Equal(t, 2, report.SessionsDeleted)
+	assert.Equal(t, 2, report.EventsDeleted)
+	assert.Equal(t, 2, report.AuditEntriesScrubbed)
+}
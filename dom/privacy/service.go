@@ -0,0 +1,44 @@
+package privacy
+
+import (
+	"context"
+
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/dom/session"
+	"github.com/jackmcguire1/UserService/dom/user"
+)
+
+// PrivacyService answers the access and erasure requests of data subjects
+type PrivacyService interface {
+	// Export collects everything stored about the user, soft deleted users included
+	Export(ctx context.Context, userID string) (*Export, error)
+	// Erase removes the user, their sessions and their undelivered events, scrubs them from the audit log
+	// and publishes an ERASED event
+	Erase(ctx context.Context, userID string) (*ErasureReport, error)
+}
+
+// EventDecrypter decrypts events stored encrypted, e.g. by user.EncryptedRepository
+type EventDecrypter interface {
+	Decrypt(*outbox.Message) (*outbox.Message, error)
+}
+
+type Resources struct {
+	Users    user.Repository
+	Sessions session.Repository
+	Audit    audit.AuditService
+	// Events is the outbox user events wait in until they are delivered, Decrypter is only needed
+	// when the events are stored encrypted
+	Events    outbox.Repository
+	Decrypter EventDecrypter
+}
+
+type service struct {
+	*Resources
+}
+
+func NewService(r *Resources) (*service, error) {
+	return &service{
+		Resources: r,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// MemoryRepository is a thread-safe in-memory session store, intended for tests and local development
+type MemoryRepository struct {
+	BaseRepository
+
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewMemoryRepo() *MemoryRepository {
+	return &MemoryRepository{sessions: map[string]Session{}}
+}
+
+func (repo *MemoryRepository) GetSession(_ context.Context, id string) (*Session, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	sess, ok := repo.sessions[id]
+	if !ok {
+		return nil, utils.ErrNotFound
+	}
+
+	return &sess, nil
+}
+
+func (repo *MemoryRepository) PutSession(_ context.Context, sess *Session) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.sessions[sess.ID] = *sess
+
+	return nil
+}
+
+func (repo *MemoryRepository) RotateRefreshToken(_ context.Context, id string, oldHash, newHash []byte, refreshedAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	sess, ok := repo.sessions[id]
+	if !ok || !sess.Active(refreshedAt) || !bytes.Equal(sess.RefreshTokenHash, oldHash) {
+		return fmt.Errorf("failed to rotate refresh token %w", utils.ErrNotFound)
+	}
+
+	sess.RefreshTokenHash = newHash
+	sess.RefreshedAt = refreshedAt
+	repo.sessions[id] = sess
+
+	return nil
+}
+
+func (repo *MemoryRepository) RevokeSession(_ context.Context, id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	sess, ok := repo.sessions[id]
+	if !ok || sess.RevokedAt != nil {
+		return fmt.Errorf("failed to revoke session %w", utils.ErrNotFound)
+	}
+
+	now := time.Now().UTC()
+	sess.RevokedAt = &now
+	repo.sessions[id] = sess
+
+	return nil
+}
+
+func (repo *MemoryRepository) RevokeUserSessions(_ context.Context, userID string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now().UTC()
+	for id, sess := range repo.sessions {
+		if sess.UserID == userID && sess.RevokedAt == nil {
+			sess.RevokedAt = &now
+			repo.sessions[id] = sess
+		}
+	}
+
+	return nil
+}
+
+func (repo *MemoryRepository) GetUserSessions(_ context.Context, userID string) ([]*Session, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	sessions := []*Session{}
+	for _, sess := range repo.sessions {
+		if sess.UserID == userID {
+			sess := sess
+			sessions = append(sessions, &sess)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+
+	return sessions, nil
+}
+
+func (repo *MemoryRepository) DeleteUserSessions(_ context.Context, userID string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, sess := range repo.sessions {
+		if sess.UserID == userID {
+			delete(repo.sessions, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoRepository struct {
+	BaseRepository
+
+	Collection *mongo.Collection
+}
+
+type MongoRepoParams struct {
+	Host           string
+	Database       string
+	CollectionName string
+}
+
+func NewMongoRepo(ctx context.Context, params *MongoRepoParams) (*MongoRepository, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(params.Host))
+	if err != nil {
+		return nil, err
+	}
+	database := client.Database(params.Database)
+
+	collection := database.Collection(params.CollectionName)
+
+	// expired sessions are removed by mongo once they can no longer be refreshed
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session indexes err:%w", err)
+	}
+
+	return &MongoRepository{Collection: collection}, nil
+}
+
+func (repo *MongoRepository) GetSession(ctx context.Context, id string) (*Session, error) {
+	res := repo.Collection.FindOne(ctx, bson.M{"_id": id})
+	if res.Err() != nil {
+		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, res.Err()
+	}
+
+	var sess *Session
+	err := res.Decode(&sess)
+	if err != nil {
+		err = fmt.Errorf("failed to umarshal bson session document err:%w", err)
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+func (repo *MongoRepository) PutSession(ctx context.Context, sess *Session) error {
+	opts := options.Replace().SetUpsert(true)
+
+	_, err := repo.Collection.ReplaceOne(ctx, bson.M{"_id": sess.ID}, sess, opts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *MongoRepository) RotateRefreshToken(ctx context.Context, id string, oldHash, newHash []byte, refreshedAt time.Time) error {
+	filter := bson.M{
+		"_id":              id,
+		"refreshTokenHash": oldHash,
+		"revokedAt":        bson.M{"$exists": false},
+		"expiresAt":        bson.M{"$gt": refreshedAt},
+	}
+	update := bson.M{"$set": bson.M{"refreshTokenHash": newHash, "refreshedAt": refreshedAt}}
+
+	res, err := repo.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return fmt.Errorf("failed to rotate refresh token count:%d %w", res.MatchedCount, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *MongoRepository) RevokeSession(ctx context.Context, id string) error {
+	filter := bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}}
+
+	res, err := repo.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return fmt.Errorf("failed to revoke session count:%d %w", res.MatchedCount, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *MongoRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	filter := bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}}
+
+	_, err := repo.Collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (repo *MongoRepository) GetUserSessions(ctx context.Context, userID string) ([]*Session, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := repo.Collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []*Session{}
+	err = cursor.All(ctx, &sessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to umarshal bson session documents err:%w", err)
+	}
+
+	return sessions, nil
+}
+
+func (repo *MongoRepository) DeleteUserSessions(ctx context.Context, userID string) error {
+	_, err := repo.Collection.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
@@ -0,0 +1,53 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var NotImplementedErr = fmt.Errorf("this method is not implemented")
+
+type Repository interface {
+	GetSession(context.Context, string) (*Session, error)
+	PutSession(context.Context, *Session) error
+	// RotateRefreshToken replaces the refresh token hash of the session only while it is still oldHash and the session
+	// is neither revoked nor expired at refreshedAt, so a refresh token can be exchanged once even by concurrent requests
+	RotateRefreshToken(ctx context.Context, id string, oldHash, newHash []byte, refreshedAt time.Time) error
+	RevokeSession(context.Context, string) error
+	RevokeUserSessions(context.Context, string) error
+	// GetUserSessions returns every stored session of the user, including revoked ones, oldest first
+	GetUserSessions(ctx context.Context, userID string) ([]*Session, error)
+	// DeleteUserSessions removes every session of the user, e.g. when the user is erased
+	DeleteUserSessions(ctx context.Context, userID string) error
+}
+
+type BaseRepository struct{}
+
+func (repo *BaseRepository) GetSession(context.Context, string) (*Session, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) PutSession(context.Context, *Session) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) RotateRefreshToken(context.Context, string, []byte, []byte, time.Time) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) RevokeSession(context.Context, string) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) RevokeUserSessions(context.Context, string) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) GetUserSessions(context.Context, string) ([]*Session, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) DeleteUserSessions(context.Context, string) error {
+	return NotImplementedErr
+}
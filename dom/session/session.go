@@ -0,0 +1,20 @@
+package session
+
+import (
+	"time"
+)
+
+type Session struct {
+	ID               string     `json:"_id" bson:"_id"`
+	UserID           string     `json:"userId" bson:"userId"`
+	RefreshTokenHash []byte     `json:"-" bson:"refreshTokenHash"`
+	CreatedAt        time.Time  `json:"createdAt" bson:"createdAt"`
+	RefreshedAt      time.Time  `json:"refreshedAt" bson:"refreshedAt"`
+	ExpiresAt        time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt        *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// Active reports whether the session can still be used to authenticate requests
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
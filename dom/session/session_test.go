@@ -0,0 +1,159 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRepository(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	newSession := func(id, userID string, expiresAt time.Time) *Session {
+		return &Session{
+			ID:               id,
+			UserID:           userID,
+			RefreshTokenHash: []byte("hash-" + id),
+			CreatedAt:        now,
+			RefreshedAt:      now,
+			ExpiresAt:        expiresAt,
+		}
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		sess := newSession("put-1", "user-1", now.Add(time.Hour))
+		assert.NoError(t, repo.PutSession(ctx, sess))
+
+		got, err := repo.GetSession(ctx, "put-1")
+		assert.NoError(t, err)
+		assert.Equal(t, "user-1", got.UserID)
+		assert.Equal(t, sess.RefreshTokenHash, got.RefreshTokenHash)
+		assert.True(t, got.Active(now))
+
+		_, err = repo.GetSession(ctx, "missing")
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		assert.NoError(t, repo.PutSession(ctx, newSession("revoke-1", "user-2", now.Add(time.Hour))))
+		assert.NoError(t, repo.PutSession(ctx, newSession("revoke-2", "user-2", now.Add(time.Hour))))
+		assert.NoError(t, repo.PutSession(ctx, newSession("revoke-3", "user-2", now.Add(time.Hour))))
+
+		assert.NoError(t, repo.RevokeSession(ctx, "revoke-1"))
+		got, err := repo.GetSession(ctx, "revoke-1")
+		assert.NoError(t, err)
+		assert.NotNil(t, got.RevokedAt)
+		assert.False(t, got.Active(now))
+
+		assert.ErrorIs(t, repo.RevokeSession(ctx, "revoke-1"), utils.ErrNotFound, "sessions are revoked once")
+		assert.ErrorIs(t, repo.RevokeSession(ctx, "missing"), utils.ErrNotFound)
+
+		assert.NoError(t, repo.RevokeUserSessions(ctx, "user-2"))
+		sessions, err := repo.GetUserSessions(ctx, "user-2")
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 3)
+		for _, sess := range sessions {
+			assert.False(t, sess.Active(now))
+		}
+		assert.Equal(t, got.RevokedAt.Unix(), sessions[0].RevokedAt.Unix(), "revoked sessions keep when they were revoked")
+	})
+
+	t.Run("rotate refresh token", func(t *testing.T) {
+		assert.NoError(t, repo.PutSession(ctx, newSession("rotate-1", "user-3", now.Add(time.Hour))))
+		refreshedAt := now.Add(time.Minute)
+
+		err := repo.RotateRefreshToken(ctx, "rotate-1", []byte("hash-rotate-1"), []byte("rotated"), refreshedAt)
+		assert.NoError(t, err)
+
+		got, err := repo.GetSession(ctx, "rotate-1")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("rotated"), got.RefreshTokenHash)
+		assert.True(t, refreshedAt.Equal(got.RefreshedAt))
+
+		err = repo.RotateRefreshToken(ctx, "rotate-1", []byte("hash-rotate-1"), []byte("again"), refreshedAt)
+		assert.ErrorIs(t, err, utils.ErrNotFound, "a rotated token can not be rotated again")
+		err = repo.RotateRefreshToken(ctx, "missing", []byte("hash-missing"), []byte("again"), refreshedAt)
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+
+		assert.NoError(t, repo.RevokeSession(ctx, "rotate-1"))
+		err = repo.RotateRefreshToken(ctx, "rotate-1", []byte("rotated"), []byte("again"), refreshedAt)
+		assert.ErrorIs(t, err, utils.ErrNotFound, "revoked sessions can not be refreshed")
+	})
+
+	t.Run("concurrent rotations", func(t *testing.T) {
+		assert.NoError(t, repo.PutSession(ctx, newSession("race-1", "user-4", now.Add(time.Hour))))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- repo.RotateRefreshToken(ctx, "race-1", []byte("hash-race-1"), []byte{byte(i)}, now)
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		rotated := 0
+		for err := range errs {
+			if err == nil {
+				rotated++
+				continue
+			}
+			assert.ErrorIs(t, err, utils.ErrNotFound)
+		}
+		assert.Equal(t, 1, rotated, "a refresh token is exchanged once")
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		sess := newSession("expire-1", "user-5", now.Add(time.Minute))
+		assert.NoError(t, repo.PutSession(ctx, sess))
+
+		got, err := repo.GetSession(ctx, "expire-1")
+		assert.NoError(t, err)
+		assert.True(t, got.Active(now))
+		assert.False(t, got.Active(now.Add(time.Minute)))
+
+		err = repo.RotateRefreshToken(ctx, "expire-1", []byte("hash-expire-1"), []byte("rotated"), now.Add(2*time.Minute))
+		assert.ErrorIs(t, err, utils.ErrNotFound, "expired sessions can not be refreshed")
+	})
+
+	t.Run("delete user sessions", func(t *testing.T) {
+		assert.NoError(t, repo.PutSession(ctx, newSession("delete-1", "user-6", now.Add(time.Hour))))
+		assert.NoError(t, repo.PutSession(ctx, newSession("delete-2", "user-7", now.Add(time.Hour))))
+
+		assert.NoError(t, repo.DeleteUserSessions(ctx, "user-6"))
+
+		sessions, err := repo.GetUserSessions(ctx, "user-6")
+		assert.NoError(t, err)
+		assert.Empty(t, sessions)
+		_, err = repo.GetSession(ctx, "delete-2")
+		assert.NoError(t, err, "sessions of other users are kept")
+	})
+}
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, NewMemoryRepo())
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "sessions.db")+"?_time_format=sqlite&_pragma=busy_timeout(5000)")
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	runner, err := migrations.NewRunner(db, migrations.SQLITE, nil)
+	assert.NoError(t, err)
+	_, err = runner.Up(context.Background())
+	assert.NoError(t, err)
+
+	testRepository(t, NewSQLRepo(db))
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const sessionColumns = `id, user_id, refresh_token_hash, created_at, refreshed_at, expires_at, revoked_at`
+
+// SQLRepository stores sessions in the 'sessions' table
+type SQLRepository struct {
+	BaseRepository
+
+	DB *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepository {
+	return &SQLRepository{DB: db}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (*Session, error) {
+	sess := &Session{}
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&sess.ID,
+		&sess.UserID,
+		&sess.RefreshTokenHash,
+		&sess.CreatedAt,
+		&sess.RefreshedAt,
+		&sess.ExpiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		sess.RevokedAt = &revokedAt.Time
+	}
+
+	return sess, nil
+}
+
+func (repo *SQLRepository) GetSession(ctx context.Context, id string) (*Session, error) {
+	row := repo.DB.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = $1`, id)
+
+	sess, err := scanSession(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to scan session row err:%w", err)
+	}
+
+	return sess, nil
+}
+
+func (repo *SQLRepository) PutSession(ctx context.Context, sess *Session) error {
+	_, err := repo.DB.ExecContext(ctx, `INSERT INTO sessions (`+sessionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET
+			user_id = excluded.user_id,
+			refresh_token_hash = excluded.refresh_token_hash,
+			created_at = excluded.created_at,
+			refreshed_at = excluded.refreshed_at,
+			expires_at = excluded.expires_at,
+			revoked_at = excluded.revoked_at`,
+		sess.ID,
+		sess.UserID,
+		sess.RefreshTokenHash,
+		sess.CreatedAt,
+		sess.RefreshedAt,
+		sess.ExpiresAt,
+		sess.RevokedAt,
+	)
+
+	return err
+}
+
+func (repo *SQLRepository) RotateRefreshToken(ctx context.Context, id string, oldHash, newHash []byte, refreshedAt time.Time) error {
+	res, err := repo.DB.ExecContext(ctx, `UPDATE sessions SET refresh_token_hash = $1, refreshed_at = $2
+		WHERE id = $3 AND refresh_token_hash = $4 AND revoked_at IS NULL AND expires_at > $2`,
+		newHash, refreshedAt, id, oldHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("failed to rotate refresh token count:%d %w", count, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *SQLRepository) RevokeSession(ctx context.Context, id string) error {
+	res, err := repo.DB.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("failed to revoke session count:%d %w", count, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *SQLRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	_, err := repo.DB.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`,
+		time.Now().UTC(), userID,
+	)
+
+	return err
+}
+
+func (repo *SQLRepository) GetUserSessions(ctx context.Context, userID string) ([]*Session, error) {
+	rows, err := repo.DB.QueryContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions WHERE user_id = $1 ORDER BY created_at`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session row err:%w", err)
+		}
+		sessions = append(sessions, sess)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (repo *SQLRepository) DeleteUserSessions(ctx context.Context, userID string) error {
+	_, err := repo.DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
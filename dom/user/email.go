@@ -0,0 +1,149 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// gmailDomains ignore dots and '+' suffixes in the local part and are the same mailbox
+var gmailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+}
+
+// EmailNormalizer derives the key emails are looked up and deduplicated by,
+// users keep the address they entered for display
+type EmailNormalizer struct {
+	// FoldGmail removes dots and '+' suffixes from gmail addresses so 'j.doe+news@gmail.com'
+	// and 'jdoe@googlemail.com' are the same account
+	FoldGmail bool
+}
+
+// CleanEmail trims and NFC normalizes the address the user entered,
+// so visually identical addresses are stored identically
+func CleanEmail(email string) string {
+	return norm.NFC.String(strings.TrimSpace(email))
+}
+
+// Normalize returns the lookup key of the email, domains are case-insensitive and so are the
+// local parts of every mainstream provider, so the whole address is lowercased
+func (n EmailNormalizer) Normalize(email string) string {
+	email = strings.ToLower(CleanEmail(email))
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+
+	if n.FoldGmail && gmailDomains[domain] {
+		if plus := strings.Index(local, "+"); plus >= 0 {
+			local = local[:plus]
+		}
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	}
+
+	return local + "@" + domain
+}
+
+// EmailBackfill reports the outcome of BackfillNormalizedEmails
+type EmailBackfill struct {
+	Scanned int `json:"scanned"`
+	Updated int `json:"updated"`
+	// Collisions are users whose normalized email is taken by another user,
+	// they keep matching on their email until an administrator changes or merges one of them
+	Collisions []*EmailCollision `json:"collisions"`
+}
+
+type EmailCollision struct {
+	NormalizedEmail string `json:"normalizedEmail"`
+	// KeptUserID owns the normalized email, the user which already had it or else the oldest
+	KeptUserID string   `json:"keptUserId"`
+	UserIDs    []string `json:"userIds"`
+}
+
+// BackfillNormalizedEmails sets the normalized email of every user that lacks an up-to-date one,
+// when several users normalize to the same email only the oldest is updated and the rest are reported.
+// Users are rewritten at their version without events as nothing published about them changes, the write is
+// still conditional on the version so a concurrent update is not overwritten, with dryRun nothing is written
+func BackfillNormalizedEmails(ctx context.Context, repo Repository, normalizer EmailNormalizer, dryRun bool) (*EmailBackfill, error) {
+	users, err := repo.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &EmailBackfill{Scanned: len(users), Collisions: []*EmailCollision{}}
+
+	// encrypted users hold the blind index of their normalized email
+	owns := func(u *User, key string) bool { return u.NormalizedEmail == key }
+	if encrypted, ok := repo.(*EncryptedRepository); ok {
+		owns = func(u *User, key string) bool {
+			return u.NormalizedEmail == key || u.NormalizedEmail == encrypted.Cipher.BlindIndex(key)
+		}
+	}
+
+	groups := map[string][]*User{}
+	keys := []string{}
+	for _, u := range users {
+		key := normalizer.Normalize(u.Email)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], u)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		group := groups[key]
+
+		// a user already owning the key keeps it, otherwise the oldest user does
+		sort.SliceStable(group, func(i, j int) bool {
+			if first := owns(group[i], key); first != owns(group[j], key) {
+				return first
+			}
+			if group[i].Saved != group[j].Saved {
+				return group[i].Saved < group[j].Saved
+			}
+			return group[i].ID < group[j].ID
+		})
+		kept := group[0]
+
+		if len(group) > 1 {
+			report.Collisions = append(report.Collisions, &EmailCollision{
+				NormalizedEmail: key,
+				KeptUserID:      kept.ID,
+				UserIDs:         userIDs(group),
+			})
+		}
+
+		if owns(kept, key) {
+			continue
+		}
+		report.Updated++
+		if dryRun {
+			continue
+		}
+
+		kept.NormalizedEmail = key
+		err = repo.RewriteUser(ctx, kept)
+		if err != nil {
+			return report, fmt.Errorf("failed to backfill normalized email of user %s err:%w", kept.ID, err)
+		}
+	}
+
+	return report, nil
+}
+
+func userIDs(users []*User) []string {
+	ids := []string{}
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,117 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email     string
+		foldGmail bool
+		expected  string
+	}{
+		{email: "bob@example.com", expected: "bob@example.com"},
+		{email: "  Bob@Example.COM ", expected: "bob@example.com"},
+		// 'é' as 'e' and a combining accent composes to the single code point
+		{email: "Rene\u0301@example.com", expected: "ren\u00e9@example.com"},
+		{email: "J.Doe+news@Gmail.com", expected: "j.doe+news@gmail.com"},
+		{email: "J.Doe+news@Gmail.com", foldGmail: true, expected: "jdoe@gmail.com"},
+		{email: "jdoe@googlemail.com", foldGmail: true, expected: "jdoe@gmail.com"},
+		{email: "j.doe+news@example.com", foldGmail: true, expected: "j.doe+news@example.com"},
+		{email: "not-an-email", expected: "not-an-email"},
+	}
+
+	for _, tc := range tests {
+		normalizer := EmailNormalizer{FoldGmail: tc.foldGmail}
+		assert.Equal(t, tc.expected, normalizer.Normalize(tc.email), tc.email)
+	}
+}
+
+func TestPutUserNormalizesEmail(t *testing.T) {
+	user := &User{
+		FirstName:   "Bob",
+		LastName:    "Doe",
+		CountryCode: "GB",
+		Email:       " B.ob+news@Gmail.com ",
+	}
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("PutUser", user, mock.Anything).Return(nil)
+
+	svc, err := NewService(&Resources{Repo: mockRepo, Emails: EmailNormalizer{FoldGmail: true}})
+	assert.NoError(t, err)
+
+	_, err = svc.PutUser(context.Background(), user)
+	assert.NoError(t, err)
+	assert.Equal(t, "B.ob+news@Gmail.com", user.Email, "the email is kept as entered for display")
+	assert.Equal(t, "bob@gmail.com", user.NormalizedEmail)
+}
+
+func TestGetUserByEmailNormalizes(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetUserByEmail", "bob@example.com").Return(&User{ID: "1234"}, nil)
+
+	svc, err := NewService(&Resources{Repo: mockRepo})
+	assert.NoError(t, err)
+
+	u, err := svc.GetUserByEmail(context.Background(), "Bob@Example.com ")
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", u.ID)
+}
+
+func TestBackfillNormalizedEmails(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo(nil)
+
+	legacy := []*User{
+		{ID: "1", Email: "Bob@Example.com", Version: 1, Saved: "2024-01-01T00:00:00Z"},
+		{ID: "2", Email: "jdoe+news@gmail.com", Version: 1, Saved: "2024-01-03T00:00:00Z"},
+		{ID: "3", Email: "j.doe@gmail.com", Version: 1, Saved: "2024-01-02T00:00:00Z"},
+		{ID: "4", Email: "alice@example.com", NormalizedEmail: "alice@example.com", Version: 1, Saved: "2024-01-04T00:00:00Z"},
+	}
+	for _, u := range legacy {
+		assert.NoError(t, repo.PutUser(ctx, u, nil))
+	}
+	normalizer := EmailNormalizer{FoldGmail: true}
+
+	report, err := BackfillNormalizedEmails(ctx, repo, normalizer, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Scanned)
+	assert.Equal(t, 2, report.Updated)
+
+	u, err := repo.GetUser(ctx, "1")
+	assert.NoError(t, err)
+	assert.Empty(t, u.NormalizedEmail, "dry runs do not write")
+
+	report, err = BackfillNormalizedEmails(ctx, repo, normalizer, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Updated)
+	assert.Equal(t, []*EmailCollision{{
+		NormalizedEmail: "jdoe@gmail.com",
+		KeptUserID:      "3",
+		UserIDs:         []string{"3", "2"},
+	}}, report.Collisions)
+
+	u, err = repo.GetUserByEmail(ctx, "bob@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", u.ID)
+	assert.Equal(t, "Bob@Example.com", u.Email)
+	assert.Equal(t, int64(1), u.Version, "the backfill publishes nothing so the version is kept")
+
+	u, err = repo.GetUserByEmail(ctx, "jdoe@gmail.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", u.ID, "the oldest user keeps the normalized email")
+
+	u, err = repo.GetUser(ctx, "2")
+	assert.NoError(t, err)
+	assert.Empty(t, u.NormalizedEmail, "colliding users are left for an administrator")
+
+	report, err = BackfillNormalizedEmails(ctx, repo, normalizer, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Updated, "the backfill is idempotent")
+	assert.Len(t, report.Collisions, 1, "collisions are reported until resolved")
+}
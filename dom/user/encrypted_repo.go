@@ -0,0 +1,408 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/fieldcrypt"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// EncryptedRepository encrypts the names, email and nickname of users before the wrapped Repository stores them
+// and decrypts them when they are read. Every user has its own data key, stored wrapped in DataKey and tagged with
+// the version of the key which wrapped it, and the normalized email is stored as its blind index so users are still
+// looked up by email and emails stay unique. Users saved before encryption was enabled are returned as they are
+// until they are written again or ReEncryptUsers encrypts them
+type EncryptedRepository struct {
+	Repository
+
+	Cipher *fieldcrypt.Cipher
+}
+
+func NewEncryptedRepo(repo Repository, cipher *fieldcrypt.Cipher) *EncryptedRepository {
+	return &EncryptedRepository{Repository: repo, Cipher: cipher}
+}
+
+// encryptedFields are the personal fields of a user stored encrypted, by json name
+var encryptedFields = map[string]func(u *User) *string{
+	"firstName": func(u *User) *string { return &u.FirstName },
+	"lastName":  func(u *User) *string { return &u.LastName },
+	"email":     func(u *User) *string { return &u.Email },
+	"nickName":  func(u *User) *string { return &u.NickName },
+}
+
+func (repo *EncryptedRepository) GetUser(ctx context.Context, id string) (*User, error) {
+	u, err := repo.Repository.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.decrypt(ctx, u)
+}
+
+// GetUserByEmail looks up the blind index of the normalized email,
+// users which have not been encrypted yet are looked up by the normalized email itself
+func (repo *EncryptedRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	u, err := repo.Repository.GetUserByEmail(ctx, repo.Cipher.BlindIndex(email))
+	if errors.Is(err, utils.ErrNotFound) {
+		u, err = repo.Repository.GetUserByEmail(ctx, email)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.decrypt(ctx, u)
+}
+
+func (repo *EncryptedRepository) GetUsersByCountry(ctx context.Context, cc string) ([]*User, error) {
+	users, err := repo.Repository.GetUsersByCountry(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.decryptAll(ctx, users)
+}
+
+func (repo *EncryptedRepository) GetAllUsers(ctx context.Context) ([]*User, error) {
+	users, err := repo.Repository.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.decryptAll(ctx, users)
+}
+
+// SearchUsers rejects filtering and sorting by the encrypted fields, the stored ciphertexts neither share
+// prefixes nor sort like the values they encrypt
+func (repo *EncryptedRepository) SearchUsers(ctx context.Context, query *SearchQuery) (*SearchResult, error) {
+	switch {
+	case query.EmailDomain != "":
+		return nil, fmt.Errorf("%w - cannot filter by email domain while emails are encrypted", utils.ValidationErr)
+	case query.NamePrefix != "":
+		return nil, fmt.Errorf("%w - cannot filter by name prefix while names are encrypted", utils.ValidationErr)
+	case query.SortBy == SortByLastName || query.SortBy == SortByEmail:
+		return nil, fmt.Errorf("%w - cannot sort by %q while it is encrypted", utils.ValidationErr, query.SortBy)
+	}
+
+	result, err := repo.Repository.SearchUsers(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Users, err = repo.decryptAll(ctx, result.Users)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// PutUser stores the user with its personal fields encrypted, a user without a data key is given one and
+// a data key wrapped with a retired key is rewrapped with the current key
+func (repo *EncryptedRepository) PutUser(ctx context.Context, u *User, event *UserUpdate) error {
+	encrypted, key, err := repo.encryptForWrite(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	event, err = encryptEvent(event, key)
+	if err != nil {
+		return err
+	}
+
+	err = repo.Repository.PutUser(ctx, encrypted, event)
+	if err != nil {
+		return err
+	}
+	u.DataKey = encrypted.DataKey
+
+	return nil
+}
+
+// RewriteUser stores the user with its personal fields encrypted like PutUser does
+func (repo *EncryptedRepository) RewriteUser(ctx context.Context, u *User) error {
+	encrypted, _, err := repo.encryptForWrite(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	err = repo.Repository.RewriteUser(ctx, encrypted)
+	if err != nil {
+		return err
+	}
+	u.DataKey = encrypted.DataKey
+
+	return nil
+}
+
+// encryptForWrite returns the user with its personal fields encrypted and the data key they were encrypted with
+func (repo *EncryptedRepository) encryptForWrite(ctx context.Context, u *User) (*User, *fieldcrypt.DataKey, error) {
+	// users which have not been encrypted yet are only unique by their plaintext normalized email
+	if isPlainEmail(u.NormalizedEmail) {
+		existing, err := repo.Repository.GetUserByEmail(ctx, u.NormalizedEmail)
+		if err != nil && !errors.Is(err, utils.ErrNotFound) {
+			return nil, nil, err
+		}
+		if existing != nil && existing.ID != u.ID {
+			return nil, nil, fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+		}
+	}
+
+	key, err := repo.dataKey(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encrypted, err := repo.encrypt(u, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encrypted, key, nil
+}
+
+// DeleteUser stores the event with the personal fields of the deleted user encrypted under its data key
+func (repo *EncryptedRepository) DeleteUser(ctx context.Context, id string, event *UserUpdate) error {
+	if event != nil && event.Previous != nil {
+		key, err := repo.dataKey(ctx, event.Previous)
+		if err != nil {
+			return err
+		}
+
+		event, err = encryptEvent(event, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return repo.Repository.DeleteUser(ctx, id, event)
+}
+
+// dataKey opens the data key of the user, a user without a data key is given one and
+// a data key wrapped with a retired key is rewrapped with the current key
+func (repo *EncryptedRepository) dataKey(ctx context.Context, u *User) (*fieldcrypt.DataKey, error) {
+	var key *fieldcrypt.DataKey
+	var err error
+	if u.DataKey == "" {
+		key, err = repo.Cipher.NewDataKey(ctx)
+	} else {
+		key, err = repo.Cipher.OpenDataKey(ctx, u.DataKey)
+		if err == nil && !repo.Cipher.Current(key.Envelope) {
+			key.Envelope, err = repo.Cipher.Rewrap(ctx, key.Envelope)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the data key of user %s err:%w", u.ID, err)
+	}
+
+	return key, nil
+}
+
+// encrypt returns a copy of the user with its personal fields encrypted and its normalized email replaced by
+// its blind index, users which have not been backfilled yet are indexed by the lowercased email they are matched by
+func (repo *EncryptedRepository) encrypt(u *User, key *fieldcrypt.DataKey) (*User, error) {
+	encrypted, err := encryptUser(u, key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isPlainEmail(u.NormalizedEmail):
+		encrypted.NormalizedEmail = repo.Cipher.BlindIndex(u.NormalizedEmail)
+	case u.NormalizedEmail == "" && u.Email != "":
+		encrypted.NormalizedEmail = repo.Cipher.BlindIndex(strings.ToLower(u.Email))
+	}
+
+	return encrypted, nil
+}
+
+// encryptUser returns a copy of the user with its personal fields encrypted with the key
+func encryptUser(u *User, key *fieldcrypt.DataKey) (*User, error) {
+	encrypted := *u
+	encrypted.DataKey = key.Envelope
+
+	var err error
+	for name, field := range encryptedFields {
+		*field(&encrypted), err = key.Encrypt(*field(u), fieldContext(u.ID, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s of user %s err:%w", name, u.ID, err)
+		}
+	}
+
+	return &encrypted, nil
+}
+
+// encryptEvent returns a copy of the event with the personal fields of both versions of the user encrypted with
+// the key of the user, so the outbox and its dead letters never hold them in plaintext
+func encryptEvent(event *UserUpdate, key *fieldcrypt.DataKey) (*UserUpdate, error) {
+	if event == nil || (event.User == nil && event.Previous == nil) {
+		return event, nil
+	}
+
+	encrypted := *event
+	encrypted.DataKey = key.Envelope
+
+	var err error
+	if event.User != nil {
+		if encrypted.User, err = encryptUser(event.User, key); err != nil {
+			return nil, err
+		}
+	}
+	if event.Previous != nil {
+		if encrypted.Previous, err = encryptUser(event.Previous, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &encrypted, nil
+}
+
+// decrypt decrypts the personal fields of the stored user in place, the normalized email stays its blind index
+func (repo *EncryptedRepository) decrypt(ctx context.Context, u *User) (*User, error) {
+	if u.DataKey == "" {
+		return u, nil
+	}
+
+	key, err := repo.Cipher.OpenDataKey(ctx, u.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the data key of user %s err:%w", u.ID, err)
+	}
+
+	return decryptUser(u, key)
+}
+
+func decryptUser(u *User, key *fieldcrypt.DataKey) (*User, error) {
+	var err error
+	for name, field := range encryptedFields {
+		*field(u), err = key.Decrypt(*field(u), fieldContext(u.ID, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s of user %s err:%w", name, u.ID, err)
+		}
+	}
+
+	return u, nil
+}
+
+func (repo *EncryptedRepository) decryptAll(ctx context.Context, users []*User) ([]*User, error) {
+	for _, u := range users {
+		if _, err := repo.decrypt(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+// fieldContext binds a ciphertext to the user and field it was encrypted for
+func fieldContext(id, field string) string {
+	return id + "/" + field
+}
+
+// isPlainEmail tells a normalized email apart from its blind index, which is hex and never contains an '@'
+func isPlainEmail(normalizedEmail string) bool {
+	return strings.Contains(normalizedEmail, "@")
+}
+
+// DecryptingPublisher decrypts the users of events stored by an EncryptedRepository before handing them to the
+// next publisher, the stored message keeps its ciphertexts so retries and dead letters stay encrypted.
+// Retired key encryption keys must be kept until the outbox has drained of events wrapped with them
+type DecryptingPublisher struct {
+	Next   outbox.Publisher
+	Cipher *fieldcrypt.Cipher
+}
+
+func NewDecryptingPublisher(next outbox.Publisher, cipher *fieldcrypt.Cipher) *DecryptingPublisher {
+	return &DecryptingPublisher{Next: next, Cipher: cipher}
+}
+
+func (p *DecryptingPublisher) Publish(msg *outbox.Message) error {
+	decrypted, err := p.Decrypt(msg)
+	if err != nil {
+		return err
+	}
+
+	return p.Next.Publish(decrypted)
+}
+
+// Decrypt returns a copy of the message with the users of its event decrypted,
+// messages stored without encryption are returned as they are
+func (p *DecryptingPublisher) Decrypt(msg *outbox.Message) (*outbox.Message, error) {
+	event := &UserUpdate{}
+	if err := json.Unmarshal([]byte(msg.Payload), event); err != nil {
+		return nil, fmt.Errorf("failed to parse event %s err:%w", msg.ID, err)
+	}
+	if event.DataKey == "" {
+		return msg, nil
+	}
+
+	key, err := p.Cipher.OpenDataKey(context.Background(), event.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the data key of event %s err:%w", msg.ID, err)
+	}
+
+	for _, u := range []*User{event.User, event.Previous} {
+		if u == nil {
+			continue
+		}
+		if _, err = decryptUser(u, key); err != nil {
+			return nil, err
+		}
+	}
+	event.DataKey = ""
+
+	decrypted := *msg
+	decrypted.Payload = utils.ToJSON(event)
+
+	return &decrypted, nil
+}
+
+type ReEncryption struct {
+	Scanned int `json:"scanned"`
+	// Encrypted counts users stored in plaintext which were encrypted,
+	// Rewrapped the users whose data key was wrapped with a retired key
+	Encrypted int `json:"encrypted"`
+	Rewrapped int `json:"rewrapped"`
+}
+
+// ReEncryptUsers encrypts every user still stored in plaintext and rewraps every data key which is not wrapped with
+// the current key, after which retired keys can be removed from the key provider. Rewrapping leaves the encrypted
+// fields as they are. Nothing published about the users changes, so like BackfillNormalizedEmails they are rewritten at
+// their version without events, with dryRun nothing is written
+func ReEncryptUsers(ctx context.Context, repo *EncryptedRepository, dryRun bool) (*ReEncryption, error) {
+	users, err := repo.Repository.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReEncryption{Scanned: len(users)}
+	for _, u := range users {
+		switch {
+		case u.DataKey == "":
+			report.Encrypted++
+			if dryRun {
+				continue
+			}
+
+			err = repo.RewriteUser(ctx, u)
+		case !repo.Cipher.Current(u.DataKey):
+			report.Rewrapped++
+			if dryRun {
+				continue
+			}
+
+			u.DataKey, err = repo.Cipher.Rewrap(ctx, u.DataKey)
+			if err == nil {
+				err = repo.Repository.RewriteUser(ctx, u)
+			}
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to re-encrypt user %s err:%w", u.ID, err)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,278 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/fieldcrypt"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCipher(t *testing.T, current string) *fieldcrypt.Cipher {
+	keys, err := fieldcrypt.NewLocalKeyProvider(current, map[string][]byte{
+		"1": bytes.Repeat([]byte{1}, fieldcrypt.DataKeySize),
+		"2": bytes.Repeat([]byte{2}, fieldcrypt.DataKeySize),
+	}, bytes.Repeat([]byte{9}, fieldcrypt.MinIndexKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipher, err := fieldcrypt.NewCipher(keys, keys.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cipher
+}
+
+func TestEncryptedRepository(t *testing.T) {
+	ctx := utils.WithCallerID(context.Background(), "admin")
+	inner := NewMemoryRepo(outbox.NewMemoryRepo())
+	svc, err := NewService(&Resources{Repo: NewEncryptedRepo(inner, newTestCipher(t, "1"))})
+	assert.NoError(t, err)
+
+	u, err := svc.PutUser(ctx, &User{FirstName: "John", LastName: "Doe", NickName: "jd", CountryCode: "GB", Email: "John@Example.com"})
+	assert.NoError(t, err)
+
+	stored, err := inner.GetUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", fieldcrypt.KeyID(stored.DataKey))
+	assert.Len(t, stored.NormalizedEmail, 64)
+	for _, value := range []string{stored.FirstName, stored.LastName, stored.NickName, stored.Email, stored.NormalizedEmail} {
+		assert.NotContains(t, strings.ToLower(value), "john")
+	}
+	assert.Equal(t, "GB", stored.CountryCode)
+
+	got, err := svc.GetUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "John", got.FirstName)
+	assert.Equal(t, "Doe", got.LastName)
+	assert.Equal(t, "jd", got.NickName)
+	assert.Equal(t, "John@Example.com", got.Email)
+
+	got, err = svc.GetUserByEmail(ctx, "john@example.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, u.ID, got.ID)
+
+	_, err = svc.PutUser(ctx, &User{FirstName: "Jane", LastName: "Doe", CountryCode: "GB", Email: "JOHN@example.com"})
+	assert.ErrorIs(t, err, utils.AlreadyExists)
+
+	got.LastName = "Smith"
+	updated, err := svc.PutUser(ctx, got)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updated.Version)
+
+	stored, err = inner.GetUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, u.DataKey, stored.DataKey, "the data key is kept across writes")
+
+	users, err := svc.GetUsersByCountry(ctx, "GB")
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "Smith", users[0].LastName)
+
+	result, err := svc.SearchUsers(ctx, &SearchQuery{CountryCode: "GB"})
+	assert.NoError(t, err)
+	assert.Len(t, result.Users, 1)
+	assert.Equal(t, "John@Example.com", result.Users[0].Email)
+
+	for _, query := range []*SearchQuery{
+		{NamePrefix: "jo"},
+		{EmailDomain: "example.com"},
+		{SortBy: SortByLastName},
+		{SortBy: SortByEmail},
+	} {
+		_, err = svc.SearchUsers(ctx, query)
+		assert.ErrorIs(t, err, utils.ValidationErr)
+	}
+}
+
+func TestEncryptedRepositoryRejectsMovedCiphertexts(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryRepo(outbox.NewMemoryRepo())
+	repo := NewEncryptedRepo(inner, newTestCipher(t, "1"))
+
+	for _, id := range []string{"1", "2"} {
+		assert.NoError(t, repo.PutUser(ctx, &User{ID: id, FirstName: "User" + id, Email: id + "@example.com", Version: 1}, nil))
+	}
+
+	first, err := inner.GetUser(ctx, "1")
+	assert.NoError(t, err)
+	second, err := inner.GetUser(ctx, "2")
+	assert.NoError(t, err)
+
+	// swapping the data key along with the ciphertext still fails as the ciphertext is bound to its user
+	second.FirstName, second.DataKey, second.Version = first.FirstName, first.DataKey, 2
+	assert.NoError(t, inner.PutUser(ctx, second, nil))
+
+	_, err = repo.GetUser(ctx, "2")
+	assert.Error(t, err)
+}
+
+func TestReEncryptUsers(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryRepo(outbox.NewMemoryRepo())
+
+	legacy := &User{ID: "1", FirstName: "John", Email: "John@example.com", NormalizedEmail: "john@example.com", Version: 1}
+	assert.NoError(t, inner.PutUser(ctx, legacy, nil))
+	unbackfilled := &User{ID: "2", FirstName: "Jane", Email: "Jane@example.com", Version: 1}
+	assert.NoError(t, inner.PutUser(ctx, unbackfilled, nil))
+
+	repo := NewEncryptedRepo(inner, newTestCipher(t, "1"))
+
+	got, err := repo.GetUserByEmail(ctx, "john@example.com")
+	assert.NoError(t, err, "plaintext users are still found")
+	assert.Equal(t, "John", got.FirstName)
+
+	err = repo.PutUser(ctx, &User{ID: "3", Email: "john@example.com", NormalizedEmail: "john@example.com", Version: 1}, nil)
+	assert.ErrorIs(t, err, utils.AlreadyExists, "plaintext users keep their email")
+
+	report, err := ReEncryptUsers(ctx, repo, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReEncryption{Scanned: 2, Encrypted: 2}, report)
+
+	stored, err := inner.GetUser(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "", stored.DataKey, "dry runs do not write")
+
+	report, err = ReEncryptUsers(ctx, repo, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReEncryption{Scanned: 2, Encrypted: 2}, report)
+
+	stored, err = inner.GetUser(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", fieldcrypt.KeyID(stored.DataKey))
+	assert.NotEqual(t, "John", stored.FirstName)
+	assert.Equal(t, int64(1), stored.Version, "re-encryption publishes nothing so the version is kept")
+
+	for email, id := range map[string]string{"john@example.com": "1", "jane@example.com": "2"} {
+		got, err = repo.GetUserByEmail(ctx, email)
+		assert.NoError(t, err)
+		assert.Equal(t, id, got.ID)
+	}
+
+	// rotate to key 2, only the data keys are rewrapped
+	rotated := NewEncryptedRepo(inner, newTestCipher(t, "2"))
+	report, err = ReEncryptUsers(ctx, rotated, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReEncryption{Scanned: 2, Rewrapped: 2}, report)
+
+	rewrapped, err := inner.GetUser(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", fieldcrypt.KeyID(rewrapped.DataKey))
+	assert.Equal(t, stored.FirstName, rewrapped.FirstName)
+	assert.Equal(t, int64(1), rewrapped.Version)
+
+	got, err = rotated.GetUserByEmail(ctx, "john@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "John", got.FirstName)
+
+	report, err = ReEncryptUsers(ctx, rotated, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReEncryption{Scanned: 2}, report)
+}
+
+func TestEncryptedSQLiteRepository(t *testing.T) {
+	ctx := context.Background()
+
+	inner, err := NewSQLiteRepo(ctx, &SQLiteRepoParams{Path: filepath.Join(t.TempDir(), "users.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { inner.DB.Close() })
+
+	runner, err := migrations.NewRunner(inner.DB, migrations.SQLITE, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = runner.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewEncryptedRepo(inner, newTestCipher(t, "1"))
+	u := &User{ID: "1", FirstName: "John", LastName: "Doe", Email: "john@example.com", NormalizedEmail: "john@example.com", Version: 1}
+	assert.NoError(t, repo.PutUser(ctx, u, NewUserUpdate(nil, u, u.ID)))
+
+	var email, dataKey string
+	err = inner.DB.QueryRowContext(ctx, `SELECT email, data_key FROM users WHERE id = '1'`).Scan(&email, &dataKey)
+	assert.NoError(t, err)
+	assert.NotContains(t, email, "john")
+	assert.Equal(t, u.DataKey, dataKey)
+
+	got, err := repo.GetUserByEmail(ctx, "john@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "Doe", got.LastName)
+
+	err = repo.PutUser(ctx, &User{ID: "2", Email: "john@example.com", NormalizedEmail: "john@example.com", Version: 1}, nil)
+	assert.ErrorIs(t, err, utils.AlreadyExists)
+}
+
+func TestBackfillEncryptedUsers(t *testing.T) {
+	ctx := context.Background()
+	repo := NewEncryptedRepo(NewMemoryRepo(outbox.NewMemoryRepo()), newTestCipher(t, "1"))
+
+	assert.NoError(t, repo.PutUser(ctx, &User{ID: "1", Email: "John@example.com", NormalizedEmail: "john@example.com", Version: 1}, nil))
+	assert.NoError(t, repo.PutUser(ctx, &User{ID: "2", Email: "Jane@example.com", Version: 1}, nil))
+
+	report, err := BackfillNormalizedEmails(ctx, repo, EmailNormalizer{}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Updated, "blind indexes of up-to-date normalized emails are kept")
+
+	report, err = BackfillNormalizedEmails(ctx, repo, EmailNormalizer{FoldGmail: true}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Updated)
+}
+
+func TestEncryptedRepositoryEvents(t *testing.T) {
+	ctx := utils.WithCallerID(context.Background(), "admin")
+	events := outbox.NewMemoryRepo()
+	cipher := newTestCipher(t, "1")
+	repo := NewEncryptedRepo(NewMemoryRepo(events), cipher)
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+
+	u, err := svc.PutUser(ctx, &User{FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.NoError(t, err)
+	u.LastName = "Smith"
+	_, err = svc.PutUser(ctx, u)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.DeleteUser(ctx, u.ID, NewUserUpdate(u, nil, "admin")))
+
+	msgs := events.Messages()
+	assert.Len(t, msgs, 3)
+
+	var published []*outbox.Message
+	publisher := NewDecryptingPublisher(outbox.PublisherFunc(func(msg *outbox.Message) error {
+		published = append(published, msg)
+		return nil
+	}), cipher)
+
+	for _, msg := range msgs {
+		stored := msg.Payload
+		assert.NotContains(t, stored, "john@example.com")
+		assert.NotContains(t, stored, "Doe")
+		assert.Contains(t, stored, `"DataKey"`)
+
+		assert.NoError(t, publisher.Publish(msg))
+		assert.Equal(t, stored, msg.Payload, "the stored message stays encrypted")
+	}
+
+	assert.Len(t, published, 3)
+	for _, msg := range published {
+		assert.Contains(t, msg.Payload, "john@example.com")
+		assert.NotContains(t, msg.Payload, `"DataKey"`)
+	}
+
+	event := &UserUpdate{}
+	assert.NoError(t, json.Unmarshal([]byte(published[1].Payload), event))
+	assert.Equal(t, "Smith", event.User.LastName)
+	assert.Equal(t, "Doe", event.Previous.LastName)
+	assert.Equal(t, []string{"lastName"}, event.ChangedFields)
+}
@@ -0,0 +1,158 @@
+package user
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	EventCreated = "CREATED"
+	EventUpdated = "UPDATED"
+	EventDeleted = "DELETED"
+	// EventRestored is published when a soft deleted user is restored,
+	// EventPurged once the retention window has passed and the user is gone for good
+	EventRestored = "RESTORED"
+	EventPurged   = "PURGED"
+	// EventErased is published when the user exercised their right to erasure,
+	// consumers must remove everything they stored about the user
+	EventErased = "ERASED"
+)
+
+// EventTypes are every user event type published to the outbox
+var EventTypes = []string{EventCreated, EventUpdated, EventDeleted, EventRestored, EventPurged, EventErased}
+
+// UserUpdate is the event published when a user changes,
+// it is written to the outbox together with the user so it survives restarts
+type UserUpdate struct {
+	ID     string
+	UserID string
+	Status string
+
+	// User is the user after the write and is nil once deleted,
+	// Previous is the user before the write and is nil on creation
+	User     *User
+	Previous *User
+
+	// ChangedFields lists the json names of the fields that differ from the previous version,
+	// password hashes are never published so a new password is only flagged by PasswordChanged
+	ChangedFields   []string
+	PasswordChanged bool
+
+	ActorID   string
+	Version   int64
+	CreatedAt time.Time
+
+	// DataKey is set while the event waits in the outbox of an EncryptedRepository, the personal fields of
+	// User and Previous are then stored encrypted with it and DecryptingPublisher decrypts them before publishing
+	DataKey string `json:",omitempty"`
+
+	// removedVersion is the version a removal enqueueing the event may only remove the user at, zero removes any version
+	removedVersion int64
+}
+
+// NewUserUpdate describes the change from previous to current made by the actor,
+// the event type is derived from which of the two versions exist
+func NewUserUpdate(previous, current *User, actorID string) *UserUpdate {
+	update := &UserUpdate{
+		ID:        uuid.NewString(),
+		User:      current,
+		Previous:  previous,
+		ActorID:   actorID,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	switch {
+	case current == nil:
+		update.Status = EventDeleted
+		update.UserID = previous.ID
+		update.Version = previous.Version + 1
+		update.ChangedFields = []string{}
+		update.removedVersion = previous.Version
+	case previous == nil:
+		update.Status = EventCreated
+		update.UserID = current.ID
+		update.Version = current.Version
+		update.ChangedFields = current.Diff(&User{})
+		update.PasswordChanged = len(current.Password) > 0
+	default:
+		update.Status = EventUpdated
+		update.UserID = current.ID
+		update.Version = current.Version
+		update.ChangedFields = current.Diff(previous)
+		update.PasswordChanged = !bytes.Equal(current.Password, previous.Password)
+	}
+
+	return update
+}
+
+// NewUserErasure describes the erasure of the user by the actor, unlike other events it carries no
+// version of the user so the personal data is not published again
+func NewUserErasure(u *User, actorID string) *UserUpdate {
+	return &UserUpdate{
+		ID:            uuid.NewString(),
+		UserID:        u.ID,
+		Status:        EventErased,
+		ChangedFields: []string{},
+		ActorID:       actorID,
+		Version:       u.Version + 1,
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+// NewUserPurge describes the removal of the soft deleted user once its retention window has passed, like an erasure
+// it carries no version of the user, the user is only removed while it is still at the version it was purged at
+func NewUserPurge(u *User, actorID string) *UserUpdate {
+	return &UserUpdate{
+		ID:             uuid.NewString(),
+		UserID:         u.ID,
+		Status:         EventPurged,
+		ChangedFields:  []string{},
+		ActorID:        actorID,
+		Version:        u.Version + 1,
+		CreatedAt:      time.Now().UTC(),
+		removedVersion: u.Version,
+	}
+}
+
+// Message converts the update into an outbox message, the event ID doubles as the message ID
+func (update *UserUpdate) Message() *outbox.Message {
+	return &outbox.Message{
+		ID:            update.ID,
+		Type:          update.Status,
+		Subject:       update.UserID,
+		Payload:       utils.ToJSON(update),
+		CreatedAt:     update.CreatedAt,
+		NextAttemptAt: update.CreatedAt,
+	}
+}
+
+// Diff returns the json names of the profile fields which differ from the previous version,
+// bookkeeping fields such as 'saved' and 'version' and the password are not compared
+func (u *User) Diff(previous *User) []string {
+	changed := []string{}
+
+	if u.FirstName != previous.FirstName {
+		changed = append(changed, "firstName")
+	}
+	if u.LastName != previous.LastName {
+		changed = append(changed, "lastName")
+	}
+	if u.Email != previous.Email {
+		changed = append(changed, "email")
+	}
+	if u.NickName != previous.NickName {
+		changed = append(changed, "nickName")
+	}
+	if u.CountryCode != previous.CountryCode {
+		changed = append(changed, "countryCode")
+	}
+	if u.IsAdmin != previous.IsAdmin {
+		changed = append(changed, "is_admin")
+	}
+
+	return changed
+}
@@ -0,0 +1,225 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// MemoryRepository is a thread-safe in-memory user store, intended for tests and local development
+type MemoryRepository struct {
+	BaseRepository
+
+	// Outbox receives user events while the write lock is held, events are dropped when nil
+	Outbox outbox.Repository
+
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+func NewMemoryRepo(events outbox.Repository) *MemoryRepository {
+	return &MemoryRepository{
+		Outbox: events,
+		users:  map[string]User{},
+	}
+}
+
+func (repo *MemoryRepository) GetUser(ctx context.Context, userId string) (*User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	u, ok := repo.users[userId]
+	if !ok {
+		return nil, utils.ErrNotFound
+	}
+
+	return &u, nil
+}
+
+func (repo *MemoryRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, u := range repo.users {
+		if u.NormalizedEmail == email || (u.NormalizedEmail == "" && strings.EqualFold(u.Email, email)) {
+			return &u, nil
+		}
+	}
+
+	return nil, utils.ErrNotFound
+}
+
+func (repo *MemoryRepository) GetUsersByCountry(ctx context.Context, cc string) ([]*User, error) {
+	return repo.filterUsers(func(u *User) bool { return u.CountryCode == cc }), nil
+}
+
+func (repo *MemoryRepository) GetAllUsers(ctx context.Context) ([]*User, error) {
+	return repo.filterUsers(func(*User) bool { return true }), nil
+}
+
+func (repo *MemoryRepository) PutUser(ctx context.Context, u *User, event *UserUpdate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if stored := repo.users[u.ID]; stored.Version != u.Version-1 {
+		return fmt.Errorf("user %s is at version %d err: %w", u.ID, stored.Version, utils.VersionConflict)
+	}
+
+	for _, existing := range repo.users {
+		if existing.ID == u.ID {
+			continue
+		}
+		sameEmail := u.Email != "" && strings.EqualFold(existing.Email, u.Email)
+		sameNormalizedEmail := u.NormalizedEmail != "" && existing.NormalizedEmail == u.NormalizedEmail
+		if sameEmail || sameNormalizedEmail {
+			return fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+		}
+	}
+
+	if err := repo.enqueue(event); err != nil {
+		return err
+	}
+	repo.users[u.ID] = *u
+
+	return nil
+}
+
+func (repo *MemoryRepository) RewriteUser(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, ok := repo.users[u.ID]
+	if !ok || stored.Version != u.Version {
+		return fmt.Errorf("user %s is not at version %d err: %w", u.ID, u.Version, utils.VersionConflict)
+	}
+
+	for _, existing := range repo.users {
+		if existing.ID != u.ID && u.NormalizedEmail != "" && existing.NormalizedEmail == u.NormalizedEmail {
+			return fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+		}
+	}
+	repo.users[u.ID] = *u
+
+	return nil
+}
+
+func (repo *MemoryRepository) DeleteUser(ctx context.Context, id string, event *UserUpdate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, ok := repo.users[id]
+	if !ok {
+		return fmt.Errorf("failed to remove user from repo %w", utils.ErrNotFound)
+	}
+	if event != nil && event.removedVersion != 0 && stored.Version != event.removedVersion {
+		return fmt.Errorf("user %s is at version %d err: %w", id, stored.Version, utils.VersionConflict)
+	}
+
+	if err := repo.enqueue(event); err != nil {
+		return err
+	}
+	delete(repo.users, id)
+
+	return nil
+}
+
+func (repo *MemoryRepository) enqueue(event *UserUpdate) error {
+	if event == nil || repo.Outbox == nil {
+		return nil
+	}
+
+	err := repo.Outbox.PutMessage(event.Message())
+	if err != nil {
+		return fmt.Errorf("failed to enqueue user event err:%w", err)
+	}
+
+	return nil
+}
+
+func (repo *MemoryRepository) SearchUsers(ctx context.Context, query *SearchQuery) (*SearchResult, error) {
+	cursor, err := query.DecodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	users := repo.filterUsers(func(u *User) bool {
+		return query.Matches(u)
+	})
+
+	// keyset ordering on the sort field with the ID as tie breaker, matching the mongo sort
+	less := func(a, b *User) bool {
+		av, bv := a.SortValue(query.SortBy), b.SortValue(query.SortBy)
+		if av != bv {
+			return av < bv
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if query.Descending {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+
+	if cursor != nil {
+		position := &User{ID: cursor.ID}
+		switch query.SortBy {
+		case SortByLastName:
+			position.LastName = cursor.Value
+		case SortByEmail:
+			position.Email = cursor.Value
+		default:
+			position.Saved = cursor.Value
+		}
+
+		start := sort.Search(len(users), func(i int) bool {
+			if query.Descending {
+				return less(users[i], position)
+			}
+			return less(position, users[i])
+		})
+		users = users[start:]
+	}
+
+	result := &SearchResult{Users: users}
+	if len(users) > query.Limit {
+		result.Users = users[:query.Limit]
+		result.NextCursor = query.NextCursor(result.Users[query.Limit-1])
+	}
+
+	return result, nil
+}
+
+// filterUsers returns copies of the matching users ordered by ID
+func (repo *MemoryRepository) filterUsers(match func(*User) bool) []*User {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	users := []*User{}
+	for _, u := range repo.users {
+		u := u
+		if match(&u) {
+			users = append(users, &u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users
+}
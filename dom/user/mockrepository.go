@@ -1,6 +1,8 @@
 package user
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -10,7 +12,7 @@ type MockRepository struct {
 	BaseRepository
 }
 
-func (repo *MockRepository) GetUser(userId string) (user *User, err error) {
+func (repo *MockRepository) GetUser(ctx context.Context, userId string) (user *User, err error) {
 	args := repo.Called(userId)
 
 	if args.Get(0) != nil {
@@ -20,7 +22,7 @@ func (repo *MockRepository) GetUser(userId string) (user *User, err error) {
 	return user, args.Error(1)
 }
 
-func (repo *MockRepository) GetUserByEmail(email string) (user *User, err error) {
+func (repo *MockRepository) GetUserByEmail(ctx context.Context, email string) (user *User, err error) {
 	args := repo.Called(email)
 
 	if args.Get(0) != nil {
@@ -30,12 +32,17 @@ func (repo *MockRepository) GetUserByEmail(email string) (user *User, err error)
 	return user, args.Error(1)
 }
 
-func (repo *MockRepository) PutUser(user *User) error {
+func (repo *MockRepository) PutUser(ctx context.Context, user *User, event *UserUpdate) error {
+	args := repo.Called(user, event)
+	return args.Error(0)
+}
+
+func (repo *MockRepository) RewriteUser(ctx context.Context, user *User) error {
 	args := repo.Called(user)
 	return args.Error(0)
 }
 
-func (repo *MockRepository) GetUsersByCountry(cc string) (users []*User, err error) {
+func (repo *MockRepository) GetUsersByCountry(ctx context.Context, cc string) (users []*User, err error) {
 	args := repo.Called(cc)
 
 	if args.Get(0) != nil {
@@ -45,12 +52,12 @@ func (repo *MockRepository) GetUsersByCountry(cc string) (users []*User, err err
 	return users, args.Error(1)
 }
 
-func (repo *MockRepository) DeleteUser(id string) error {
-	args := repo.Called(id)
+func (repo *MockRepository) DeleteUser(ctx context.Context, id string, event *UserUpdate) error {
+	args := repo.Called(id, event)
 	return args.Error(0)
 }
 
-func (repo *MockRepository) GetAllUsers() (users []*User, err error) {
+func (repo *MockRepository) GetAllUsers(ctx context.Context) (users []*User, err error) {
 	args := repo.Called()
 
 	if args.Get(0) != nil {
@@ -59,3 +66,13 @@ func (repo *MockRepository) GetAllUsers() (users []*User, err error) {
 
 	return users, args.Error(1)
 }
+
+func (repo *MockRepository) SearchUsers(ctx context.Context, query *SearchQuery) (result *SearchResult, err error) {
+	args := repo.Called(query)
+
+	if args.Get(0) != nil {
+		result = args.Get(0).(*SearchResult)
+	}
+
+	return result, args.Error(1)
+}
@@ -2,25 +2,36 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/jackmcguire1/UserService/pkg/utils"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// emailCollation compares emails case-insensitively, the unique email index and email lookups
+// must use the same collation for the index to be used
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
 type MongoRepository struct {
 	BaseRepository
 
 	Collection *mongo.Collection
+	// OutboxCollection receives user events in the same transaction as the user write,
+	// transactions require mongo to run as a replica set
+	OutboxCollection *mongo.Collection
 }
 
 type MongoRepoParams struct {
-	Host           string
-	Database       string
-	CollectionName string
+	Host                 string
+	Database             string
+	CollectionName       string
+	OutboxCollectionName string
 }
 
 func NewMongoRepo(ctx context.Context, params *MongoRepoParams) (*MongoRepository, error) {
@@ -32,20 +43,58 @@ func NewMongoRepo(ctx context.Context, params *MongoRepoParams) (*MongoRepositor
 
 	collection := database.Collection(params.CollectionName)
 
-	return &MongoRepository{Collection: collection}, nil
+	// enforces email uniqueness atomically, a read before the write races under concurrent sign ups
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().
+			SetName("email_unique").
+			SetUnique(true).
+			SetCollation(emailCollation),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create unique user email index, remove duplicate emails first err:%w", err)
+	}
+
+	// users which have not been backfilled yet have no normalized email and are excluded from the index
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "normalizedEmail", Value: 1}},
+		Options: options.Index().
+			SetName("normalized_email_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"normalizedEmail": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create unique user normalized email index err:%w", err)
+	}
+
+	return &MongoRepository{
+		Collection:       collection,
+		OutboxCollection: database.Collection(params.OutboxCollectionName),
+	}, nil
+}
+
+func (repo *MongoRepository) GetUser(ctx context.Context, userId string) (*User, error) {
+	return repo.GetUserByAttr(ctx, "_id", userId)
 }
 
-func (repo *MongoRepository) GetUser(userId string) (*User, error) {
-	return repo.GetUserByAttr("_id", userId)
+// GetUserByEmail matches the normalized email, users which have not been backfilled yet
+// are matched case-insensitively on their email
+func (repo *MongoRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	u, err := repo.GetUserByAttr(ctx, "normalizedEmail", email)
+	if !errors.Is(err, utils.ErrNotFound) {
+		return u, err
+	}
+
+	filter := bson.M{"email": email, "normalizedEmail": bson.M{"$exists": false}}
+	return repo.getUser(ctx, filter, options.FindOne().SetCollation(emailCollation))
 }
 
-func (repo *MongoRepository) GetUserByEmail(email string) (*User, error) {
-	return repo.GetUserByAttr("email", email)
+func (repo *MongoRepository) GetUserByAttr(ctx context.Context, attr, value string) (*User, error) {
+	return repo.getUser(ctx, bson.M{attr: value})
 }
 
-func (repo *MongoRepository) GetUserByAttr(attr, value string) (*User, error) {
-	filter := bson.M{attr: value}
-	res := repo.Collection.FindOne(context.Background(), filter, nil)
+func (repo *MongoRepository) getUser(ctx context.Context, filter bson.M, opts ...*options.FindOneOptions) (*User, error) {
+	res := repo.Collection.FindOne(ctx, filter, opts...)
 	if res.Err() != nil {
 		if strings.Contains(res.Err().Error(), "no documents in result") {
 			return nil, utils.ErrNotFound
@@ -63,68 +112,235 @@ func (repo *MongoRepository) GetUserByAttr(attr, value string) (*User, error) {
 	return user, nil
 }
 
-func (repo *MongoRepository) GetUsersByCountry(cc string) ([]*User, error) {
+func (repo *MongoRepository) GetUsersByCountry(ctx context.Context, cc string) ([]*User, error) {
 	filter := bson.M{"countryCode": cc}
-	return repo.searchUsers(filter)
+	return repo.searchUsers(ctx, filter)
 }
 
-func (repo *MongoRepository) PutUser(u *User) error {
-
-	filter := bson.M{"_id": u.ID}
-
+func (repo *MongoRepository) PutUser(ctx context.Context, u *User, event *UserUpdate) error {
 	data, err := bson.Marshal(u)
 	if err != nil {
 		return err
 	}
-	opts := options.Replace().SetUpsert(true) // Set upsert options if needed
 
-	//repo.Collection.ReplaceOne(context.Background(), filter, data)
-	_, err = repo.Collection.ReplaceOne(context.Background(), filter, data, opts)
+	return repo.withEvent(ctx, event, func(ctx mongo.SessionContext) error {
+		res, err := repo.Collection.ReplaceOne(ctx, versionFilter(u.ID, u.Version-1), data)
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+		}
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 1 {
+			return nil
+		}
+
+		// only the first version of a user is inserted, a missing document for a later version was deleted concurrently
+		if u.Version == 1 {
+			_, err = repo.Collection.InsertOne(ctx, data)
+			if err == nil {
+				return nil
+			}
+			if !isDuplicateID(err) {
+				if mongo.IsDuplicateKeyError(err) {
+					return fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+				}
+				return err
+			}
+		}
+
+		return fmt.Errorf("user %s is not at version %d err: %w", u.ID, u.Version-1, utils.VersionConflict)
+	})
+}
+
+func (repo *MongoRepository) RewriteUser(ctx context.Context, u *User) error {
+	res, err := repo.Collection.ReplaceOne(ctx, versionFilter(u.ID, u.Version), u)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+	}
 	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("user %s is not at version %d err: %w", u.ID, u.Version, utils.VersionConflict)
+	}
 
 	return nil
 }
 
-func (repo *MongoRepository) DeleteUser(id string) error {
+func (repo *MongoRepository) DeleteUser(ctx context.Context, id string, event *UserUpdate) error {
 	filter := bson.M{"_id": id}
-	res, err := repo.Collection.DeleteOne(context.Background(), filter)
-	if err != nil {
-		return err
+	if event != nil && event.removedVersion != 0 {
+		filter = versionFilter(id, event.removedVersion)
 	}
 
-	if res.DeletedCount != 1 {
-		err = fmt.Errorf("failed to remove user from repo count:%d %w", res.DeletedCount, utils.ErrNotFound)
+	return repo.withEvent(ctx, event, func(ctx mongo.SessionContext) error {
+		res, err := repo.Collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 1 {
+			return nil
+		}
+
+		count, err := repo.Collection.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("user %s was modified concurrently err: %w", id, utils.VersionConflict)
+		}
+
+		return fmt.Errorf("failed to remove user from repo count:%d %w", res.DeletedCount, utils.ErrNotFound)
+	})
+}
+
+// versionFilter matches the user while at the given version, users written before versions existed lack the field
+func versionFilter(id string, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "version": bson.M{"$in": bson.A{0, nil}}}
+	}
+
+	return bson.M{"_id": id, "version": version}
+}
+
+// isDuplicateID reports whether the write failed because a document with the same _id exists
+func isDuplicateID(err error) bool {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == 11000 && strings.Contains(e.Message, "index: _id_ ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (repo *MongoRepository) withEvent(ctx context.Context, event *UserUpdate, write func(mongo.SessionContext) error) error {
+	sess, err := repo.Collection.Database().Client().StartSession()
+	if err != nil {
 		return err
 	}
+	defer sess.EndSession(context.Background())
 
-	return nil
+	_, err = sess.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		err := write(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if event != nil {
+			_, err = repo.OutboxCollection.InsertOne(ctx, event.Message())
+			if err != nil {
+				return nil, fmt.Errorf("failed to enqueue user event err:%w", err)
+			}
+		}
+
+		return nil, nil
+	})
+
+	return err
 }
 
-func (repo *MongoRepository) GetAllUsers() ([]*User, error) {
-	return repo.searchUsers(bson.M{})
+func (repo *MongoRepository) GetAllUsers(ctx context.Context) ([]*User, error) {
+	return repo.searchUsers(ctx, bson.M{})
 }
 
-func (repo *MongoRepository) searchUsers(filter bson.M) ([]*User, error) {
-	cursor, err := repo.Collection.Find(context.Background(), filter)
+func (repo *MongoRepository) SearchUsers(ctx context.Context, query *SearchQuery) (*SearchResult, error) {
+	filter, err := searchFilter(query)
 	if err != nil {
 		return nil, err
 	}
-	if cursor.Err() != nil {
+
+	order := 1
+	if query.Descending {
+		order = -1
+	}
+
+	// fetch one extra user to find out whether there is another page
+	opts := options.Find().
+		SetSort(bson.D{{Key: string(query.SortBy), Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int64(query.Limit + 1))
+
+	users, err := repo.searchUsers(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Users: users}
+	if len(users) > query.Limit {
+		result.Users = users[:query.Limit]
+		result.NextCursor = query.NextCursor(result.Users[query.Limit-1])
+	}
+
+	return result, nil
+}
+
+func searchFilter(query *SearchQuery) (bson.M, error) {
+	and := bson.A{}
+
+	if query.DeletedBefore != "" {
+		and = append(and, bson.M{"deletedAt": bson.M{"$lt": query.DeletedBefore}})
+	} else if !query.IncludeDeleted {
+		and = append(and, bson.M{"deletedAt": bson.M{"$exists": false}})
+	}
+	if query.CountryCode != "" {
+		and = append(and, bson.M{"countryCode": query.CountryCode})
+	}
+	if query.IsAdmin != nil {
+		and = append(and, bson.M{"isAdmin": *query.IsAdmin})
+	}
+	if query.EmailDomain != "" {
+		pattern := "@" + regexp.QuoteMeta(query.EmailDomain) + "$"
+		and = append(and, bson.M{"email": primitive.Regex{Pattern: pattern, Options: "i"}})
+	}
+	if query.NamePrefix != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.NamePrefix), Options: "i"}
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"firstName": prefix},
+			bson.M{"lastName": prefix},
+		}})
+	}
+
+	cursor, err := query.DecodeCursor()
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		op := "$gt"
+		if query.Descending {
+			op = "$lt"
+		}
+
+		field := string(query.SortBy)
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{field: bson.M{op: cursor.Value}},
+			bson.M{field: cursor.Value, "_id": bson.M{op: cursor.ID}},
+		}})
+	}
+
+	if len(and) == 0 {
+		return bson.M{}, nil
+	}
+
+	return bson.M{"$and": and}, nil
+}
+
+func (repo *MongoRepository) searchUsers(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]*User, error) {
+	cursor, err := repo.Collection.Find(ctx, filter, opts...)
+	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(context.Background())
 
 	var users = []*User{}
-	for cursor.Next(context.Background()) {
-		var tmpUsers []*User
-		err = cursor.All(context.Background(), &users)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, tmpUsers...)
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, err
 	}
 
-	return users, err
+	return users, nil
 }
@@ -0,0 +1,253 @@
+package user
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	InvalidCredentialsErr = fmt.Errorf("invalid credentials")
+	UnsupportedHashErr    = fmt.Errorf("unsupported password hash format")
+)
+
+// PasswordHasher hashes and verifies passwords stored in User.Password
+type PasswordHasher interface {
+	// Hash returns the encoded (PHC formatted) hash of the password
+	Hash(password string) ([]byte, error)
+	// Verify reports whether the password matches the encoded hash in constant time
+	Verify(password string, encoded []byte) (bool, error)
+	// NeedsRehash reports whether the encoded hash should be replaced with a fresh Hash
+	NeedsRehash(encoded []byte) bool
+	// Supports reports whether the encoded hash was produced by this hasher
+	Supports(encoded []byte) bool
+}
+
+// NewDefaultPasswordHasher hashes new passwords with argon2id
+// while still verifying (and upgrading) bcrypt and legacy SHA-256 hashes
+func NewDefaultPasswordHasher() *UpgradingHasher {
+	return &UpgradingHasher{
+		Preferred: NewArgon2idHasher(DefaultArgon2idParams),
+		Legacy: []PasswordHasher{
+			NewBcryptHasher(bcrypt.DefaultCost),
+			&SHA256Hasher{},
+		},
+	}
+}
+
+type Argon2idParams struct {
+	Memory     uint32
+	Iterations uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:     64 * 1024,
+	Iterations: 1,
+	Threads:    4,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{Params: params}
+}
+
+func (h *Argon2idHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt err:%w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Threads, h.Params.KeyLength)
+
+	encoded := fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.Params.Memory,
+		h.Params.Iterations,
+		h.Params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+func (h *Argon2idHasher) Verify(password string, encoded []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Threads, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded []byte) bool {
+	params, salt, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params != h.Params || uint32(len(salt)) != h.Params.SaltLength
+}
+
+func (h *Argon2idHasher) Supports(encoded []byte) bool {
+	return bytes.HasPrefix(encoded, []byte(argon2idPrefix))
+}
+
+func decodeArgon2id(encoded []byte) (params Argon2idParams, salt, key []byte, err error) {
+	// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+	parts := strings.Split(string(encoded), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("%w - malformed argon2id hash", UnsupportedHashErr)
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("%w - malformed argon2id version err:%s", UnsupportedHashErr, err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w - argon2id version %d", UnsupportedHashErr, version)
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("%w - malformed argon2id params err:%s", UnsupportedHashErr, err)
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w - malformed argon2id salt err:%s", UnsupportedHashErr, err)
+	}
+	params.SaltLength = uint32(len(salt))
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w - malformed argon2id key err:%s", UnsupportedHashErr, err)
+	}
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+}
+
+func (h *BcryptHasher) Verify(password string, encoded []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(encoded, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w - err:%s", UnsupportedHashErr, err)
+	}
+
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded []byte) bool {
+	cost, err := bcrypt.Cost(encoded)
+	return err != nil || cost != h.Cost
+}
+
+func (h *BcryptHasher) Supports(encoded []byte) bool {
+	return bytes.HasPrefix(encoded, []byte("$2a$")) ||
+		bytes.HasPrefix(encoded, []byte("$2b$")) ||
+		bytes.HasPrefix(encoded, []byte("$2y$"))
+}
+
+// SHA256Hasher verifies the unsalted SHA-256 digests stored by earlier releases,
+// it should only ever be used as a legacy hasher so existing users are upgraded on sign in
+type SHA256Hasher struct{}
+
+func (h *SHA256Hasher) Hash(password string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(password))
+	return sum[:], nil
+}
+
+func (h *SHA256Hasher) Verify(password string, encoded []byte) (bool, error) {
+	sum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(sum[:], encoded) == 1, nil
+}
+
+func (h *SHA256Hasher) NeedsRehash([]byte) bool {
+	return true
+}
+
+func (h *SHA256Hasher) Supports(encoded []byte) bool {
+	return len(encoded) == sha256.Size
+}
+
+// UpgradingHasher hashes with the preferred hasher and verifies with whichever hasher supports the stored hash,
+// hashes produced by a legacy hasher always need a rehash
+type UpgradingHasher struct {
+	Preferred PasswordHasher
+	Legacy    []PasswordHasher
+}
+
+func (h *UpgradingHasher) Hash(password string) ([]byte, error) {
+	return h.Preferred.Hash(password)
+}
+
+func (h *UpgradingHasher) Verify(password string, encoded []byte) (bool, error) {
+	hasher := h.hasherFor(encoded)
+	if hasher == nil {
+		return false, UnsupportedHashErr
+	}
+
+	return hasher.Verify(password, encoded)
+}
+
+func (h *UpgradingHasher) NeedsRehash(encoded []byte) bool {
+	if h.Preferred.Supports(encoded) {
+		return h.Preferred.NeedsRehash(encoded)
+	}
+
+	return true
+}
+
+func (h *UpgradingHasher) Supports(encoded []byte) bool {
+	return h.hasherFor(encoded) != nil
+}
+
+func (h *UpgradingHasher) hasherFor(encoded []byte) PasswordHasher {
+	if h.Preferred.Supports(encoded) {
+		return h.Preferred
+	}
+
+	for _, hasher := range h.Legacy {
+		if hasher.Supports(encoded) {
+			return hasher
+		}
+	}
+
+	return nil
+}
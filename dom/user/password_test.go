@@ -0,0 +1,145 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	h := NewArgon2idHasher(DefaultArgon2idParams)
+
+	encoded, err := h.Hash("secret")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(encoded), "$argon2id$v=19$m=65536,t=1,p=4$"))
+	assert.True(t, h.Supports(encoded))
+	assert.False(t, h.NeedsRehash(encoded))
+
+	ok, err := h.Verify("secret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = h.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	other, err := h.Hash("secret")
+	assert.NoError(t, err)
+	assert.NotEqual(t, encoded, other, "hashes must be salted")
+
+	stronger := NewArgon2idHasher(Argon2idParams{Memory: 64 * 1024, Iterations: 2, Threads: 4, SaltLength: 16, KeyLength: 32})
+	assert.True(t, stronger.NeedsRehash(encoded))
+}
+
+func TestBcryptHasher(t *testing.T) {
+	h := NewBcryptHasher(bcrypt.MinCost)
+
+	encoded, err := h.Hash("secret")
+	assert.NoError(t, err)
+	assert.True(t, h.Supports(encoded))
+	assert.False(t, h.NeedsRehash(encoded))
+
+	ok, err := h.Verify("secret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = h.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestUpgradingHasher(t *testing.T) {
+	h := NewDefaultPasswordHasher()
+
+	legacy := sha256.Sum256([]byte("secret"))
+	ok, err := h.Verify("secret", legacy[:])
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, h.NeedsRehash(legacy[:]))
+
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("secret")
+	assert.NoError(t, err)
+	ok, err = h.Verify("secret", bcryptHash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, h.NeedsRehash(bcryptHash))
+
+	encoded, err := h.Hash("secret")
+	assert.NoError(t, err)
+	assert.False(t, h.NeedsRehash(encoded))
+
+	_, err = h.Verify("secret", []byte("plaintext"))
+	assert.ErrorIs(t, err, UnsupportedHashErr)
+}
+
+func TestAuthenticateUpgradesLegacyHash(t *testing.T) {
+	legacy := sha256.Sum256([]byte("secret"))
+	usr := &User{ID: "1234", Email: "test@example.com", Password: legacy[:], Version: 1}
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetUserByEmail", "test@example.com").Return(usr, nil)
+	mockRepo.On("PutUser", mock.MatchedBy(func(u *User) bool {
+		return strings.HasPrefix(string(u.Password), "$argon2id$")
+	}), mock.MatchedBy(func(event *UserUpdate) bool {
+		return event.Status == EventUpdated && event.PasswordChanged && event.Version == 2 && event.ActorID == "1234"
+	})).Return(nil)
+
+	svc, err := NewService(&Resources{Repo: mockRepo})
+	assert.NoError(t, err)
+
+	resp, err := svc.Authenticate(context.Background(), "test@example.com", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", resp.ID)
+	assert.EqualValues(t, 2, resp.Version)
+	mockRepo.AssertCalled(t, "PutUser", mock.Anything, mock.Anything)
+
+	ok, err := svc.Hasher.Verify("secret", resp.Password)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestAuthenticateInvalidPassword(t *testing.T) {
+	svc, err := NewService(&Resources{Repo: &MockRepository{}})
+	assert.NoError(t, err)
+
+	encoded, err := svc.HashPassword("secret")
+	assert.NoError(t, err)
+
+	mockRepo := svc.Repo.(*MockRepository)
+	mockRepo.On("GetUserByEmail", "test@example.com").Return(&User{ID: "1234", Password: encoded}, nil)
+
+	_, err = svc.Authenticate(context.Background(), "test@example.com", "wrong")
+	assert.ErrorIs(t, err, InvalidCredentialsErr)
+	mockRepo.AssertNotCalled(t, "PutUser", mock.Anything, mock.Anything)
+}
+
+type countingHasher struct {
+	PasswordHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(password string, encoded []byte) (bool, error) {
+	h.verified++
+	return h.PasswordHasher.Verify(password, encoded)
+}
+
+func TestAuthenticateUnknownEmail(t *testing.T) {
+	hasher := &countingHasher{PasswordHasher: NewDefaultPasswordHasher()}
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetUserByEmail", "unknown@example.com").Return((*User)(nil), utils.ErrNotFound)
+
+	svc, err := NewService(&Resources{Repo: mockRepo, Hasher: hasher})
+	assert.NoError(t, err)
+
+	_, err = svc.Authenticate(context.Background(), "unknown@example.com", "secret")
+	assert.ErrorIs(t, err, InvalidCredentialsErr)
+	assert.NotErrorIs(t, err, utils.ErrNotFound)
+	assert.Equal(t, 1, hasher.verified, "unknown emails verify against a dummy hash")
+}
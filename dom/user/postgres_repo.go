@@ -0,0 +1,43 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackmcguire1/UserService/pkg/sqlerr"
+)
+
+// PostgresRepository stores users in the 'users' table and their events in the 'user_events' outbox table,
+// the schema is created by the migrations in dom/migrations
+type PostgresRepository struct {
+	sqlRepository
+}
+
+type PostgresRepoParams struct {
+	DSN string
+}
+
+func NewPostgresRepo(ctx context.Context, params *PostgresRepoParams) (*PostgresRepository, error) {
+	db, err := sql.Open("pgx", params.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPostgresRepoFromDB(db), nil
+}
+
+// NewPostgresRepoFromDB shares an open connection pool, e.g. with the session and outbox repositories
+func NewPostgresRepoFromDB(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{
+		sqlRepository: sqlRepository{
+			DB:                db,
+			isUniqueViolation: sqlerr.IsPostgresUniqueViolation,
+		},
+	}
+}
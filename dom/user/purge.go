@@ -0,0 +1,120 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// PurgeActorID is the actor of the events published when the Purger removes users
+const PurgeActorID = "retention-purger"
+
+// DefaultRetention is how long soft deleted users can be restored before they are purged
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Purger periodically hard deletes users which were soft deleted longer than the retention window ago,
+// a PURGED event is published for every removed user
+type Purger struct {
+	Repo   Repository
+	Logger *slog.Logger
+	// Audit records every purged user, optional
+	Audit audit.AuditService
+
+	Retention    time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func NewPurger(repo Repository, retention time.Duration, logger *slog.Logger) *Purger {
+	if retention == 0 {
+		retention = DefaultRetention
+	}
+
+	return &Purger{
+		Repo:         repo,
+		Logger:       logger,
+		Retention:    retention,
+		PollInterval: time.Hour,
+		BatchSize:    100,
+	}
+}
+
+// Run purges users until the context is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// keep purging while full batches are being removed
+		for {
+			n, err := p.PurgeOnce(ctx)
+			if err != nil {
+				p.Logger.
+					With("error", err).
+					Error("failed to purge deleted users")
+			}
+			if err != nil || n < p.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce removes a single batch of expired users, returning the number of purged users.
+// Users restored since they were read are skipped
+func (p *Purger) PurgeOnce(ctx context.Context) (int, error) {
+	cutoff := time.Now().UTC().Add(-p.Retention).Format(time.RFC3339)
+
+	result, err := p.Repo.SearchUsers(ctx, &SearchQuery{
+		Limit:         p.BatchSize,
+		SortBy:        SortBySaved,
+		DeletedBefore: cutoff,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, u := range result.Users {
+		logEntry := p.Logger.
+			With("user-id", u.ID).
+			With("deleted-at", u.DeletedAt)
+
+		event := NewUserPurge(u, PurgeActorID)
+
+		err = p.Repo.DeleteUser(ctx, u.ID, event)
+		if errors.Is(err, utils.VersionConflict) || errors.Is(err, utils.ErrNotFound) {
+			logEntry.
+				With("error", err).
+				Warn("user changed while purging, skipping")
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+
+		if p.Audit != nil {
+			_ = p.Audit.Record(ctx, &audit.Entry{
+				ActorID:      PurgeActorID,
+				Action:       audit.ActionUserPurged,
+				TargetUserID: u.ID,
+				BeforeHash:   auditHash(u),
+			})
+		}
+
+		logEntry.Info("purged deleted user")
+		purged++
+	}
+
+	return purged, nil
+}
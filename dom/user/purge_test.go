@@ -0,0 +1,146 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	ctx := utils.WithCallerID(context.Background(), "admin")
+	events := outbox.NewMemoryRepo()
+	svc, err := NewService(&Resources{Repo: NewMemoryRepo(events)})
+	assert.NoError(t, err)
+
+	u, err := svc.PutUser(ctx, &User{FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, svc.DeleteUser(ctx, u.ID))
+	assert.ErrorIs(t, svc.DeleteUser(ctx, u.ID), utils.ErrNotFound, "deleting twice")
+
+	_, err = svc.GetUser(ctx, u.ID)
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+	_, err = svc.GetUserByEmail(ctx, "john@example.com")
+	assert.ErrorIs(t, err, utils.ErrNotFound, "deleted users cannot sign in")
+	_, err = svc.PutUser(ctx, &User{ID: u.ID, FirstName: "Jane", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.ErrorIs(t, err, utils.ErrNotFound, "deleted users cannot be updated")
+
+	users, err := svc.GetAllUsers(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	result, err := svc.SearchUsers(ctx, &SearchQuery{IncludeDeleted: true})
+	assert.NoError(t, err)
+	assert.Len(t, result.Users, 1)
+	assert.Equal(t, "admin", result.Users[0].DeletedBy)
+	assert.NotEmpty(t, result.Users[0].DeletedAt)
+
+	restored, err := svc.RestoreUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.False(t, restored.Deleted())
+	assert.Equal(t, int64(3), restored.Version)
+
+	got, err := svc.GetUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, restored, got)
+
+	again, err := svc.RestoreUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, restored, again, "restoring a user which is not deleted is a no-op")
+
+	msgs, err := events.ClaimMessages(10, time.Minute)
+	assert.NoError(t, err)
+	types := []string{}
+	for _, msg := range msgs {
+		types = append(types, msg.Type)
+	}
+	assert.Equal(t, []string{EventCreated, EventDeleted, EventRestored}, types)
+}
+
+func TestPutUserIgnoresSoftDelete(t *testing.T) {
+	ctx := utils.WithCallerID(context.Background(), "1234")
+	events := outbox.NewMemoryRepo()
+	svc, err := NewService(&Resources{Repo: NewMemoryRepo(events)})
+	assert.NoError(t, err)
+
+	deleted := "2020-01-01T00:00:00Z"
+	u, err := svc.PutUser(ctx, &User{ID: "1234", FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com", DeletedAt: deleted, DeletedBy: "someone-else"})
+	assert.NoError(t, err)
+	assert.False(t, u.Deleted(), "users cannot be created deleted")
+
+	_, err = svc.PutUser(ctx, &User{ID: "1234", FirstName: "Jane", LastName: "Doe", CountryCode: "GB", Email: "john@example.com", DeletedAt: deleted, DeletedBy: "someone-else"})
+	assert.NoError(t, err)
+
+	got, err := svc.GetUser(ctx, "1234")
+	assert.NoError(t, err, "updates cannot soft delete the user")
+	assert.Empty(t, got.DeletedAt)
+	assert.Empty(t, got.DeletedBy)
+
+	result, err := svc.SearchUsers(ctx, &SearchQuery{DeletedBefore: time.Now().UTC().Format(time.RFC3339)})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Users, "the purger finds nothing to purge")
+}
+
+func TestPurger(t *testing.T) {
+	ctx := context.Background()
+	events := outbox.NewMemoryRepo()
+	repo := NewMemoryRepo(events)
+
+	now := time.Now().UTC()
+	for _, u := range []*User{
+		{ID: "active", Email: "active@example.com", Version: 1},
+		{ID: "expired", Email: "expired@example.com", Version: 1, DeletedAt: now.Add(-48 * time.Hour).Format(time.RFC3339)},
+		{ID: "recent", Email: "recent@example.com", Version: 1, DeletedAt: now.Add(-time.Hour).Format(time.RFC3339)},
+	} {
+		assert.NoError(t, repo.PutUser(ctx, u, nil))
+	}
+
+	auditRepo := audit.NewMemoryRepo()
+	purger := NewPurger(repo, 24*time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	purger.Audit, _ = audit.NewService(&audit.Resources{Repo: auditRepo})
+	n, err := purger.PurgeOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = repo.GetUser(ctx, "expired")
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+	for _, id := range []string{"active", "recent"} {
+		_, err = repo.GetUser(ctx, id)
+		assert.NoError(t, err, id)
+	}
+
+	msgs, err := events.ClaimMessages(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, EventPurged, msgs[0].Type)
+
+	var event UserUpdate
+	assert.NoError(t, json.Unmarshal([]byte(msgs[0].Payload), &event))
+	assert.Equal(t, "expired", event.UserID)
+	assert.Equal(t, PurgeActorID, event.ActorID)
+	assert.Equal(t, int64(2), event.Version)
+	assert.Nil(t, event.User)
+	assert.Nil(t, event.Previous, "the event carries no personal data")
+	assert.NotContains(t, msgs[0].Payload, "expired@example.com")
+
+	entries, err := auditRepo.GetEntries(ctx, &audit.Query{Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, audit.ActionUserPurged, entries[0].Action)
+	assert.Equal(t, PurgeActorID, entries[0].ActorID)
+	assert.Equal(t, "expired", entries[0].TargetUserID)
+	assert.NotEmpty(t, entries[0].BeforeHash)
+	assert.Empty(t, entries[0].AfterHash)
+
+	n, err = purger.PurgeOnce(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -1,40 +1,65 @@
 package user
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 var NotImplementedErr = fmt.Errorf("this method is not implemented")
 
 type Repository interface {
-	GetUser(string) (*User, error)
-	GetUserByEmail(string) (*User, error)
-	GetUsersByCountry(cc string) (users []*User, err error)
-	DeleteUser(string) error
-	PutUser(*User) error
-	GetAllUsers() (users []*User, err error)
+	GetUser(context.Context, string) (*User, error)
+	// GetUserByEmail looks up the user by their normalized email, see EmailNormalizer
+	GetUserByEmail(context.Context, string) (*User, error)
+	GetUsersByCountry(ctx context.Context, cc string) (users []*User, err error)
+	// DeleteUser permanently removes the user and enqueues the event, when given, in the same transaction,
+	// the service soft deletes users with PutUser and only the Purger removes them.
+	// With an event of NewUserUpdate or NewUserPurge the user is only removed while still at the version it was
+	// read at, otherwise utils.VersionConflict is returned
+	DeleteUser(context.Context, string, *UserUpdate) error
+	// PutUser upserts the user and enqueues the event, when given, in the same transaction.
+	// The write is conditional on the stored user being at u.Version-1, absent users count as version 0,
+	// otherwise utils.VersionConflict is returned
+	PutUser(context.Context, *User, *UserUpdate) error
+	// RewriteUser overwrites the stored user without a new version or event, for maintenance writes which change
+	// nothing published about the user such as backfills and re-encryption. The write is conditional on the
+	// stored user still being at u.Version, otherwise utils.VersionConflict is returned
+	RewriteUser(context.Context, *User) error
+	GetAllUsers(ctx context.Context) (users []*User, err error)
+	// SearchUsers excludes soft deleted users unless the query includes them
+	SearchUsers(context.Context, *SearchQuery) (*SearchResult, error)
 }
 
 type BaseRepository struct{}
 
-func (repo *BaseRepository) GetUser(string) (*User, error) {
+func (repo *BaseRepository) GetUser(context.Context, string) (*User, error) {
 	return nil, NotImplementedErr
 }
 
-func (repo *BaseRepository) GetUserByEmail(string) (*User, error) {
+func (repo *BaseRepository) GetUserByEmail(context.Context, string) (*User, error) {
 	return nil, NotImplementedErr
 }
 
-func (repo *BaseRepository) PutUser(*User) error {
+func (repo *BaseRepository) PutUser(context.Context, *User, *UserUpdate) error {
 	return NotImplementedErr
 }
 
-func (repo *BaseRepository) DeleteUser(string) error {
+func (repo *BaseRepository) RewriteUser(context.Context, *User) error {
 	return NotImplementedErr
 }
 
-func (repo *BaseRepository) GetUsersByCountry(cc string) (users []*User, err error) {
+func (repo *BaseRepository) DeleteUser(context.Context, string, *UserUpdate) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) GetUsersByCountry(ctx context.Context, cc string) (users []*User, err error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) GetAllUsers(ctx context.Context) (users []*User, err error) {
 	return nil, NotImplementedErr
 }
 
-func (repo *BaseRepository) GetAllUsers() (users []*User, err error) {
+func (repo *BaseRepository) SearchUsers(context.Context, *SearchQuery) (*SearchResult, error) {
 	return nil, NotImplementedErr
 }
@@ -0,0 +1,568 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackmcguire1/UserService/dom/migrations"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// repositoryFixture is a freshly created, empty repository under test
+type repositoryFixture struct {
+	Repo Repository
+	// Events returns the messages the repository enqueued in its outbox
+	Events func() []*outbox.Message
+}
+
+// testRepositoryConformance is the behaviour every Repository implementation must share
+func testRepositoryConformance(t *testing.T, newFixture func(t *testing.T) *repositoryFixture) {
+	ctx := context.Background()
+
+	newUser := func(id, email, cc string) *User {
+		return &User{
+			ID:          id,
+			FirstName:   "First" + id,
+			LastName:    "Last" + id,
+			Email:       email,
+			CountryCode: cc,
+			Saved:       "2024-01-01T00:00:0" + id + "Z",
+			Password:    []byte("hash-" + id),
+			Version:     1,
+
+			NormalizedEmail: strings.ToLower(email),
+		}
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "one@example.com", "GB")
+
+		assert.NoError(t, f.Repo.PutUser(ctx, u, NewUserUpdate(nil, u, u.ID)))
+
+		got, err := f.Repo.GetUser(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, u, got)
+
+		got, err = f.Repo.GetUserByEmail(ctx, "one@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", got.ID)
+
+		events := f.Events()
+		assert.Len(t, events, 1)
+		assert.Equal(t, EventCreated, events[0].Type)
+		assert.Equal(t, "1", events[0].Subject)
+	})
+
+	t.Run("missing users are not found", func(t *testing.T) {
+		f := newFixture(t)
+
+		_, err := f.Repo.GetUser(ctx, "missing")
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+
+		_, err = f.Repo.GetUserByEmail(ctx, "missing@example.com")
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+
+		err = f.Repo.DeleteUser(ctx, "missing", nil)
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+	})
+
+	t.Run("put replaces existing user", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "one@example.com", "GB")
+		assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+
+		updated := *u
+		updated.FirstName = "Updated"
+		updated.Email = "updated@example.com"
+		updated.NormalizedEmail = "updated@example.com"
+		updated.Version = 2
+		assert.NoError(t, f.Repo.PutUser(ctx, &updated, nil))
+
+		got, err := f.Repo.GetUser(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "Updated", got.FirstName)
+		assert.Equal(t, int64(2), got.Version)
+
+		_, err = f.Repo.GetUserByEmail(ctx, "one@example.com")
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+		assert.Empty(t, f.Events(), "writes without an event do not enqueue one")
+	})
+
+	t.Run("email is unique", func(t *testing.T) {
+		f := newFixture(t)
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("1", "one@example.com", "GB"), nil))
+
+		err := f.Repo.PutUser(ctx, newUser("2", "one@example.com", "GB"), nil)
+		assert.ErrorIs(t, err, utils.AlreadyExists)
+
+		_, err = f.Repo.GetUser(ctx, "2")
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+	})
+
+	t.Run("email is unique regardless of case", func(t *testing.T) {
+		f := newFixture(t)
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("1", "one@example.com", "GB"), nil))
+
+		err := f.Repo.PutUser(ctx, newUser("2", "One@Example.com", "GB"), nil)
+		assert.ErrorIs(t, err, utils.AlreadyExists)
+
+		got, err := f.Repo.GetUserByEmail(ctx, "one@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", got.ID)
+	})
+
+	t.Run("normalized email is unique", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "j.doe@gmail.com", "GB")
+		u.NormalizedEmail = "jdoe@gmail.com"
+		assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+
+		other := newUser("2", "jdoe+news@gmail.com", "GB")
+		other.NormalizedEmail = "jdoe@gmail.com"
+		err := f.Repo.PutUser(ctx, other, nil)
+		assert.ErrorIs(t, err, utils.AlreadyExists)
+
+		got, err := f.Repo.GetUserByEmail(ctx, "jdoe@gmail.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", got.ID)
+		assert.Equal(t, "j.doe@gmail.com", got.Email, "the email is kept as entered")
+	})
+
+	t.Run("users without a normalized email are found by email", func(t *testing.T) {
+		f := newFixture(t)
+		legacy := newUser("1", "Legacy@Example.com", "GB")
+		legacy.NormalizedEmail = ""
+		assert.NoError(t, f.Repo.PutUser(ctx, legacy, nil))
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("2", "two@example.com", "GB"), nil))
+
+		got, err := f.Repo.GetUserByEmail(ctx, "legacy@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", got.ID)
+		assert.Empty(t, got.NormalizedEmail)
+	})
+
+	t.Run("concurrent writes with the same email", func(t *testing.T) {
+		f := newFixture(t)
+
+		const writers = 20
+		errs := make(chan error, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				u := newUser(fmt.Sprint(i), "race@example.com", "GB")
+				errs <- f.Repo.PutUser(ctx, u, NewUserUpdate(nil, u, u.ID))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		won := 0
+		for err := range errs {
+			if err == nil {
+				won++
+				continue
+			}
+			assert.ErrorIs(t, err, utils.AlreadyExists)
+		}
+		assert.Equal(t, 1, won, "exactly one writer wins")
+
+		users, err := f.Repo.GetAllUsers(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, users, 1)
+		assert.Len(t, f.Events(), 1, "losing writers do not enqueue events")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "one@example.com", "GB")
+		assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+
+		assert.NoError(t, f.Repo.DeleteUser(ctx, "1", NewUserUpdate(u, nil, "admin")))
+
+		_, err := f.Repo.GetUser(ctx, "1")
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+
+		events := f.Events()
+		assert.Len(t, events, 1)
+		assert.Equal(t, EventDeleted, events[0].Type)
+
+		err = f.Repo.DeleteUser(ctx, "1", nil)
+		assert.ErrorIs(t, err, utils.ErrNotFound)
+	})
+
+	t.Run("stale writes conflict", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "one@example.com", "GB")
+		assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+
+		err := f.Repo.PutUser(ctx, newUser("1", "one@example.com", "GB"), nil)
+		assert.ErrorIs(t, err, utils.VersionConflict, "creating an existing user")
+
+		stale := *u
+		stale.FirstName = "Stale"
+		stale.Version = 3
+		err = f.Repo.PutUser(ctx, &stale, nil)
+		assert.ErrorIs(t, err, utils.VersionConflict, "skipping a version")
+
+		got, err := f.Repo.GetUser(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, u, got)
+
+		err = f.Repo.PutUser(ctx, &User{ID: "2", Email: "two@example.com", Version: 2}, nil)
+		assert.ErrorIs(t, err, utils.VersionConflict, "updating a missing user")
+	})
+
+	t.Run("rewrite keeps the version", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "one@example.com", "GB")
+		assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("2", "two@example.com", "GB"), nil))
+
+		rewritten := *u
+		rewritten.NormalizedEmail = "one+normalized@example.com"
+		assert.NoError(t, f.Repo.RewriteUser(ctx, &rewritten))
+
+		got, err := f.Repo.GetUser(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, &rewritten, got)
+		assert.Empty(t, f.Events(), "rewrites enqueue no events")
+
+		stale := rewritten
+		stale.Version = 2
+		assert.ErrorIs(t, f.Repo.RewriteUser(ctx, &stale), utils.VersionConflict, "rewriting another version")
+		assert.ErrorIs(t, f.Repo.RewriteUser(ctx, newUser("3", "three@example.com", "GB")), utils.VersionConflict, "rewriting a missing user")
+
+		taken := rewritten
+		taken.NormalizedEmail = "two@example.com"
+		assert.ErrorIs(t, f.Repo.RewriteUser(ctx, &taken), utils.AlreadyExists)
+	})
+
+	t.Run("stale deletes conflict", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "one@example.com", "GB")
+		assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+
+		updated := *u
+		updated.Version = 2
+		assert.NoError(t, f.Repo.PutUser(ctx, &updated, nil))
+
+		err := f.Repo.DeleteUser(ctx, "1", NewUserUpdate(u, nil, "admin"))
+		assert.ErrorIs(t, err, utils.VersionConflict)
+		err = f.Repo.DeleteUser(ctx, "1", NewUserPurge(u, PurgeActorID))
+		assert.ErrorIs(t, err, utils.VersionConflict, "purges carry no user but still check its version")
+		assert.Empty(t, f.Events(), "conflicting deletes do not enqueue events")
+
+		_, err = f.Repo.GetUser(ctx, "1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("concurrent updates of the same version", func(t *testing.T) {
+		f := newFixture(t)
+		u := newUser("1", "one@example.com", "GB")
+		assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+
+		const writers = 20
+		errs := make(chan error, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				updated := *u
+				updated.FirstName = fmt.Sprint("Writer", i)
+				updated.Version = 2
+				errs <- f.Repo.PutUser(ctx, &updated, NewUserUpdate(u, &updated, "admin"))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		won := 0
+		for err := range errs {
+			if err == nil {
+				won++
+				continue
+			}
+			assert.ErrorIs(t, err, utils.VersionConflict)
+		}
+		assert.Equal(t, 1, won, "exactly one writer wins")
+		assert.Len(t, f.Events(), 1, "losing writers do not enqueue events")
+
+		got, err := f.Repo.GetUser(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), got.Version)
+	})
+
+	t.Run("search excludes deleted users", func(t *testing.T) {
+		f := newFixture(t)
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("1", "one@example.com", "GB"), nil))
+
+		deleted := newUser("2", "two@example.com", "GB")
+		deleted.DeletedAt = "2024-01-05T00:00:00Z"
+		deleted.DeletedBy = "admin"
+		assert.NoError(t, f.Repo.PutUser(ctx, deleted, nil))
+
+		recent := newUser("3", "three@example.com", "GB")
+		recent.DeletedAt = "2024-02-01T00:00:00Z"
+		assert.NoError(t, f.Repo.PutUser(ctx, recent, nil))
+
+		got, err := f.Repo.GetUser(ctx, "2")
+		assert.NoError(t, err)
+		assert.Equal(t, deleted, got, "the repository returns deleted users by id")
+
+		ids := func(query *SearchQuery) []string {
+			assert.NoError(t, query.Normalize())
+			result, err := f.Repo.SearchUsers(ctx, query)
+			assert.NoError(t, err)
+
+			ids := []string{}
+			for _, u := range result.Users {
+				ids = append(ids, u.ID)
+			}
+			return ids
+		}
+		assert.Equal(t, []string{"1"}, ids(&SearchQuery{}))
+		assert.Equal(t, []string{"1", "2", "3"}, ids(&SearchQuery{IncludeDeleted: true}))
+		assert.Equal(t, []string{"2"}, ids(&SearchQuery{DeletedBefore: "2024-01-31T00:00:00Z"}))
+	})
+
+	t.Run("filter by country", func(t *testing.T) {
+		f := newFixture(t)
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("1", "one@example.com", "GB"), nil))
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("2", "two@example.com", "US"), nil))
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("3", "three@example.com", "GB"), nil))
+
+		users, err := f.Repo.GetUsersByCountry(ctx, "GB")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"1", "3"}, userIDs(users))
+
+		users, err = f.Repo.GetUsersByCountry(ctx, "FR")
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+
+		users, err = f.Repo.GetAllUsers(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, users, 3)
+	})
+
+	t.Run("search pages through every user", func(t *testing.T) {
+		f := newFixture(t)
+		for i := 1; i <= 5; i++ {
+			u := newUser(fmt.Sprint(i), fmt.Sprintf("user%d@example.com", i), "GB")
+			u.LastName = "Doe"
+			assert.NoError(t, f.Repo.PutUser(ctx, u, nil))
+		}
+
+		for _, descending := range []bool{false, true} {
+			query := &SearchQuery{Limit: 2, SortBy: SortByLastName, Descending: descending}
+			assert.NoError(t, query.Normalize())
+
+			ids := []string{}
+			for {
+				result, err := f.Repo.SearchUsers(ctx, query)
+				assert.NoError(t, err)
+				ids = append(ids, userIDs(result.Users)...)
+
+				if result.NextCursor == "" {
+					break
+				}
+				query.Cursor = result.NextCursor
+			}
+
+			// equal last names are ordered by ID
+			if descending {
+				assert.Equal(t, []string{"5", "4", "3", "2", "1"}, ids)
+			} else {
+				assert.Equal(t, []string{"1", "2", "3", "4", "5"}, ids)
+			}
+		}
+	})
+
+	t.Run("search filters", func(t *testing.T) {
+		f := newFixture(t)
+		admin := newUser("1", "admin@corp.com", "GB")
+		admin.IsAdmin = true
+		admin.FirstName = "Alice"
+		assert.NoError(t, f.Repo.PutUser(ctx, admin, nil))
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("2", "two@example.com", "US"), nil))
+		assert.NoError(t, f.Repo.PutUser(ctx, newUser("3", "three@Example.com", "GB"), nil))
+
+		isAdmin := true
+		tests := []struct {
+			query *SearchQuery
+			ids   []string
+		}{
+			{query: &SearchQuery{CountryCode: "GB"}, ids: []string{"1", "3"}},
+			{query: &SearchQuery{IsAdmin: &isAdmin}, ids: []string{"1"}},
+			{query: &SearchQuery{EmailDomain: "example.com"}, ids: []string{"2", "3"}},
+			{query: &SearchQuery{NamePrefix: "ali"}, ids: []string{"1"}},
+			{query: &SearchQuery{NamePrefix: "last"}, ids: []string{"1", "2", "3"}},
+		}
+
+		for _, tc := range tests {
+			assert.NoError(t, tc.query.Normalize())
+
+			result, err := f.Repo.SearchUsers(ctx, tc.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ids, userIDs(result.Users), utils.ToJSON(tc.query))
+			assert.Empty(t, result.NextCursor)
+		}
+	})
+}
+
+func TestMemoryRepository(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) *repositoryFixture {
+		events := outbox.NewMemoryRepo()
+
+		return &repositoryFixture{
+			Repo:   NewMemoryRepo(events),
+			Events: events.Messages,
+		}
+	})
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) *repositoryFixture {
+		ctx := context.Background()
+
+		repo, err := NewSQLiteRepo(ctx, &SQLiteRepoParams{Path: filepath.Join(t.TempDir(), "users.db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.DB.Close() })
+
+		var journalMode string
+		err = repo.DB.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode)
+		assert.NoError(t, err)
+		assert.Equal(t, "wal", journalMode)
+
+		runner, err := migrations.NewRunner(repo.DB, migrations.SQLITE, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = runner.Up(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events := outbox.NewSQLRepo(repo.DB, "")
+
+		return &repositoryFixture{
+			Repo: repo,
+			Events: func() []*outbox.Message {
+				msgs, err := events.ClaimMessages(100, 0)
+				assert.NoError(t, err)
+
+				return msgs
+			},
+		}
+	})
+}
+
+// TestMongoRepository runs against the replica set at MONGO_TEST_HOST, each case uses a throwaway database
+func TestMongoRepository(t *testing.T) {
+	host := os.Getenv("MONGO_TEST_HOST")
+	if host == "" {
+		t.Skip("MONGO_TEST_HOST is not set")
+	}
+
+	testRepositoryConformance(t, func(t *testing.T) *repositoryFixture {
+		repo, err := NewMongoRepo(context.Background(), &MongoRepoParams{
+			Host:                 host,
+			Database:             "users_test_" + uuid.NewString()[:8],
+			CollectionName:       "users",
+			OutboxCollectionName: "user_events",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			repo.Collection.Database().Drop(context.Background())
+		})
+
+		return &repositoryFixture{
+			Repo: repo,
+			Events: func() []*outbox.Message {
+				cursor, err := repo.OutboxCollection.Find(context.Background(), bson.M{})
+				assert.NoError(t, err)
+
+				msgs := []*outbox.Message{}
+				assert.NoError(t, cursor.All(context.Background(), &msgs))
+
+				return msgs
+			},
+		}
+	})
+}
+
+// TestPostgresRepository runs against the database at POSTGRES_TEST_DSN, each case migrates a throwaway schema
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	testRepositoryConformance(t, func(t *testing.T) *repositoryFixture {
+		ctx := context.Background()
+		schema := "users_test_" + uuid.NewString()[:8]
+
+		admin, err := NewPostgresRepo(ctx, &PostgresRepoParams{DSN: dsn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = admin.DB.ExecContext(ctx, "CREATE SCHEMA "+schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.RuntimeParams["search_path"] = schema
+		db := stdlib.OpenDB(*config)
+
+		t.Cleanup(func() {
+			db.Close()
+			admin.DB.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+			admin.DB.Close()
+		})
+
+		runner, err := migrations.NewRunner(db, migrations.POSTGRES, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = runner.Up(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events := outbox.NewSQLRepo(db, outbox.POSTGRES_CLAIM_LOCK)
+
+		return &repositoryFixture{
+			Repo: NewPostgresRepoFromDB(db),
+			Events: func() []*outbox.Message {
+				msgs, err := events.ClaimMessages(100, 0)
+				assert.NoError(t, err)
+
+				return msgs
+			},
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jackmcguire1/UserService/pkg/iso3166"
+	"github.com/jackmcguire1/UserService/pkg/redact"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+)
+
+type SortField string
+
+const (
+	SortBySaved    SortField = "saved"
+	SortByLastName SortField = "lastName"
+	SortByEmail    SortField = "email"
+)
+
+// SearchQuery filters, sorts and paginates users,
+// empty filter fields are ignored
+type SearchQuery struct {
+	Limit      int
+	Cursor     string
+	SortBy     SortField
+	Descending bool
+
+	CountryCode string
+	IsAdmin     *bool
+	EmailDomain string
+	NamePrefix  string
+
+	// IncludeDeleted also returns soft deleted users, DeletedBefore only returns users
+	// soft deleted before the given UTC RFC 3339 time
+	IncludeDeleted bool
+	DeletedBefore  string
+}
+
+// LogValue tags the name prefix, the cursor holds the sort value and ID of the last user of a page
+func (q *SearchQuery) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Int("limit", q.Limit),
+		slog.Any("cursor", redact.Tag(redact.ClassSecret, q.Cursor)),
+		slog.String("sortBy", string(q.SortBy)),
+		slog.Bool("descending", q.Descending),
+		slog.String("countryCode", q.CountryCode),
+		slog.String("emailDomain", q.EmailDomain),
+		slog.Any("namePrefix", redact.Tag(redact.ClassName, q.NamePrefix)),
+		slog.Bool("includeDeleted", q.IncludeDeleted),
+		slog.String("deletedBefore", q.DeletedBefore),
+	}
+	if q.IsAdmin != nil {
+		attrs = append(attrs, slog.Bool("isAdmin", *q.IsAdmin))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+type SearchResult struct {
+	Users      []*User `json:"users"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// SearchCursor is the position after the last user of a page,
+// it is handed to clients as an opaque base64 string
+type SearchCursor struct {
+	SortBy     SortField `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Value      string    `json:"v"`
+	ID         string    `json:"id"`
+}
+
+// Normalize applies defaults and validates the query
+func (q *SearchQuery) Normalize() error {
+	if q.Limit == 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxSearchLimit {
+		return fmt.Errorf("%w - limit must be between 1 and %d", utils.ValidationErr, MaxSearchLimit)
+	}
+
+	if q.SortBy == "" {
+		q.SortBy = SortBySaved
+	}
+	switch q.SortBy {
+	case SortBySaved, SortByLastName, SortByEmail:
+	default:
+		return fmt.Errorf("%w - cannot sort by %q", utils.ValidationErr, q.SortBy)
+	}
+
+	q.CountryCode = strings.ToUpper(q.CountryCode)
+	if q.CountryCode != "" && !iso3166.Valid(q.CountryCode) {
+		return fmt.Errorf("%w - country code must be ISO 3166-1 alpha-2", utils.ValidationErr)
+	}
+
+	q.EmailDomain = strings.ToLower(strings.TrimPrefix(q.EmailDomain, "@"))
+
+	if q.Cursor != "" {
+		if _, err := q.DecodeCursor(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeCursor returns the position encoded in the query cursor,
+// cursors are only valid for the sort order they were created with
+func (q *SearchQuery) DecodeCursor() (*SearchCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w - malformed cursor", utils.ValidationErr)
+	}
+
+	var cursor *SearchCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor == nil || cursor.ID == "" {
+		return nil, fmt.Errorf("%w - malformed cursor", utils.ValidationErr)
+	}
+
+	if cursor.SortBy != q.SortBy || cursor.Descending != q.Descending {
+		return nil, fmt.Errorf("%w - cursor does not match the requested sort order", utils.ValidationErr)
+	}
+
+	return cursor, nil
+}
+
+// NextCursor encodes the position after the given user for the query sort order
+func (q *SearchQuery) NextCursor(u *User) string {
+	cursor := &SearchCursor{
+		SortBy:     q.SortBy,
+		Descending: q.Descending,
+		Value:      u.SortValue(q.SortBy),
+		ID:         u.ID,
+	}
+
+	return base64.RawURLEncoding.EncodeToString(utils.ToRAWJSON(cursor))
+}
+
+// SortValue returns the value of the field users are sorted by
+func (u *User) SortValue(field SortField) string {
+	switch field {
+	case SortByLastName:
+		return u.LastName
+	case SortByEmail:
+		return u.Email
+	default:
+		return u.Saved
+	}
+}
+
+// Matches reports whether the user passes the query filters, the cursor is not considered
+func (q *SearchQuery) Matches(u *User) bool {
+	if q.DeletedBefore != "" {
+		if !u.Deleted() || u.DeletedAt >= q.DeletedBefore {
+			return false
+		}
+	} else if !q.IncludeDeleted && u.Deleted() {
+		return false
+	}
+	if q.CountryCode != "" && u.CountryCode != q.CountryCode {
+		return false
+	}
+	if q.IsAdmin != nil && u.IsAdmin != *q.IsAdmin {
+		return false
+	}
+	if q.EmailDomain != "" && !strings.HasSuffix(strings.ToLower(u.Email), "@"+strings.ToLower(q.EmailDomain)) {
+		return false
+	}
+	if q.NamePrefix != "" {
+		prefix := strings.ToLower(q.NamePrefix)
+		if !strings.HasPrefix(strings.ToLower(u.FirstName), prefix) && !strings.HasPrefix(strings.ToLower(u.LastName), prefix) {
+			return false
+		}
+	}
+
+	return true
+}
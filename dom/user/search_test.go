@@ -0,0 +1,80 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSearchQueryNormalize(t *testing.T) {
+	query := &SearchQuery{CountryCode: "gb", EmailDomain: "@Example.com"}
+	assert.NoError(t, query.Normalize())
+	assert.Equal(t, DefaultSearchLimit, query.Limit)
+	assert.Equal(t, SortBySaved, query.SortBy)
+	assert.Equal(t, "GB", query.CountryCode)
+	assert.Equal(t, "example.com", query.EmailDomain)
+
+	assert.ErrorIs(t, (&SearchQuery{Limit: MaxSearchLimit + 1}).Normalize(), utils.ValidationErr)
+	assert.ErrorIs(t, (&SearchQuery{SortBy: "password"}).Normalize(), utils.ValidationErr)
+	assert.ErrorIs(t, (&SearchQuery{CountryCode: "GBR"}).Normalize(), utils.ValidationErr)
+	assert.ErrorIs(t, (&SearchQuery{Cursor: "!!"}).Normalize(), utils.ValidationErr)
+}
+
+func TestSearchCursor(t *testing.T) {
+	query := &SearchQuery{SortBy: SortByLastName, Descending: true}
+	query.Cursor = query.NextCursor(&User{ID: "1234", LastName: "Doe"})
+
+	cursor, err := query.DecodeCursor()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", cursor.ID)
+	assert.Equal(t, "Doe", cursor.Value)
+
+	// a cursor cannot be replayed against a different sort order
+	other := &SearchQuery{SortBy: SortByEmail, Cursor: query.Cursor}
+	_, err = other.DecodeCursor()
+	assert.ErrorIs(t, err, utils.ValidationErr)
+}
+
+func TestSearchUsers(t *testing.T) {
+	result := &SearchResult{Users: []*User{{ID: "1234"}}, NextCursor: "next"}
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("SearchUsers", mock.MatchedBy(func(q *SearchQuery) bool {
+		return q.Limit == 10 && q.SortBy == SortByEmail && q.CountryCode == "GB"
+	})).Return(result, nil)
+
+	svc, err := NewService(&Resources{Repo: mockRepo})
+	assert.NoError(t, err)
+
+	resp, err := svc.SearchUsers(context.Background(), &SearchQuery{Limit: 10, SortBy: SortByEmail, CountryCode: "gb"})
+	assert.NoError(t, err)
+	assert.Equal(t, result, resp)
+
+	_, err = svc.SearchUsers(context.Background(), &SearchQuery{SortBy: "password"})
+	assert.ErrorIs(t, err, utils.ValidationErr)
+	mockRepo.AssertNumberOfCalls(t, "SearchUsers", 1)
+}
+
+func TestMongoSearchFilter(t *testing.T) {
+	isAdmin := true
+	query := &SearchQuery{SortBy: SortByEmail, Descending: true, CountryCode: "GB", IsAdmin: &isAdmin}
+	query.Cursor = query.NextCursor(&User{ID: "1234", Email: "a@example.com"})
+
+	filter, err := searchFilter(query)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"$and":[{"deletedAt":{"$exists":false}},{"countryCode":"GB"},{"isAdmin":true},{"$or":[{"email":{"$lt":"a@example.com"}},{"_id":{"$lt":"1234"},"email":"a@example.com"}]}]}`,
+		utils.ToJSON(filter),
+	)
+
+	filter, err = searchFilter(&SearchQuery{SortBy: SortBySaved, IncludeDeleted: true})
+	assert.NoError(t, err)
+	assert.Empty(t, filter)
+
+	filter, err = searchFilter(&SearchQuery{SortBy: SortBySaved, DeletedBefore: "2024-01-01T00:00:00Z"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"$and":[{"deletedAt":{"$lt":"2024-01-01T00:00:00Z"}}]}`, utils.ToJSON(filter))
+}
@@ -1,29 +1,77 @@
 package user
 
-type UserUpdate struct {
-	User   *User
-	Status string
-}
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackmcguire1/UserService/dom/audit"
+)
 
 type UserService interface {
-	GetUser(string) (*User, error)
-	GetUserByEmail(string) (*User, error)
-	PutUser(u *User) (*User, error)
-	DeleteUser(string) error
-	GetUsersByCountry(string) ([]*User, error)
-	GetAllUsers() ([]*User, error)
+	GetUser(context.Context, string) (*User, error)
+	GetUserByEmail(context.Context, string) (*User, error)
+	// PutUser creates or updates the user on behalf of the caller stored in the context,
+	// a request without a caller is a user signing themselves up
+	PutUser(ctx context.Context, u *User) (*User, error)
+	// DeleteUser soft deletes the user, deleted users are hidden from reads and searches
+	// until they are restored or purged
+	DeleteUser(ctx context.Context, id string) error
+	// RestoreUser undoes the soft delete of the user, restoring a user which is not deleted is a no-op
+	RestoreUser(ctx context.Context, id string) (*User, error)
+	GetUsersByCountry(context.Context, string) ([]*User, error)
+	GetAllUsers(context.Context) ([]*User, error)
+	SearchUsers(context.Context, *SearchQuery) (*SearchResult, error)
+	HashPassword(password string) ([]byte, error)
+	Authenticate(ctx context.Context, email, password string) (*User, error)
+}
+
+// Timeouts bound each repository call made by the service,
+// the request context still cancels calls earlier when the client goes away
+type Timeouts struct {
+	Read   time.Duration
+	Write  time.Duration
+	Search time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:   5 * time.Second,
+	Write:  10 * time.Second,
+	Search: 30 * time.Second,
 }
 
 type Resources struct {
-	Repo        Repository
-	UserChannel chan *UserUpdate
+	Repo     Repository
+	Hasher   PasswordHasher
+	Timeouts Timeouts
+	Emails   EmailNormalizer
+	// Audit records every mutation and search, optional
+	Audit audit.AuditService
 }
 
 type service struct {
 	*Resources
+
+	// dummyHash is verified against when the email is unknown,
+	// so unknown users take as long to reject as wrong passwords
+	dummyOnce sync.Once
+	dummyHash []byte
 }
 
 func NewService(r *Resources) (*service, error) {
+	if r.Hasher == nil {
+		r.Hasher = NewDefaultPasswordHasher()
+	}
+	if r.Timeouts.Read == 0 {
+		r.Timeouts.Read = DefaultTimeouts.Read
+	}
+	if r.Timeouts.Write == 0 {
+		r.Timeouts.Write = DefaultTimeouts.Write
+	}
+	if r.Timeouts.Search == 0 {
+		r.Timeouts.Search = DefaultTimeouts.Search
+	}
+
 	return &service{
 		Resources: r,
 	}, nil
@@ -0,0 +1,394 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const userColumns = `id, first_name, last_name, email, nick_name, country_code, saved, password, is_admin, version, normalized_email, deleted_at, deleted_by, data_key`
+
+// sqlSortColumns maps the search sort fields to their column
+var sqlSortColumns = map[SortField]string{
+	SortBySaved:    "saved",
+	SortByLastName: "last_name",
+	SortByEmail:    "email",
+}
+
+// sqlRepository implements Repository with database/sql for the SQL backends,
+// queries only use '$n' placeholders, upserts and row values which postgres and sqlite both understand
+type sqlRepository struct {
+	BaseRepository
+
+	DB *sql.DB
+
+	// isUniqueViolation reports whether the driver error is a unique constraint violation
+	isUniqueViolation func(error) bool
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	u := &User{}
+	var normalizedEmail, deletedAt sql.NullString
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.NickName,
+		&u.CountryCode,
+		&u.Saved,
+		&u.Password,
+		&u.IsAdmin,
+		&u.Version,
+		&normalizedEmail,
+		&deletedAt,
+		&u.DeletedBy,
+		&u.DataKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	u.NormalizedEmail = normalizedEmail.String
+	u.DeletedAt = deletedAt.String
+
+	return u, nil
+}
+
+func (repo *sqlRepository) GetUser(ctx context.Context, userId string) (*User, error) {
+	return repo.getUserBy(ctx, "id = $1", userId)
+}
+
+// GetUserByEmail matches the normalized email, users which have not been backfilled yet
+// are matched on their lowercased email
+func (repo *sqlRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return repo.getUserBy(ctx, "normalized_email = $1 OR (normalized_email IS NULL AND lower(email) = $1)", email)
+}
+
+func (repo *sqlRepository) getUserBy(ctx context.Context, condition, value string) (*User, error) {
+	row := repo.DB.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE `+condition, value)
+
+	u, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to scan user row err:%w", err)
+	}
+
+	return u, nil
+}
+
+func (repo *sqlRepository) GetUsersByCountry(ctx context.Context, cc string) ([]*User, error) {
+	return repo.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE country_code = $1 ORDER BY id`, cc)
+}
+
+func (repo *sqlRepository) GetAllUsers(ctx context.Context) ([]*User, error) {
+	return repo.queryUsers(ctx, `SELECT `+userColumns+` FROM users ORDER BY id`)
+}
+
+func (repo *sqlRepository) PutUser(ctx context.Context, u *User, event *UserUpdate) error {
+	return repo.withEvent(ctx, event, func(tx *sql.Tx) error {
+		args := []any{
+			u.ID,
+			u.FirstName,
+			u.LastName,
+			u.Email,
+			u.NickName,
+			u.CountryCode,
+			u.Saved,
+			u.Password,
+			u.IsAdmin,
+			u.Version,
+			sql.NullString{String: u.NormalizedEmail, Valid: u.NormalizedEmail != ""},
+			sql.NullString{String: u.DeletedAt, Valid: u.DeletedAt != ""},
+			u.DeletedBy,
+			u.DataKey,
+		}
+
+		res, err := tx.ExecContext(ctx, `UPDATE users SET
+				first_name = $2,
+				last_name = $3,
+				email = $4,
+				nick_name = $5,
+				country_code = $6,
+				saved = $7,
+				password = $8,
+				is_admin = $9,
+				version = $10,
+				normalized_email = $11,
+				deleted_at = $12,
+				deleted_by = $13,
+				data_key = $14
+			WHERE id = $1 AND version = $15`,
+			append(args, u.Version-1)...,
+		)
+		if err != nil {
+			return repo.writeError(err)
+		}
+
+		count, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 1 {
+			return nil
+		}
+
+		// only the first version of a user is inserted, a missing row for a later version was deleted concurrently
+		if u.Version == 1 {
+			res, err = tx.ExecContext(ctx, `INSERT INTO users (`+userColumns+`)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+				ON CONFLICT (id) DO NOTHING`,
+				args...,
+			)
+			if err != nil {
+				return repo.writeError(err)
+			}
+
+			count, err = res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if count == 1 {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("user %s is not at version %d err: %w", u.ID, u.Version-1, utils.VersionConflict)
+	})
+}
+
+func (repo *sqlRepository) RewriteUser(ctx context.Context, u *User) error {
+	res, err := repo.DB.ExecContext(ctx, `UPDATE users SET
+			first_name = $2,
+			last_name = $3,
+			email = $4,
+			nick_name = $5,
+			country_code = $6,
+			saved = $7,
+			password = $8,
+			is_admin = $9,
+			normalized_email = $10,
+			deleted_at = $11,
+			deleted_by = $12,
+			data_key = $13
+		WHERE id = $1 AND version = $14`,
+		u.ID,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.NickName,
+		u.CountryCode,
+		u.Saved,
+		u.Password,
+		u.IsAdmin,
+		sql.NullString{String: u.NormalizedEmail, Valid: u.NormalizedEmail != ""},
+		sql.NullString{String: u.DeletedAt, Valid: u.DeletedAt != ""},
+		u.DeletedBy,
+		u.DataKey,
+		u.Version,
+	)
+	if err != nil {
+		return repo.writeError(err)
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("user %s is not at version %d err: %w", u.ID, u.Version, utils.VersionConflict)
+	}
+
+	return nil
+}
+
+func (repo *sqlRepository) writeError(err error) error {
+	if repo.isUniqueViolation(err) {
+		return fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+	}
+
+	return err
+}
+
+func (repo *sqlRepository) DeleteUser(ctx context.Context, id string, event *UserUpdate) error {
+	return repo.withEvent(ctx, event, func(tx *sql.Tx) error {
+		statement, args := `DELETE FROM users WHERE id = $1`, []any{id}
+		if event != nil && event.removedVersion != 0 {
+			statement, args = statement+` AND version = $2`, append(args, event.removedVersion)
+		}
+
+		res, err := tx.ExecContext(ctx, statement, args...)
+		if err != nil {
+			return err
+		}
+
+		count, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 1 {
+			return nil
+		}
+
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("user %s was modified concurrently err: %w", id, utils.VersionConflict)
+		}
+
+		return fmt.Errorf("failed to remove user from repo count:%d %w", count, utils.ErrNotFound)
+	})
+}
+
+// withEvent runs the write and inserts the event into the user_events outbox table in one transaction
+func (repo *sqlRepository) withEvent(ctx context.Context, event *UserUpdate, write func(*sql.Tx) error) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = write(tx)
+	if err != nil {
+		return err
+	}
+
+	if event != nil {
+		msg := event.Message()
+		_, err = tx.ExecContext(ctx, `INSERT INTO user_events
+			(id, type, subject, payload, created_at, attempts, next_attempt_at, locked_until, last_error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			msg.ID,
+			msg.Type,
+			msg.Subject,
+			msg.Payload,
+			msg.CreatedAt,
+			msg.Attempts,
+			msg.NextAttemptAt,
+			msg.LockedUntil,
+			msg.LastError,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to enqueue user event err:%w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) SearchUsers(ctx context.Context, query *SearchQuery) (*SearchResult, error) {
+	where, args, err := searchWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	order := "ASC"
+	if query.Descending {
+		order = "DESC"
+	}
+	column := sqlSortColumns[query.SortBy]
+
+	// fetch one extra user to find out whether there is another page
+	args = append(args, query.Limit+1)
+	statement := fmt.Sprintf(`SELECT %s FROM users %s ORDER BY %s %s, id %s LIMIT $%d`,
+		userColumns, where, column, order, order, len(args),
+	)
+
+	users, err := repo.queryUsers(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Users: users}
+	if len(users) > query.Limit {
+		result.Users = users[:query.Limit]
+		result.NextCursor = query.NextCursor(result.Users[query.Limit-1])
+	}
+
+	return result, nil
+}
+
+// searchWhere builds the WHERE clause and its arguments for the query filters and cursor
+func searchWhere(query *SearchQuery) (string, []any, error) {
+	conditions := []string{}
+	args := []any{}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.DeletedBefore != "" {
+		conditions = append(conditions, "deleted_at < "+arg(query.DeletedBefore))
+	} else if !query.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if query.CountryCode != "" {
+		conditions = append(conditions, "country_code = "+arg(query.CountryCode))
+	}
+	if query.IsAdmin != nil {
+		conditions = append(conditions, "is_admin = "+arg(*query.IsAdmin))
+	}
+	if query.EmailDomain != "" {
+		pattern := "%@" + escapeLike(strings.ToLower(query.EmailDomain))
+		conditions = append(conditions, `lower(email) LIKE `+arg(pattern)+` ESCAPE '\'`)
+	}
+	if query.NamePrefix != "" {
+		prefix := arg(escapeLike(strings.ToLower(query.NamePrefix)) + "%")
+		conditions = append(conditions, `(lower(first_name) LIKE `+prefix+` ESCAPE '\' OR lower(last_name) LIKE `+prefix+` ESCAPE '\')`)
+	}
+
+	cursor, err := query.DecodeCursor()
+	if err != nil {
+		return "", nil, err
+	}
+	if cursor != nil {
+		op := ">"
+		if query.Descending {
+			op = "<"
+		}
+
+		column := sqlSortColumns[query.SortBy]
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, op, arg(cursor.Value), arg(cursor.ID)))
+	}
+
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (repo *sqlRepository) queryUsers(ctx context.Context, statement string, args ...any) ([]*User, error) {
+	rows, err := repo.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row err:%w", err)
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
@@ -0,0 +1,59 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/jackmcguire1/UserService/pkg/sqlerr"
+)
+
+// SQLiteRepository stores users in an embedded sqlite database file using the pure-Go driver,
+// it shares its schema and queries with PostgresRepository and is intended for single-node deployments
+type SQLiteRepository struct {
+	sqlRepository
+}
+
+type SQLiteRepoParams struct {
+	// Path of the database file, created when missing
+	Path string
+}
+
+func NewSQLiteRepo(ctx context.Context, params *SQLiteRepoParams) (*SQLiteRepository, error) {
+	db, err := sql.Open("sqlite", SQLiteDSN(params.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSQLiteRepoFromDB(db), nil
+}
+
+// SQLiteDSN enables WAL so readers are not blocked by the writer, waits for the write lock instead of
+// failing with SQLITE_BUSY and stores times in a sortable format, transactions take the write lock
+// up front so concurrent read-then-write transactions can't deadlock
+func SQLiteDSN(path string) string {
+	params := url.Values{}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_time_format", "sqlite")
+	params.Add("_txlock", "immediate")
+
+	return "file:" + path + "?" + params.Encode()
+}
+
+// NewSQLiteRepoFromDB shares an open database, e.g. with the session and outbox repositories
+func NewSQLiteRepoFromDB(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{
+		sqlRepository: sqlRepository{
+			DB:                db,
+			isUniqueViolation: sqlerr.IsSQLiteUniqueViolation,
+		},
+	}
+}
@@ -1,14 +1,21 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/mail"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/pkg/iso3166"
+	"github.com/jackmcguire1/UserService/pkg/redact"
 	"github.com/jackmcguire1/UserService/pkg/utils"
 )
 
@@ -27,13 +34,53 @@ type User struct {
 	Saved       string `json:"saved" bson:"saved"`
 	Password    []byte `json:"-" bson:"password"`
 	IsAdmin     bool   `json:"is_admin"  bson:"isAdmin"`
+	// Version is incremented on every write of the user
+	Version int64 `json:"version" bson:"version"`
+	// NormalizedEmail is the unique lookup key derived by EmailNormalizer, Email keeps the address as entered
+	NormalizedEmail string `json:"-" bson:"normalizedEmail,omitempty"`
+	// DeletedAt is the UTC RFC 3339 time the user was soft deleted by DeletedBy, deleted users keep their email
+	// until they are restored or purged, see Purger
+	DeletedAt string `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy string `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
+	// DataKey is the wrapped key the personal fields are stored encrypted with, see EncryptedRepository,
+	// it is empty while they are stored in plaintext
+	DataKey string `json:"-" bson:"dataKey,omitempty"`
 }
 
-func (svc *service) GetUser(userID string) (*User, error) {
-	logEntry := slog.With("user-id", userID)
+// Deleted reports whether the user has been soft deleted
+func (u *User) Deleted() bool {
+	return u.DeletedAt != ""
+}
+
+// LogValue tags the personal fields of the user so a redact.Handler can mask, hash or drop them,
+// the password hash is never logged
+func (u *User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("_id", redact.Tag(redact.ClassID, u.ID)),
+		slog.Any("firstName", redact.Tag(redact.ClassName, u.FirstName)),
+		slog.Any("lastName", redact.Tag(redact.ClassName, u.LastName)),
+		slog.Any("email", redact.Tag(redact.ClassEmail, u.Email)),
+		slog.Any("nickName", redact.Tag(redact.ClassName, u.NickName)),
+		slog.String("countryCode", u.CountryCode),
+		slog.String("saved", u.Saved),
+		slog.Bool("is_admin", u.IsAdmin),
+		slog.Int64("version", u.Version),
+		slog.String("deletedAt", u.DeletedAt),
+		slog.Any("deletedBy", redact.Tag(redact.ClassID, u.DeletedBy)),
+	)
+}
+
+func (svc *service) GetUser(ctx context.Context, userID string) (*User, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("user-id", userID)
 	logEntry.Info("call GetUser")
 
-	user, err := svc.Repo.GetUser(userID)
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Read)
+	defer cancel()
+
+	user, err := svc.Repo.GetUser(ctx, userID)
+	if err == nil && user.Deleted() {
+		err = fmt.Errorf("user %s is deleted err: %w", userID, utils.ErrNotFound)
+	}
 	if err != nil {
 		logEntry.
 			With("error", err).
@@ -45,11 +92,17 @@ func (svc *service) GetUser(userID string) (*User, error) {
 	return user, err
 }
 
-func (svc *service) GetUserByEmail(email string) (*User, error) {
-	logEntry := slog.With("email", email)
+func (svc *service) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("email", email)
 	logEntry.Debug("call GetUser")
 
-	user, err := svc.Repo.GetUserByEmail(email)
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Read)
+	defer cancel()
+
+	user, err := svc.Repo.GetUserByEmail(ctx, svc.Emails.Normalize(email))
+	if err == nil && user.Deleted() {
+		err = fmt.Errorf("user with this email is deleted err: %w", utils.ErrNotFound)
+	}
 	if err != nil {
 		logEntry.
 			With("error", err).
@@ -61,17 +114,21 @@ func (svc *service) GetUserByEmail(email string) (*User, error) {
 	return user, err
 }
 
-func (svc *service) PutUser(u *User) (*User, error) {
-	logEntry := slog.With("user", utils.ToJSON(u))
+func (svc *service) PutUser(ctx context.Context, u *User) (*User, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("user", u)
 	logEntry.Info("call PutUser")
 
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Write)
+	defer cancel()
+
 	if u == nil {
 		logEntry.Error("user struct not init")
 
 		return nil, fmt.Errorf("user struct was nil")
 	}
 
-	if u.ID == "" {
+	generatedID := u.ID == ""
+	if generatedID {
 		logEntry.Warn("no userID has been defined, generating new")
 
 		guid, err := uuid.NewUUID()
@@ -90,69 +147,187 @@ func (svc *service) PutUser(u *User) (*User, error) {
 		logEntry.Debug("generated new uuid for user")
 	}
 
+	u.Email = CleanEmail(u.Email)
 	if err := u.Validate(); err != nil {
 		return nil, err
 	}
+	u.NormalizedEmail = svc.Emails.Normalize(u.Email)
 
-	if u.Email != "" {
-		existingUser, err := svc.GetUserByEmail(u.Email)
+	var previous *User
+	if !generatedID {
+		existingUser, err := svc.Repo.GetUser(ctx, u.ID)
 		if err != nil && !errors.Is(err, utils.ErrNotFound) {
 			return nil, err
 		}
+		previous = existingUser
+	}
 
-		if existingUser != nil && existingUser.ID != u.ID {
-			return nil, fmt.Errorf("user already exists with this email err: %w", utils.AlreadyExists)
+	// deleted users are only written again by RestoreUser
+	if previous != nil && previous.Deleted() {
+		return nil, fmt.Errorf("user %s is deleted err: %w", u.ID, utils.ErrNotFound)
+	}
+
+	if err := checkExpectedVersion(ctx, u.ID, previous); err != nil {
+		logEntry.
+			With("error", err).
+			Warn("user is not at the expected version")
+
+		return nil, err
+	}
+
+	// the soft delete is only changed by DeleteUser and RestoreUser
+	u.DeletedAt, u.DeletedBy = "", ""
+
+	u.Version = 1
+	if previous != nil {
+		u.Version = previous.Version + 1
+		u.DeletedAt, u.DeletedBy = previous.DeletedAt, previous.DeletedBy
+
+		// profile updates do not carry the password hash
+		if len(u.Password) == 0 {
+			u.Password = previous.Password
 		}
 	}
 
-	u.Saved = time.Now().Format(time.RFC3339)
+	actorID := utils.CallerID(ctx)
+	if actorID == "" {
+		actorID = u.ID
+	}
+
+	u.Saved = time.Now().UTC().Format(time.RFC3339)
 	u.CountryCode = strings.ToUpper(u.CountryCode)
 
+	// the repository enforces email uniqueness atomically and returns utils.AlreadyExists
 	logEntry.Debug("saving user to repository")
-	err := svc.Repo.PutUser(u)
+	err := svc.Repo.PutUser(ctx, u, NewUserUpdate(previous, u, actorID))
 	if err != nil {
 		logEntry.
 			With("error", err).
 			Error("failed to put user into repository")
 
-		return nil, err
+		return nil, conflictError(ctx, err)
 	}
 
-	if svc.UserChannel != nil {
-		svc.UserChannel <- &UserUpdate{
-			User:   u,
-			Status: "UPDATE",
-		}
+	action := audit.ActionUserUpdated
+	if previous == nil {
+		action = audit.ActionUserCreated
 	}
+	svc.recordAudit(ctx, &audit.Entry{
+		ActorID:      actorID,
+		Action:       action,
+		TargetUserID: u.ID,
+		BeforeHash:   auditHash(previous),
+		AfterHash:    auditHash(u),
+	})
 
 	return u, err
 }
 
-func (svc *service) DeleteUser(id string) error {
-	err := svc.Repo.DeleteUser(id)
+func (svc *service) DeleteUser(ctx context.Context, id string) error {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("user-id", id)
+	logEntry.Info("call DeleteUser")
+
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Write)
+	defer cancel()
+
+	previous, err := svc.Repo.GetUser(ctx, id)
 	if err != nil {
 		return err
 	}
+	if previous.Deleted() {
+		return fmt.Errorf("user %s is already deleted err: %w", id, utils.ErrNotFound)
+	}
 
-	if svc.UserChannel != nil {
-		svc.UserChannel <- &UserUpdate{
-			User:   &User{ID: id},
-			Status: "DELETED",
-		}
+	err = checkExpectedVersion(ctx, id, previous)
+	if err != nil {
+		return err
 	}
 
+	// users are soft deleted so they can be restored until the Purger removes them
+	deleted := *previous
+	deleted.Version++
+	deleted.DeletedAt = time.Now().UTC().Format(time.RFC3339)
+	deleted.DeletedBy = utils.CallerID(ctx)
+
+	err = svc.Repo.PutUser(ctx, &deleted, NewUserUpdate(previous, nil, deleted.DeletedBy))
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to delete user in repository")
+
+		return conflictError(ctx, err)
+	}
+
+	svc.recordAudit(ctx, &audit.Entry{
+		Action:       audit.ActionUserDeleted,
+		TargetUserID: id,
+		BeforeHash:   auditHash(previous),
+		AfterHash:    auditHash(&deleted),
+	})
+
 	return err
 }
 
-func (svc *service) GetUsersByCountry(countryCode string) ([]*User, error) {
-	logEntry := slog.
+func (svc *service) RestoreUser(ctx context.Context, id string) (*User, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("user-id", id)
+	logEntry.Info("call RestoreUser")
+
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Write)
+	defer cancel()
+
+	previous, err := svc.Repo.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkExpectedVersion(ctx, id, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	if !previous.Deleted() {
+		return previous, nil
+	}
+
+	restored := *previous
+	restored.Version++
+	restored.DeletedAt = ""
+	restored.DeletedBy = ""
+
+	event := NewUserUpdate(previous, &restored, utils.CallerID(ctx))
+	event.Status = EventRestored
+
+	err = svc.Repo.PutUser(ctx, &restored, event)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to restore user in repository")
+
+		return nil, conflictError(ctx, err)
+	}
+
+	svc.recordAudit(ctx, &audit.Entry{
+		Action:       audit.ActionUserRestored,
+		TargetUserID: id,
+		BeforeHash:   auditHash(previous),
+		AfterHash:    auditHash(&restored),
+	})
+
+	return &restored, nil
+}
+
+func (svc *service) GetUsersByCountry(ctx context.Context, countryCode string) ([]*User, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).
 		With("country-code", countryCode)
 
 	logEntry.
 		Info("call GetUsersByCountry")
 
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Search)
+	defer cancel()
+
 	logEntry.Debug("querying get all users")
-	users, err := svc.Repo.GetUsersByCountry(countryCode)
+	users, err := svc.Repo.GetUsersByCountry(ctx, countryCode)
 	if err != nil {
 		logEntry.
 			With("error", err).
@@ -160,45 +335,351 @@ func (svc *service) GetUsersByCountry(countryCode string) ([]*User, error) {
 
 		return nil, err
 	}
+	users = withoutDeleted(users)
+
+	err = svc.auditSearch(ctx, utils.ToJSON(map[string]string{"CountryCode": countryCode}))
+	if err != nil {
+		return nil, err
+	}
 
 	logEntry.
-		With("user-batch", utils.ToJSON(users)).
+		With("user-count", len(users)).
 		Debug("got users from repository")
 
 	return users, nil
 }
 
-func (svc *service) GetAllUsers() ([]*User, error) {
-	slog.
+func (svc *service) GetAllUsers(ctx context.Context) ([]*User, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default())
+	logEntry.
 		Info("call GetAllUsers")
 
-	users, err := svc.Repo.GetAllUsers()
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Search)
+	defer cancel()
+
+	users, err := svc.Repo.GetAllUsers(ctx)
 	if err != nil {
-		slog.
+		logEntry.
 			With("error", err).
 			Error("failed to get all users from repository")
 	}
+	users = withoutDeleted(users)
 
-	slog.
-		With("user-batch", utils.ToJSON(users)).
+	logEntry.
+		With("user-count", len(users)).
 		Debug("got all users from repository")
 
 	return users, err
 }
 
-func (u *User) Validate() error {
-	if u.CountryCode == "" || len(u.CountryCode) != 2 {
-		return fmt.Errorf("%w - please enter a valid ISO ALPHA-2 country code", utils.ValidationErr)
+func (svc *service) SearchUsers(ctx context.Context, query *SearchQuery) (*SearchResult, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("query", query)
+	logEntry.Info("call SearchUsers")
+
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, svc.Timeouts.Search)
+	defer cancel()
+
+	result, err := svc.Repo.SearchUsers(ctx, query)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to search users in repository")
+
+		return nil, err
+	}
+
+	err = svc.auditSearch(ctx, utils.ToJSON(query))
+	if err != nil {
+		return nil, err
+	}
+
+	logEntry.
+		With("count", len(result.Users)).
+		With("next-cursor", result.NextCursor).
+		Debug("got users from repository")
+
+	return result, nil
+}
+
+func (svc *service) HashPassword(password string) ([]byte, error) {
+	return svc.Hasher.Hash(password)
+}
+
+// Authenticate verifies the password for the user with the given email,
+// hashes produced by a legacy or outdated hasher are transparently upgraded on success
+func (svc *service) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	logEntry := utils.ContextLogger(ctx, slog.Default()).With("email", email)
+	logEntry.Debug("call Authenticate")
+
+	u, err := svc.GetUserByEmail(ctx, email)
+	if errors.Is(err, utils.ErrNotFound) {
+		logEntry.Warn("unknown email")
+
+		svc.verifyDummy(password)
+		return nil, InvalidCredentialsErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logEntry = logEntry.With("user-id", u.ID)
+
+	ok, err := svc.Hasher.Verify(password, u.Password)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to verify password")
+
+		return nil, err
+	}
+	if !ok {
+		logEntry.Warn("password mismatch")
+
+		return nil, InvalidCredentialsErr
+	}
+
+	if svc.Hasher.NeedsRehash(u.Password) {
+		logEntry.Info("upgrading password hash")
+
+		password, err := svc.Hasher.Hash(password)
+		if err != nil {
+			logEntry.
+				With("error", err).
+				Error("failed to rehash password")
+
+			return u, nil
+		}
+		previous := *u
+		beforeHash := auditHash(u)
+		u.Password = password
+		u.Version++
+		u.Saved = time.Now().UTC().Format(time.RFC3339)
+
+		writeCtx, cancel := context.WithTimeout(ctx, svc.Timeouts.Write)
+		defer cancel()
+
+		// the new version is published like any other password change so consumers tracking versions see no gap
+		err = svc.Repo.PutUser(writeCtx, u, NewUserUpdate(&previous, u, u.ID))
+		if err != nil {
+			logEntry.
+				With("error", err).
+				Error("failed to save upgraded password hash")
+
+			return u, nil
+		}
+
+		svc.recordAudit(ctx, &audit.Entry{
+			ActorID:      u.ID,
+			Action:       audit.ActionUserUpdated,
+			TargetUserID: u.ID,
+			BeforeHash:   beforeHash,
+			AfterHash:    auditHash(u),
+			Details:      "password hash upgraded",
+		})
+	}
+
+	return u, nil
+}
+
+// verifyDummy spends the same work verifying the password as for a known user,
+// the result is discarded
+func (svc *service) verifyDummy(password string) {
+	svc.dummyOnce.Do(func() {
+		hash, err := svc.Hasher.Hash("dummy-password")
+		if err != nil {
+			slog.With("error", err).Error("failed to hash dummy password")
+			return
+		}
+		svc.dummyHash = hash
+	})
+	if svc.dummyHash == nil {
+		return
+	}
+
+	_, _ = svc.Hasher.Verify(password, svc.dummyHash)
+}
+
+// withoutDeleted filters soft deleted users out of the listing
+func withoutDeleted(users []*User) []*User {
+	if users == nil {
+		return nil
+	}
+
+	kept := []*User{}
+	for _, u := range users {
+		if !u.Deleted() {
+			kept = append(kept, u)
+		}
+	}
+
+	return kept
+}
+
+// recordAudit appends the action to the audit log when one is configured, the write has already happened
+// so failing to record it is logged for alerting rather than returned
+func (svc *service) recordAudit(ctx context.Context, entry *audit.Entry) {
+	if svc.Audit == nil {
+		return
+	}
+
+	err := svc.Audit.Record(ctx, entry)
+	if err != nil {
+		utils.ContextLogger(ctx, slog.Default()).
+			With("error", err).
+			With("action", entry.Action).
+			With("target-user-id", entry.TargetUserID).
+			Error("unaudited write, failed to record audit entry")
+	}
+}
+
+// auditSearch records the search of an administrator, the results are only returned once the search is recorded
+// so no admin search goes untraced. Searches by other callers are not audited
+func (svc *service) auditSearch(ctx context.Context, details string) error {
+	if svc.Audit == nil || !utils.CallerIsAdmin(ctx) {
+		return nil
+	}
+
+	err := svc.Audit.Record(ctx, &audit.Entry{
+		Action:  audit.ActionUsersSearched,
+		Details: details,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to audit search err:%w", err)
 	}
-	if u.FirstName == "" {
-		return fmt.Errorf("%w - please enter a valid first name", utils.ValidationErr)
+
+	return nil
+}
+
+// auditHash fingerprints every stored field of the user including the password hash,
+// a missing user has no hash
+func auditHash(u *User) string {
+	if u == nil {
+		return ""
 	}
-	if u.LastName == "" {
-		return fmt.Errorf("%w - please enter a valid last name", utils.ValidationErr)
+
+	return audit.Hash(utils.ToRAWJSON(struct {
+		*User
+		Password        []byte `json:"password"`
+		NormalizedEmail string `json:"normalizedEmail"`
+	}{u, u.Password, u.NormalizedEmail}))
+}
+
+// checkExpectedVersion fails with utils.PreconditionFailed unless the stored user is at the version the caller
+// expects, see utils.WithExpectedVersion. Expecting a version of a missing user always fails
+func checkExpectedVersion(ctx context.Context, id string, stored *User) error {
+	expected, ok := utils.ExpectedVersion(ctx)
+	if !ok {
+		return nil
 	}
-	if u.Email == "" || !strings.Contains(u.Email, "@") {
-		return fmt.Errorf("%w - please enter a valid email", utils.ValidationErr)
+
+	if stored == nil {
+		return fmt.Errorf("user %s does not exist err: %w", id, utils.PreconditionFailed)
+	}
+	if stored.Version != expected {
+		return fmt.Errorf("user %s is at version %d not %d err: %w", id, stored.Version, expected, utils.PreconditionFailed)
 	}
 
 	return nil
 }
+
+// conflictError reports concurrent modifications of a conditional request as a failed precondition,
+// the user is no longer at the version the caller expected
+func conflictError(ctx context.Context, err error) error {
+	if _, ok := utils.ExpectedVersion(ctx); ok && errors.Is(err, utils.VersionConflict) {
+		return fmt.Errorf("%w: %w", utils.PreconditionFailed, err)
+	}
+
+	return err
+}
+
+const (
+	MaxNameLength     = 100
+	MaxNickNameLength = 50
+	// MaxEmailLength is the longest address SMTP can deliver to
+	MaxEmailLength      = 254
+	maxEmailLocalLength = 64
+)
+
+// Validate checks every field and reports all invalid ones, emails are expected to be cleaned with CleanEmail
+func (u *User) Validate() error {
+	errs := utils.ValidationErrors{}
+
+	if !iso3166.Valid(u.CountryCode) {
+		errs.Add("countryCode", "please enter a valid ISO 3166-1 alpha-2 country code")
+	}
+	validateName(&errs, "firstName", u.FirstName, true)
+	validateName(&errs, "lastName", u.LastName, true)
+	validateNickName(&errs, u.NickName)
+	validateEmail(&errs, u.Email)
+
+	return errs.Err()
+}
+
+// validateName allows letters, combining marks, spaces and the punctuation found in names e.g. "Mary-Jane O'Neil Jr."
+func validateName(errs *utils.ValidationErrors, field, name string, required bool) {
+	if strings.TrimSpace(name) == "" {
+		if required {
+			errs.Add(field, "is required")
+		}
+		return
+	}
+
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		errs.Add(field, "must be at most %d characters", MaxNameLength)
+		return
+	}
+
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) && !strings.ContainsRune(" -'’.", r) {
+			errs.Add(field, "may only contain letters, spaces, hyphens, apostrophes and periods")
+			return
+		}
+	}
+}
+
+func validateNickName(errs *utils.ValidationErrors, nickName string) {
+	if utf8.RuneCountInString(nickName) > MaxNickNameLength {
+		errs.Add("nickName", "must be at most %d characters", MaxNickNameLength)
+		return
+	}
+
+	for _, r := range nickName {
+		if !unicode.IsPrint(r) {
+			errs.Add("nickName", "must not contain control characters")
+			return
+		}
+	}
+}
+
+// validateEmail accepts a bare RFC 5322 address with a dotted domain, display names and comments are rejected
+func validateEmail(errs *utils.ValidationErrors, email string) {
+	if email == "" {
+		errs.Add("email", "is required")
+		return
+	}
+
+	if len(email) > MaxEmailLength {
+		errs.Add("email", "must be at most %d characters", MaxEmailLength)
+		return
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		errs.Add("email", "please enter a valid email address")
+		return
+	}
+
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+	if len(local) > maxEmailLocalLength {
+		errs.Add("email", "the part before '@' must be at most %d characters", maxEmailLocalLength)
+		return
+	}
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		errs.Add("email", "please enter a valid email domain")
+	}
+}
@@ -1,8 +1,17 @@
 package user
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jackmcguire1/UserService/dom/audit"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/redact"
 	"github.com/jackmcguire1/UserService/pkg/utils"
 
 	"github.com/stretchr/testify/assert"
@@ -23,7 +32,7 @@ func TestGetUser(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
-	resp, err := svc.GetUser("100249558")
+	resp, err := svc.GetUser(context.Background(), "100249558")
 	assert.NoError(t, err)
 	assert.Equal(t, resp.FirstName, "John")
 }
@@ -38,17 +47,16 @@ func TestPutUser(t *testing.T) {
 
 	mockRepo := &MockRepository{}
 
-	mockRepo.On("PutUser", user).Return(nil)
-	mockRepo.On("GetUserByEmail", mock.Anything).Return(nil, utils.ErrNotFound)
+	mockRepo.On("PutUser", user, mock.Anything).Return(nil)
 	svc, err := NewService(&Resources{
 		Repo: mockRepo,
 	})
 	assert.NoError(t, err)
 
-	user, err = svc.PutUser(user)
+	user, err = svc.PutUser(context.Background(), user)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, user.ID)
-	assert.NotEmpty(t, user.Saved)
+	assert.True(t, strings.HasSuffix(user.Saved, "Z"), "saved is stored in UTC so it sorts chronologically")
 	assert.Equal(t, "US", user.CountryCode)
 }
 
@@ -64,21 +72,81 @@ func TestPutInvalidRequest(t *testing.T) {
 	svc, err := NewService(&Resources{})
 	assert.NoError(t, err)
 
-	user, err = svc.PutUser(user)
+	user, err = svc.PutUser(context.Background(), user)
 	assert.ErrorIs(t, err, utils.ValidationErr)
 }
 
 func TestDeleteUser(t *testing.T) {
 	mockRepo := &MockRepository{}
 
-	mockRepo.On("DeleteUser", mock.Anything).Return(nil)
+	mockRepo.On("GetUser", "100249558").Return(&User{ID: "100249558", Version: 3}, nil)
+	mockRepo.On("PutUser", mock.MatchedBy(func(u *User) bool {
+		return u.ID == "100249558" && u.Deleted() && u.DeletedBy == "admin" && u.Version == 4
+	}), mock.MatchedBy(func(event *UserUpdate) bool {
+		return event.Status == EventDeleted &&
+			event.UserID == "100249558" &&
+			event.User == nil &&
+			event.Previous.ID == "100249558" &&
+			event.Version == 4 &&
+			event.ActorID == "admin"
+	})).Return(nil)
 	svc, err := NewService(&Resources{
 		Repo: mockRepo,
 	})
 	assert.NoError(t, err)
 
-	err = svc.DeleteUser("100249558")
+	err = svc.DeleteUser(utils.WithCallerID(context.Background(), "admin"), "100249558")
 	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestExpectedVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo(nil)
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+
+	u, err := svc.PutUser(ctx, &User{FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), u.Version)
+
+	stale := utils.WithExpectedVersion(ctx, 2)
+	update := *u
+	update.FirstName = "Jane"
+	_, err = svc.PutUser(stale, &update)
+	assert.ErrorIs(t, err, utils.PreconditionFailed)
+
+	err = svc.DeleteUser(stale, u.ID)
+	assert.ErrorIs(t, err, utils.PreconditionFailed)
+
+	_, err = svc.PutUser(utils.WithExpectedVersion(ctx, 1), &update)
+	assert.NoError(t, err)
+
+	got, err := svc.GetUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane", got.FirstName)
+	assert.Equal(t, int64(2), got.Version)
+
+	_, err = svc.PutUser(utils.WithExpectedVersion(ctx, 1), &User{ID: "missing", FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "missing@example.com"})
+	assert.ErrorIs(t, err, utils.PreconditionFailed, "expecting a version of a missing user")
+
+	assert.NoError(t, svc.DeleteUser(utils.WithExpectedVersion(ctx, 2), u.ID))
+}
+
+func TestPutUserRaceFailsPrecondition(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetUser", "1234").Return(&User{ID: "1234", Version: 1}, nil)
+	mockRepo.On("PutUser", mock.Anything, mock.Anything).Return(utils.VersionConflict)
+	svc, err := NewService(&Resources{Repo: mockRepo})
+	assert.NoError(t, err)
+
+	u := &User{ID: "1234", FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"}
+	_, err = svc.PutUser(context.Background(), u)
+	assert.ErrorIs(t, err, utils.VersionConflict)
+	assert.NotErrorIs(t, err, utils.PreconditionFailed, "unconditional writes report the conflict")
+
+	_, err = svc.PutUser(utils.WithExpectedVersion(context.Background(), 1), u)
+	assert.ErrorIs(t, err, utils.PreconditionFailed)
 }
 
 func TestGetUsersByCountry(t *testing.T) {
@@ -104,8 +172,307 @@ func TestGetUsersByCountry(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
-	resp, err := svc.GetUsersByCountry("GB")
+	resp, err := svc.GetUsersByCountry(context.Background(), "GB")
 	assert.NoError(t, err)
 	assert.NotEmpty(t, resp)
 	assert.Len(t, resp, 2)
 }
+
+func TestPutUserEnqueuesEvent(t *testing.T) {
+	user := &User{
+		FirstName:   "John",
+		LastName:    "Doe",
+		CountryCode: "GB",
+		Email:       "jack@blah.com",
+	}
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("PutUser", user, mock.MatchedBy(func(event *UserUpdate) bool {
+		return event.ID != "" &&
+			event.Status == EventCreated &&
+			event.User == user &&
+			event.Previous == nil &&
+			event.Version == 1 &&
+			event.ActorID == event.UserID
+	})).Return(nil)
+
+	svc, err := NewService(&Resources{Repo: mockRepo})
+	assert.NoError(t, err)
+
+	_, err = svc.PutUser(context.Background(), user)
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPutUserUpdateEvent(t *testing.T) {
+	previous := &User{
+		ID:          "1234",
+		FirstName:   "John",
+		LastName:    "Doe",
+		CountryCode: "GB",
+		Email:       "jack@blah.com",
+		Password:    []byte("hash"),
+		Version:     2,
+	}
+	user := &User{
+		ID:          "1234",
+		FirstName:   "Jack",
+		LastName:    "Doe",
+		CountryCode: "GB",
+		Email:       "jack@blah.com",
+	}
+
+	var event *UserUpdate
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetUser", "1234").Return(previous, nil)
+	mockRepo.On("PutUser", user, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		event = args.Get(1).(*UserUpdate)
+	})
+
+	svc, err := NewService(&Resources{Repo: mockRepo})
+	assert.NoError(t, err)
+
+	_, err = svc.PutUser(utils.WithCallerID(context.Background(), "admin"), user)
+	assert.NoError(t, err)
+
+	assert.Equal(t, EventUpdated, event.Status)
+	assert.Equal(t, []string{"firstName"}, event.ChangedFields)
+	assert.False(t, event.PasswordChanged, "profile updates keep the existing password")
+	assert.Equal(t, []byte("hash"), user.Password)
+	assert.Equal(t, int64(3), event.Version)
+	assert.Equal(t, "admin", event.ActorID)
+	assert.Same(t, previous, event.Previous)
+}
+
+func TestUserUpdatePasswordNotPublished(t *testing.T) {
+	previous := &User{ID: "1234", Email: "jack@blah.com", Password: []byte("old-hash"), Version: 1}
+	current := &User{ID: "1234", Email: "jack@blah.com", Password: []byte("new-hash"), Version: 2}
+
+	event := NewUserUpdate(previous, current, "1234")
+	assert.True(t, event.PasswordChanged)
+	assert.Empty(t, event.ChangedFields)
+
+	msg := event.Message()
+	assert.Equal(t, EventUpdated, msg.Type)
+	assert.NotContains(t, msg.Payload, "hash")
+}
+
+func TestServiceLogsNoPersonalData(t *testing.T) {
+	buf := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(redact.NewHandler(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}), nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	svc, err := NewService(&Resources{Repo: NewMemoryRepo(nil)})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	u, err := svc.PutUser(ctx, &User{FirstName: "Jonathan", LastName: "Doe", Email: "jonathan@example.com", CountryCode: "GB", Password: []byte("hunter2")})
+	assert.NoError(t, err)
+	_, err = svc.GetAllUsers(ctx)
+	assert.NoError(t, err)
+	_, err = svc.SearchUsers(ctx, &SearchQuery{NamePrefix: "Jonathan"})
+	assert.NoError(t, err)
+	_, err = svc.GetUserByEmail(ctx, "jonathan@example.com")
+	assert.NoError(t, err)
+
+	logs := buf.String()
+	assert.Contains(t, logs, "j***@example.com")
+	for _, personal := range []string{"jonathan@example.com", "Jonathan", u.ID, "hunter2", string(u.Password)} {
+		assert.NotContains(t, logs, personal)
+	}
+}
+
+type contextRepository struct {
+	BaseRepository
+
+	ctx context.Context
+}
+
+func (repo *contextRepository) GetUser(ctx context.Context, id string) (*User, error) {
+	repo.ctx = ctx
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &User{ID: id}, nil
+}
+
+func TestServiceContextPropagation(t *testing.T) {
+	repo := &contextRepository{}
+	svc, err := NewService(&Resources{Repo: repo, Timeouts: Timeouts{Read: time.Second}})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTimeouts.Write, svc.Timeouts.Write)
+
+	ctx := utils.WithRequestID(context.Background(), "request-1")
+	_, err = svc.GetUser(ctx, "1234")
+	assert.NoError(t, err)
+
+	deadline, ok := repo.ctx.Deadline()
+	assert.True(t, ok, "repository calls are bounded by the read timeout")
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Second)
+	assert.Equal(t, "request-1", utils.RequestID(repo.ctx))
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = svc.GetUser(cancelled, "1234")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := utils.WithCallerIsAdmin(utils.WithCallerID(context.Background(), "admin"), true)
+	repo := NewMemoryRepo(outbox.NewMemoryRepo())
+	auditRepo := audit.NewMemoryRepo()
+	auditService, err := audit.NewService(&audit.Resources{Repo: auditRepo})
+	assert.NoError(t, err)
+
+	svc, err := NewService(&Resources{Repo: repo, Audit: auditService})
+	assert.NoError(t, err)
+
+	u, err := svc.PutUser(ctx, &User{FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.NoError(t, err)
+	_, err = svc.PutUser(ctx, &User{ID: u.ID, FirstName: "Jane", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.NoError(t, err)
+	_, err = svc.SearchUsers(ctx, &SearchQuery{CountryCode: "GB"})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.DeleteUser(ctx, u.ID))
+	_, err = svc.RestoreUser(ctx, u.ID)
+	assert.NoError(t, err)
+
+	entries, err := auditService.GetEntries(ctx, &audit.Query{TargetUserID: u.ID})
+	assert.NoError(t, err)
+
+	actions := []string{}
+	for i, entry := range entries {
+		actions = append(actions, entry.Action)
+		assert.Equal(t, "admin", entry.ActorID)
+		assert.True(t, entry.ActorIsAdmin)
+		assert.NotEqual(t, entry.BeforeHash, entry.AfterHash)
+		if i+1 < len(entries) {
+			assert.Equal(t, entries[i+1].AfterHash, entry.BeforeHash, "hashes chain the versions of the user")
+		}
+	}
+	assert.Equal(t, []string{audit.ActionUserRestored, audit.ActionUserDeleted, audit.ActionUserUpdated, audit.ActionUserCreated}, actions)
+	assert.Empty(t, entries[3].BeforeHash, "created users have no previous version")
+
+	stored, err := repo.GetUser(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, auditHash(stored), entries[0].AfterHash)
+
+	searches, err := auditService.GetEntries(ctx, &audit.Query{Action: audit.ActionUsersSearched})
+	assert.NoError(t, err)
+	assert.Len(t, searches, 1)
+	assert.Contains(t, searches[0].Details, `"CountryCode":"GB"`)
+
+	report, err := audit.Verify(ctx, auditRepo, audit.VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, 5, report.Verified, "every write is chained")
+}
+
+type failingAuditService struct {
+	audit.AuditService
+}
+
+func (failingAuditService) Record(context.Context, *audit.Entry) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestAuditSearches(t *testing.T) {
+	admin := utils.WithCallerIsAdmin(utils.WithCallerID(context.Background(), "admin"), true)
+	auditService, err := audit.NewService(&audit.Resources{Repo: audit.NewMemoryRepo()})
+	assert.NoError(t, err)
+
+	svc, err := NewService(&Resources{Repo: NewMemoryRepo(outbox.NewMemoryRepo()), Audit: auditService})
+	assert.NoError(t, err)
+
+	_, err = svc.SearchUsers(context.Background(), &SearchQuery{})
+	assert.NoError(t, err)
+	_, err = svc.GetUsersByCountry(utils.WithCallerID(context.Background(), "1234"), "GB")
+	assert.NoError(t, err)
+
+	searches, err := auditService.GetEntries(admin, &audit.Query{Action: audit.ActionUsersSearched})
+	assert.NoError(t, err)
+	assert.Empty(t, searches, "only searches by administrators are audited")
+
+	_, err = svc.GetUsersByCountry(admin, "GB")
+	assert.NoError(t, err)
+	searches, err = auditService.GetEntries(admin, &audit.Query{Action: audit.ActionUsersSearched})
+	assert.NoError(t, err)
+	assert.Len(t, searches, 1)
+
+	// an admin search which can't be audited returns no results
+	svc.Audit = failingAuditService{}
+	_, err = svc.SearchUsers(admin, &SearchQuery{})
+	assert.Error(t, err)
+	_, err = svc.GetUsersByCountry(admin, "GB")
+	assert.Error(t, err)
+
+	_, err = svc.SearchUsers(context.Background(), &SearchQuery{})
+	assert.NoError(t, err)
+	_, err = svc.PutUser(admin, &User{FirstName: "John", LastName: "Doe", CountryCode: "GB", Email: "john@example.com"})
+	assert.NoError(t, err, "writes which already happened are not failed")
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *User {
+		return &User{
+			FirstName:   "Mary-Jane",
+			LastName:    "O'Neil",
+			NickName:    "MJ 🚀",
+			Email:       "mary.jane+tag@example.co.uk",
+			CountryCode: "gb",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(u *User)
+		fields []string
+	}{
+		{name: "valid", modify: func(u *User) {}},
+		{name: "accented names", modify: func(u *User) { u.FirstName, u.LastName = "José", "Ó Súilleabháin" }},
+		{name: "reserved country code", modify: func(u *User) { u.CountryCode = "UK" }, fields: []string{"countryCode"}},
+		{name: "alpha-3 country code", modify: func(u *User) { u.CountryCode = "GBR" }, fields: []string{"countryCode"}},
+		{name: "missing names", modify: func(u *User) { u.FirstName, u.LastName = " ", "" }, fields: []string{"firstName", "lastName"}},
+		{name: "digits in name", modify: func(u *User) { u.FirstName = "J0hn" }, fields: []string{"firstName"}},
+		{name: "long name", modify: func(u *User) { u.LastName = strings.Repeat("a", MaxNameLength+1) }, fields: []string{"lastName"}},
+		{name: "long nickname", modify: func(u *User) { u.NickName = strings.Repeat("a", MaxNickNameLength+1) }, fields: []string{"nickName"}},
+		{name: "control character in nickname", modify: func(u *User) { u.NickName = "bad\nnick" }, fields: []string{"nickName"}},
+		{name: "missing email", modify: func(u *User) { u.Email = "" }, fields: []string{"email"}},
+		{name: "email without local part", modify: func(u *User) { u.Email = "@b" }, fields: []string{"email"}},
+		{name: "email with display name", modify: func(u *User) { u.Email = "Mary <mary@example.com>" }, fields: []string{"email"}},
+		{name: "email without dotted domain", modify: func(u *User) { u.Email = "mary@localhost" }, fields: []string{"email"}},
+		{name: "email with two @", modify: func(u *User) { u.Email = "mary@jane@example.com" }, fields: []string{"email"}},
+		{name: "long email local part", modify: func(u *User) { u.Email = strings.Repeat("a", 65) + "@example.com" }, fields: []string{"email"}},
+		{
+			name:   "every invalid field is reported",
+			modify: func(u *User) { u.CountryCode, u.FirstName, u.Email = "", "", "nope" },
+			fields: []string{"countryCode", "firstName", "email"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			u := valid()
+			tc.modify(u)
+
+			err := u.Validate()
+			if len(tc.fields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, utils.ValidationErr)
+
+			var errs utils.ValidationErrors
+			assert.True(t, errors.As(err, &errs))
+
+			fields := []string{}
+			for _, fieldErr := range errs {
+				fields = append(fields, fieldErr.Field)
+			}
+			assert.Equal(t, tc.fields, fields)
+		})
+	}
+}
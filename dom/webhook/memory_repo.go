@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+// MemoryRepository is a thread-safe in-memory subscription store, intended for tests and local development
+type MemoryRepository struct {
+	BaseRepository
+
+	mu            sync.RWMutex
+	subscriptions map[string]Subscription
+	deliveries    []Delivery
+}
+
+func NewMemoryRepo() *MemoryRepository {
+	return &MemoryRepository{subscriptions: map[string]Subscription{}}
+}
+
+func (repo *MemoryRepository) GetSubscription(id string) (*Subscription, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	sub, ok := repo.subscriptions[id]
+	if !ok {
+		return nil, utils.ErrNotFound
+	}
+
+	return &sub, nil
+}
+
+func (repo *MemoryRepository) GetSubscriptions() ([]*Subscription, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	subs := []*Subscription{}
+	for _, sub := range repo.subscriptions {
+		sub := sub
+		subs = append(subs, &sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+
+	return subs, nil
+}
+
+func (repo *MemoryRepository) PutSubscription(sub *Subscription) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.subscriptions[sub.ID] = *sub
+
+	return nil
+}
+
+func (repo *MemoryRepository) DeleteSubscription(id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.subscriptions[id]; !ok {
+		return fmt.Errorf("failed to remove subscription from repo %w", utils.ErrNotFound)
+	}
+	delete(repo.subscriptions, id)
+
+	return nil
+}
+
+func (repo *MemoryRepository) PutDelivery(delivery *Delivery) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.deliveries = append(repo.deliveries, *delivery)
+
+	return nil
+}
+
+func (repo *MemoryRepository) GetDeliveries(subscriptionID string, limit int) ([]*Delivery, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	deliveries := []*Delivery{}
+	for i := len(repo.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if delivery := repo.deliveries[i]; delivery.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (repo *MemoryRepository) HasDelivered(subscriptionID, eventID string) (bool, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, delivery := range repo.deliveries {
+		if delivery.SubscriptionID == subscriptionID && delivery.EventID == eventID && delivery.Success {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deliveryRetention is how long delivery logs are kept before mongo expires them
+const deliveryRetention = 30 * 24 * 60 * 60
+
+type MongoRepository struct {
+	BaseRepository
+
+	Collection           *mongo.Collection
+	DeliveriesCollection *mongo.Collection
+}
+
+type MongoRepoParams struct {
+	Host                     string
+	Database                 string
+	CollectionName           string
+	DeliveriesCollectionName string
+}
+
+func NewMongoRepo(ctx context.Context, params *MongoRepoParams) (*MongoRepository, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(params.Host))
+	if err != nil {
+		return nil, err
+	}
+	database := client.Database(params.Database)
+
+	deliveries := database.Collection(params.DeliveriesCollectionName)
+
+	_, err = deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "subscriptionId", Value: 1}, {Key: "deliveredAt", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "subscriptionId", Value: 1}, {Key: "eventId", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "deliveredAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(deliveryRetention),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook delivery indexes err:%w", err)
+	}
+
+	return &MongoRepository{
+		Collection:           database.Collection(params.CollectionName),
+		DeliveriesCollection: deliveries,
+	}, nil
+}
+
+func (repo *MongoRepository) GetSubscription(id string) (*Subscription, error) {
+	res := repo.Collection.FindOne(context.Background(), bson.M{"_id": id})
+	if res.Err() != nil {
+		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, res.Err()
+	}
+
+	var sub *Subscription
+	err := res.Decode(&sub)
+	if err != nil {
+		err = fmt.Errorf("failed to umarshal bson subscription document err:%w", err)
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (repo *MongoRepository) GetSubscriptions() ([]*Subscription, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := repo.Collection.Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	subs := []*Subscription{}
+	err = cursor.All(context.Background(), &subs)
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+func (repo *MongoRepository) PutSubscription(sub *Subscription) error {
+	opts := options.Replace().SetUpsert(true)
+
+	_, err := repo.Collection.ReplaceOne(context.Background(), bson.M{"_id": sub.ID}, sub, opts)
+	return err
+}
+
+func (repo *MongoRepository) DeleteSubscription(id string) error {
+	res, err := repo.Collection.DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount != 1 {
+		return fmt.Errorf("failed to remove subscription from repo count:%d %w", res.DeletedCount, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *MongoRepository) PutDelivery(delivery *Delivery) error {
+	_, err := repo.DeliveriesCollection.InsertOne(context.Background(), delivery)
+	return err
+}
+
+func (repo *MongoRepository) GetDeliveries(subscriptionID string, limit int) ([]*Delivery, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "deliveredAt", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := repo.DeliveriesCollection.Find(context.Background(), bson.M{"subscriptionId": subscriptionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	deliveries := []*Delivery{}
+	err = cursor.All(context.Background(), &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (repo *MongoRepository) HasDelivered(subscriptionID, eventID string) (bool, error) {
+	filter := bson.M{"subscriptionId": subscriptionID, "eventId": eventID, "success": true}
+
+	count, err := repo.DeliveriesCollection.CountDocuments(context.Background(), filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
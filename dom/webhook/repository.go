@@ -0,0 +1,47 @@
+package webhook
+
+import "fmt"
+
+var NotImplementedErr = fmt.Errorf("this method is not implemented")
+
+type Repository interface {
+	GetSubscription(string) (*Subscription, error)
+	GetSubscriptions() ([]*Subscription, error)
+	PutSubscription(*Subscription) error
+	DeleteSubscription(string) error
+	PutDelivery(*Delivery) error
+	// GetDeliveries returns the most recent deliveries of a subscription, newest first
+	GetDeliveries(subscriptionID string, limit int) ([]*Delivery, error)
+	// HasDelivered reports whether the event was already delivered successfully to the subscription
+	HasDelivered(subscriptionID, eventID string) (bool, error)
+}
+
+type BaseRepository struct{}
+
+func (repo *BaseRepository) GetSubscription(string) (*Subscription, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) GetSubscriptions() ([]*Subscription, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) PutSubscription(*Subscription) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) DeleteSubscription(string) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) PutDelivery(*Delivery) error {
+	return NotImplementedErr
+}
+
+func (repo *BaseRepository) GetDeliveries(string, int) ([]*Delivery, error) {
+	return nil, NotImplementedErr
+}
+
+func (repo *BaseRepository) HasDelivered(string, string) (bool, error) {
+	return false, NotImplementedErr
+}
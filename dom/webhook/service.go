@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+type WebhookService interface {
+	CreateSubscription(*Subscription) (*Subscription, error)
+	GetSubscription(string) (*Subscription, error)
+	GetSubscriptions() ([]*Subscription, error)
+	UpdateSubscription(*Subscription) (*Subscription, error)
+	DeleteSubscription(string) error
+	GetDeliveries(subscriptionID string, limit int) ([]*Delivery, error)
+	SendTestEvent(subscriptionID string) (*Delivery, error)
+}
+
+type Resources struct {
+	Repo   Repository
+	Client *http.Client
+}
+
+type service struct {
+	*Resources
+}
+
+func NewService(r *Resources) (*service, error) {
+	if r.Client == nil {
+		r.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &service{
+		Resources: r,
+	}, nil
+}
+
+// CreateSubscription registers a new endpoint and generates its signing secret,
+// the secret is only ever returned by this call
+func (svc *service) CreateSubscription(sub *Subscription) (*Subscription, error) {
+	logEntry := slog.With("url", sub.URL)
+	logEntry.Info("call CreateSubscription")
+
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sub.ID = uuid.NewString()
+	sub.Secret = secret
+	sub.Active = true
+	sub.CreatedAt = time.Now().UTC()
+
+	err = svc.Repo.PutSubscription(sub)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to put subscription into repository")
+
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (svc *service) GetSubscription(id string) (*Subscription, error) {
+	sub, err := svc.Repo.GetSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub.Redacted(), nil
+}
+
+func (svc *service) GetSubscriptions() ([]*Subscription, error) {
+	subs, err := svc.Repo.GetSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, sub := range subs {
+		subs[i] = sub.Redacted()
+	}
+
+	return subs, nil
+}
+
+// UpdateSubscription changes the url, event filters and active flag of a subscription while keeping its secret
+func (svc *service) UpdateSubscription(sub *Subscription) (*Subscription, error) {
+	logEntry := slog.With("subscription-id", sub.ID)
+	logEntry.Info("call UpdateSubscription")
+
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := svc.Repo.GetSubscription(sub.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing.URL = sub.URL
+	existing.Events = sub.Events
+	existing.Active = sub.Active
+
+	err = svc.Repo.PutSubscription(existing)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to put subscription into repository")
+
+		return nil, err
+	}
+
+	return existing.Redacted(), nil
+}
+
+func (svc *service) DeleteSubscription(id string) error {
+	return svc.Repo.DeleteSubscription(id)
+}
+
+func (svc *service) GetDeliveries(subscriptionID string, limit int) ([]*Delivery, error) {
+	if _, err := svc.Repo.GetSubscription(subscriptionID); err != nil {
+		return nil, err
+	}
+
+	return svc.Repo.GetDeliveries(subscriptionID, limit)
+}
+
+// SendTestEvent synchronously delivers a TEST event to the subscription, regardless of its filters
+func (svc *service) SendTestEvent(subscriptionID string) (*Delivery, error) {
+	sub, err := svc.Repo.GetSubscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	eventID := uuid.NewString()
+	payload := utils.ToJSON(map[string]any{
+		"ID":        eventID,
+		"Status":    TestEventType,
+		"CreatedAt": time.Now().UTC(),
+	})
+
+	return svc.deliver(sub, eventID, TestEventType, payload), nil
+}
+
+// Publish fans an outbox message out to every active subscription accepting its type.
+// Subscriptions that already received the event are skipped on retries,
+// an error is returned while any delivery is still failing so the outbox retries it
+func (svc *service) Publish(msg *outbox.Message) error {
+	subs, err := svc.Repo.GetSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, sub := range subs {
+		if !sub.Active || !sub.Accepts(msg.Type) {
+			continue
+		}
+
+		delivered, err := svc.Repo.HasDelivered(sub.ID, msg.ID)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			continue
+		}
+
+		delivery := svc.deliver(sub, msg.ID, msg.Type, msg.Payload)
+		if !delivery.Success {
+			failed = append(failed, sub.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to deliver event to subscriptions %s", strings.Join(failed, ","))
+	}
+
+	return nil
+}
+
+func (svc *service) deliver(sub *Subscription, eventID, eventType, payload string) *Delivery {
+	logEntry := slog.
+		With("subscription-id", sub.ID).
+		With("event-id", eventID).
+		With("event-type", eventType)
+
+	start := time.Now().UTC()
+	delivery := &Delivery{
+		ID:             uuid.NewString(),
+		SubscriptionID: sub.ID,
+		EventID:        eventID,
+		EventType:      eventType,
+		DeliveredAt:    start,
+	}
+
+	statusCode, err := svc.post(sub, eventID, eventType, []byte(payload), start)
+	delivery.Duration = time.Since(start).String()
+	delivery.StatusCode = statusCode
+	if err != nil {
+		delivery.Error = err.Error()
+
+		logEntry.
+			With("error", err).
+			Warn("failed to deliver webhook")
+	} else {
+		delivery.Success = true
+
+		logEntry.Debug("delivered webhook")
+	}
+
+	err = svc.Repo.PutDelivery(delivery)
+	if err != nil {
+		logEntry.
+			With("error", err).
+			Error("failed to put webhook delivery log into repository")
+	}
+
+	return delivery
+}
+
+func (svc *service) post(sub *Subscription, eventID, eventType string, payload []byte, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(outbox.EVENT_ID_HEADER, eventID)
+	req.Header.Set(outbox.EVENT_TYPE_HEADER, eventType)
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SIGNATURE_HEADER, Sign(sub.Secret, timestamp, payload))
+
+	resp, err := svc.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	subscriptionColumns = `id, url, secret, events, active, created_by, created_at`
+	deliveryColumns     = `id, subscription_id, event_id, event_type, status_code, error, success, duration, delivered_at`
+)
+
+// SQLRepository stores subscriptions in the 'webhooks' table and their delivery log in 'webhook_deliveries',
+// the event filter of a subscription is stored as a JSON array
+type SQLRepository struct {
+	BaseRepository
+
+	DB *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepository {
+	return &SQLRepository{DB: db}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	sub := &Subscription{}
+	var events string
+	err := row.Scan(
+		&sub.ID,
+		&sub.URL,
+		&sub.Secret,
+		&events,
+		&sub.Active,
+		&sub.CreatedBy,
+		&sub.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(events), &sub.Events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal subscription events err:%w", err)
+	}
+
+	return sub, nil
+}
+
+func (repo *SQLRepository) GetSubscription(id string) (*Subscription, error) {
+	row := repo.DB.QueryRowContext(context.Background(), `SELECT `+subscriptionColumns+` FROM webhooks WHERE id = $1`, id)
+
+	sub, err := scanSubscription(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to scan subscription row err:%w", err)
+	}
+
+	return sub, nil
+}
+
+func (repo *SQLRepository) GetSubscriptions() ([]*Subscription, error) {
+	rows, err := repo.DB.QueryContext(context.Background(), `SELECT `+subscriptionColumns+` FROM webhooks ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan subscription row err:%w", err)
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+func (repo *SQLRepository) PutSubscription(sub *Subscription) error {
+	events := sub.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	_, err := repo.DB.ExecContext(context.Background(), `INSERT INTO webhooks (`+subscriptionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET
+			url = excluded.url,
+			secret = excluded.secret,
+			events = excluded.events,
+			active = excluded.active,
+			created_by = excluded.created_by,
+			created_at = excluded.created_at`,
+		sub.ID,
+		sub.URL,
+		sub.Secret,
+		utils.ToJSON(events),
+		sub.Active,
+		sub.CreatedBy,
+		sub.CreatedAt,
+	)
+
+	return err
+}
+
+func (repo *SQLRepository) DeleteSubscription(id string) error {
+	res, err := repo.DB.ExecContext(context.Background(), `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("failed to remove subscription from repo count:%d %w", count, utils.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *SQLRepository) PutDelivery(delivery *Delivery) error {
+	_, err := repo.DB.ExecContext(context.Background(), `INSERT INTO webhook_deliveries (`+deliveryColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		delivery.ID,
+		delivery.SubscriptionID,
+		delivery.EventID,
+		delivery.EventType,
+		delivery.StatusCode,
+		delivery.Error,
+		delivery.Success,
+		delivery.Duration,
+		delivery.DeliveredAt,
+	)
+
+	return err
+}
+
+func (repo *SQLRepository) GetDeliveries(subscriptionID string, limit int) ([]*Delivery, error) {
+	rows, err := repo.DB.QueryContext(context.Background(), `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE subscription_id = $1 ORDER BY delivered_at DESC LIMIT $2`,
+		subscriptionID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		delivery := &Delivery{}
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.SubscriptionID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.StatusCode,
+			&delivery.Error,
+			&delivery.Success,
+			&delivery.Duration,
+			&delivery.DeliveredAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delivery row err:%w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (repo *SQLRepository) HasDelivered(subscriptionID, eventID string) (bool, error) {
+	var count int
+	err := repo.DB.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM webhook_deliveries
+		WHERE subscription_id = $1 AND event_id = $2 AND success = TRUE`,
+		subscriptionID, eventID,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
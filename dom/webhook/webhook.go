@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackmcguire1/UserService/dom/user"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+)
+
+const (
+	SIGNATURE_HEADER = "X-Webhook-Signature"
+	TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+
+	TestEventType = "TEST"
+)
+
+// EventTypes are the user event types a subscription can filter on
+var EventTypes = user.EventTypes
+
+var InvalidSignatureErr = fmt.Errorf("invalid webhook signature")
+
+type Subscription struct {
+	ID        string    `json:"id" bson:"_id"`
+	URL       string    `json:"url" bson:"url"`
+	Secret    string    `json:"secret,omitempty" bson:"secret"`
+	Events    []string  `json:"events" bson:"events"`
+	Active    bool      `json:"active" bson:"active"`
+	CreatedBy string    `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Delivery is the log entry of a single attempt to deliver an event to a subscription
+type Delivery struct {
+	ID             string    `json:"id" bson:"_id"`
+	SubscriptionID string    `json:"subscriptionId" bson:"subscriptionId"`
+	EventID        string    `json:"eventId" bson:"eventId"`
+	EventType      string    `json:"eventType" bson:"eventType"`
+	StatusCode     int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error          string    `json:"error,omitempty" bson:"error,omitempty"`
+	Success        bool      `json:"success" bson:"success"`
+	Duration       string    `json:"duration" bson:"duration"`
+	DeliveredAt    time.Time `json:"deliveredAt" bson:"deliveredAt"`
+}
+
+// Accepts reports whether the subscription wants events of the given type,
+// subscriptions without filters receive every event
+func (sub *Subscription) Accepts(eventType string) bool {
+	if eventType == TestEventType {
+		return true
+	}
+
+	return len(sub.Events) == 0 || slices.Contains(sub.Events, eventType)
+}
+
+func (sub *Subscription) Validate() error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%w - please enter a valid http(s) webhook url", utils.ValidationErr)
+	}
+
+	for _, event := range sub.Events {
+		if !slices.Contains(EventTypes, event) {
+			return fmt.Errorf("%w - unknown event type %q, must be one of %s", utils.ValidationErr, event, strings.Join(EventTypes, ","))
+		}
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the subscription without its signing secret
+func (sub *Subscription) Redacted() *Subscription {
+	redacted := *sub
+	redacted.Secret = ""
+
+	return &redacted
+}
+
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret err:%w", err)
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value for the payload,
+// the HMAC-SHA256 covers "<timestamp>.<payload>" so a captured delivery cannot be replayed later with a new timestamp
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a delivery,
+// receivers should reject deliveries older than the tolerance
+func Verify(secret, signature, timestamp string, payload []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w - malformed timestamp", InvalidSignatureErr)
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w - timestamp outside of tolerance", InvalidSignatureErr)
+	}
+
+	expected := Sign(secret, ts, payload)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return InvalidSignatureErr
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackmcguire1/UserService/dom/outbox"
+	"github.com/jackmcguire1/UserService/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	payload := []byte(`{"ID":"1234"}`)
+	now := time.Now().Unix()
+	ts := strconv.FormatInt(now, 10)
+	signature := Sign("secret", now, payload)
+
+	assert.NoError(t, Verify("secret", signature, ts, payload, time.Minute))
+	assert.ErrorIs(t, Verify("other", signature, ts, payload, time.Minute), InvalidSignatureErr)
+	assert.ErrorIs(t, Verify("secret", signature, ts, []byte(`{"ID":"5678"}`), time.Minute), InvalidSignatureErr)
+
+	old := now - 600
+	assert.ErrorIs(t, Verify("secret", Sign("secret", old, payload), strconv.FormatInt(old, 10), payload, time.Minute), InvalidSignatureErr)
+	assert.ErrorIs(t, Verify("secret", signature, "not a timestamp", payload, time.Minute), InvalidSignatureErr)
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	assert.NoError(t, (&Subscription{URL: "https://example.com/hook"}).Validate())
+	assert.NoError(t, (&Subscription{URL: "https://example.com/hook", Events: []string{"DELETED"}}).Validate())
+	assert.ErrorIs(t, (&Subscription{URL: "ftp://example.com"}).Validate(), utils.ValidationErr)
+	assert.ErrorIs(t, (&Subscription{URL: "https://example.com", Events: []string{"UNKNOWN"}}).Validate(), utils.ValidationErr)
+
+	sub := &Subscription{Events: []string{"DELETED"}}
+	assert.True(t, sub.Accepts("DELETED"))
+	assert.False(t, sub.Accepts("CREATED"))
+	assert.True(t, sub.Accepts(TestEventType))
+	assert.True(t, (&Subscription{}).Accepts("CREATED"))
+}
+
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	rcv.received = append(rcv.received, r)
+	rcv.bodies = append(rcv.bodies, body)
+	w.WriteHeader(rcv.status)
+}
+
+func TestPublish(t *testing.T) {
+	ok := &receiver{status: http.StatusOK}
+	okServer := httptest.NewServer(ok)
+	defer okServer.Close()
+
+	failing := &receiver{status: http.StatusInternalServerError}
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+
+	filtered := &receiver{status: http.StatusOK}
+	filteredServer := httptest.NewServer(filtered)
+	defer filteredServer.Close()
+
+	repo := NewMemoryRepo()
+	svc, err := NewService(&Resources{Repo: repo})
+	assert.NoError(t, err)
+
+	okSub, err := svc.CreateSubscription(&Subscription{URL: okServer.URL})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, okSub.Secret)
+	failingSub, err := svc.CreateSubscription(&Subscription{URL: failingServer.URL})
+	assert.NoError(t, err)
+	_, err = svc.CreateSubscription(&Subscription{URL: filteredServer.URL, Events: []string{"DELETED"}})
+	assert.NoError(t, err)
+
+	msg := &outbox.Message{ID: "event-1", Type: "CREATED", Payload: `{"ID":"1234"}`}
+	assert.Error(t, svc.Publish(msg), "a failing subscription makes the outbox retry the event")
+
+	assert.Len(t, ok.received, 1)
+	assert.Len(t, failing.received, 1)
+	assert.Len(t, filtered.received, 0)
+
+	r := ok.received[0]
+	assert.Equal(t, "event-1", r.Header.Get(outbox.EVENT_ID_HEADER))
+	assert.Equal(t, "CREATED", r.Header.Get(outbox.EVENT_TYPE_HEADER))
+	assert.NoError(t, Verify(okSub.Secret, r.Header.Get(SIGNATURE_HEADER), r.Header.Get(TIMESTAMP_HEADER), ok.bodies[0], time.Minute))
+
+	// on retry only the subscription that failed receives the event again
+	failing.mu.Lock()
+	failing.status = http.StatusOK
+	failing.mu.Unlock()
+	assert.NoError(t, svc.Publish(msg))
+	assert.Len(t, ok.received, 1)
+	assert.Len(t, failing.received, 2)
+
+	deliveries, err := svc.GetDeliveries(failingSub.ID, 10)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 2)
+}
+
+func TestSendTestEvent(t *testing.T) {
+	rcv := &receiver{status: http.StatusNoContent}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	svc, err := NewService(&Resources{Repo: NewMemoryRepo()})
+	assert.NoError(t, err)
+
+	sub, err := svc.CreateSubscription(&Subscription{URL: server.URL, Events: []string{"DELETED"}})
+	assert.NoError(t, err)
+
+	delivery, err := svc.SendTestEvent(sub.ID)
+	assert.NoError(t, err)
+	assert.True(t, delivery.Success)
+	assert.Equal(t, http.StatusNoContent, delivery.StatusCode)
+	assert.Equal(t, TestEventType, rcv.received[0].Header.Get(outbox.EVENT_TYPE_HEADER))
+
+	fetched, err := svc.GetSubscription(sub.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, fetched.Secret, "secrets are only returned on creation")
+
+	_, err = svc.SendTestEvent("unknown")
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+}
@@ -0,0 +1,229 @@
+// Package fieldcrypt encrypts single fields of a record with envelope encryption, every record has its own data key
+// which is wrapped by a versioned key encryption key of a KeyProvider. Blind indexes let encrypted fields still be
+// looked up by equality
+package fieldcrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DataKeySize is the size of the AES-256 data and key encryption keys
+	DataKeySize = 32
+	// MinIndexKeySize is the smallest HMAC key accepted for blind indexes
+	MinIndexKeySize = 32
+
+	// ciphertextPrefix tags encrypted values with the format they were encrypted in
+	ciphertextPrefix = "enc1:"
+)
+
+// KeyProvider wraps data keys with versioned key encryption keys which never leave the provider,
+// LocalKeyProvider keeps them in a key file while a KMS backed provider would call the KMS encrypt and decrypt APIs.
+// Providers calling out to a KMS should cache unwrapped data keys, every read of an encrypted record unwraps its key
+type KeyProvider interface {
+	// CurrentKeyID is the version of the key new data keys are wrapped with
+	CurrentKeyID() string
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Cipher creates and opens the data keys of records and computes blind indexes
+type Cipher struct {
+	keys     KeyProvider
+	indexKey []byte
+}
+
+// NewCipher wraps data keys with the provider and keys blind indexes with indexKey,
+// the index key cannot be rotated without recomputing every stored index
+func NewCipher(keys KeyProvider, indexKey []byte) (*Cipher, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("a key provider is required")
+	}
+	if len(indexKey) < MinIndexKeySize {
+		return nil, fmt.Errorf("the blind index key must be at least %d bytes", MinIndexKeySize)
+	}
+
+	return &Cipher{keys: keys, indexKey: indexKey}, nil
+}
+
+// DataKey is the plaintext data key of a record and the Envelope it is stored as,
+// "<key id>:<base64 wrapped key>" so the version of the key encryption key is known without unwrapping it
+type DataKey struct {
+	Key      []byte
+	Envelope string
+}
+
+// NewDataKey generates a data key wrapped with the current key
+func (c *Cipher) NewDataKey(ctx context.Context) (*DataKey, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	envelope, err := c.wrap(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataKey{Key: key, Envelope: envelope}, nil
+}
+
+// OpenDataKey unwraps the data key stored as envelope
+func (c *Cipher) OpenDataKey(ctx context.Context, envelope string) (*DataKey, error) {
+	keyID, encoded, ok := strings.Cut(envelope, ":")
+	if !ok {
+		return nil, fmt.Errorf("data key envelope is missing its key id")
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data key envelope err:%w", err)
+	}
+
+	key, err := c.keys.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %q err:%w", keyID, err)
+	}
+
+	return &DataKey{Key: key, Envelope: envelope}, nil
+}
+
+// Rewrap wraps the data key of the envelope with the current key, the data key itself and therefore every value
+// encrypted with it stay the same. Envelopes already wrapped with the current key are returned as they are
+func (c *Cipher) Rewrap(ctx context.Context, envelope string) (string, error) {
+	if c.Current(envelope) {
+		return envelope, nil
+	}
+
+	key, err := c.OpenDataKey(ctx, envelope)
+	if err != nil {
+		return "", err
+	}
+
+	return c.wrap(ctx, key.Key)
+}
+
+// Current reports whether the envelope is wrapped with the current key
+func (c *Cipher) Current(envelope string) bool {
+	return KeyID(envelope) == c.keys.CurrentKeyID()
+}
+
+func (c *Cipher) wrap(ctx context.Context, key []byte) (string, error) {
+	keyID := c.keys.CurrentKeyID()
+	wrapped, err := c.keys.WrapKey(ctx, keyID, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key with key %q err:%w", keyID, err)
+	}
+
+	return keyID + ":" + base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// BlindIndex is the hex HMAC-SHA256 of the value, equal values have equal indexes
+// but the value cannot be recovered from its index without the index key
+func (c *Cipher) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// KeyID is the version of the key encryption key which wrapped the envelope
+func KeyID(envelope string) string {
+	keyID, _, _ := strings.Cut(envelope, ":")
+
+	return keyID
+}
+
+// Encrypt seals the plaintext with AES-256-GCM, the additional data binds the ciphertext to where it is stored
+// so it cannot be moved to another record or field. Empty values stay empty
+func (k *DataKey) Encrypt(plaintext, additionalData string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	sealed, err := seal(k.Key, []byte(plaintext), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+
+	return ciphertextPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with the same additional data
+func (k *DataKey) Decrypt(value, additionalData string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	encoded, ok := strings.CutPrefix(value, ciphertextPrefix)
+	if !ok {
+		return "", fmt.Errorf("value is not encrypted")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ciphertext err:%w", err)
+	}
+
+	plaintext, err := open(k.Key, sealed, []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// seal encrypts with AES-GCM and prepends the random nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt err:%w", err)
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("keys must be %d bytes", DataKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
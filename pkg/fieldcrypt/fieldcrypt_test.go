@@ -0,0 +1,148 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, DataKeySize)
+}
+
+func newTestCipher(t *testing.T, current string) *Cipher {
+	keys, err := NewLocalKeyProvider(current, map[string][]byte{"1": testKey(1), "2": testKey(2)}, testKey(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCipher(keys, keys.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCipher(t, "1")
+
+	key, err := c.NewDataKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", KeyID(key.Envelope))
+
+	ciphertext, err := key.Encrypt("john@example.com", "user-1.email")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ciphertext, ciphertextPrefix))
+	assert.NotContains(t, ciphertext, "john")
+
+	again, err := key.Encrypt("john@example.com", "user-1.email")
+	assert.NoError(t, err)
+	assert.NotEqual(t, ciphertext, again, "encryption is randomized")
+
+	opened, err := c.OpenDataKey(ctx, key.Envelope)
+	assert.NoError(t, err)
+
+	plaintext, err := opened.Decrypt(ciphertext, "user-1.email")
+	assert.NoError(t, err)
+	assert.Equal(t, "john@example.com", plaintext)
+
+	_, err = opened.Decrypt(ciphertext, "user-2.email")
+	assert.Error(t, err, "ciphertexts are bound to their additional data")
+
+	_, err = opened.Decrypt("john@example.com", "user-1.email")
+	assert.Error(t, err)
+
+	empty, err := key.Encrypt("", "user-1.nickName")
+	assert.NoError(t, err)
+	assert.Equal(t, "", empty)
+}
+
+func TestRewrap(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := newTestCipher(t, "1").NewDataKey(ctx)
+	assert.NoError(t, err)
+	ciphertext, err := key.Encrypt("John", "user-1.firstName")
+	assert.NoError(t, err)
+
+	rotated := newTestCipher(t, "2")
+	assert.False(t, rotated.Current(key.Envelope))
+
+	envelope, err := rotated.Rewrap(ctx, key.Envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", KeyID(envelope))
+	assert.True(t, rotated.Current(envelope))
+
+	opened, err := rotated.OpenDataKey(ctx, envelope)
+	assert.NoError(t, err)
+	plaintext, err := opened.Decrypt(ciphertext, "user-1.firstName")
+	assert.NoError(t, err)
+	assert.Equal(t, "John", plaintext)
+
+	unchanged, err := rotated.Rewrap(ctx, envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, envelope, unchanged)
+}
+
+func TestOpenDataKeyFailures(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCipher(t, "1")
+
+	key, err := c.NewDataKey(ctx)
+	assert.NoError(t, err)
+
+	for name, envelope := range map[string]string{
+		"no key id":   strings.TrimPrefix(key.Envelope, "1:"),
+		"unknown key": "3" + strings.TrimPrefix(key.Envelope, "1"),
+		"wrong key":   "2" + strings.TrimPrefix(key.Envelope, "1"),
+		"not base64":  "1:%%%",
+	} {
+		_, err := c.OpenDataKey(ctx, envelope)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	c := newTestCipher(t, "1")
+
+	index := c.BlindIndex("john@example.com")
+	assert.Len(t, index, 64)
+	assert.Equal(t, index, c.BlindIndex("john@example.com"))
+	assert.NotEqual(t, index, c.BlindIndex("jane@example.com"))
+
+	other, err := NewCipher(&LocalKeyProvider{}, testKey(8))
+	assert.NoError(t, err)
+	assert.NotEqual(t, index, other.BlindIndex("john@example.com"))
+
+	_, err = NewCipher(&LocalKeyProvider{}, []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestParseKeyFile(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+
+	keys, err := ParseKeyFile([]byte(fmt.Sprintf(`{"currentKeyId":"2","keys":{"1":%q,"2":%q},"indexKey":%q}`,
+		encode(testKey(1)), encode(testKey(2)), encode(testKey(9)),
+	)))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", keys.CurrentKeyID())
+	assert.Equal(t, testKey(9), keys.IndexKey())
+
+	for name, data := range map[string]string{
+		"not json":        `keys`,
+		"missing current": fmt.Sprintf(`{"currentKeyId":"2","keys":{"1":%q}}`, encode(testKey(1))),
+		"short key":       fmt.Sprintf(`{"currentKeyId":"1","keys":{"1":%q}}`, encode([]byte("short"))),
+		"colon in id":     fmt.Sprintf(`{"currentKeyId":"a:b","keys":{"a:b":%q}}`, encode(testKey(1))),
+		"not base64":      `{"currentKeyId":"1","keys":{"1":"%%%"}}`,
+	} {
+		_, err := ParseKeyFile([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
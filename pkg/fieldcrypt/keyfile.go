@@ -0,0 +1,103 @@
+package fieldcrypt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// KeyFile is the JSON layout of the key file of a LocalKeyProvider, keys are base64 encoded 32 byte AES keys.
+// A key is rotated by adding it under a new id and making it current, older keys stay in the file
+// until every data key wrapped with them has been rewrapped
+type KeyFile struct {
+	CurrentKeyID string            `json:"currentKeyId"`
+	Keys         map[string]string `json:"keys"`
+	IndexKey     string            `json:"indexKey"`
+}
+
+// LocalKeyProvider wraps data keys with key encryption keys held in memory, meant for development and
+// deployments without a KMS
+type LocalKeyProvider struct {
+	current  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// NewLocalKeyProvider wraps new data keys with the key of the current id
+func NewLocalKeyProvider(current string, keys map[string][]byte, indexKey []byte) (*LocalKeyProvider, error) {
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("key id %q must be non empty and must not contain ':'", id)
+		}
+		if len(key) != DataKeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes", id, DataKeySize)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not one of the keys", current)
+	}
+
+	return &LocalKeyProvider{current: current, keys: keys, indexKey: indexKey}, nil
+}
+
+// ParseKeyFile reads a LocalKeyProvider from the JSON of a KeyFile
+func ParseKeyFile(data []byte) (*LocalKeyProvider, error) {
+	file := &KeyFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file err:%w", err)
+	}
+
+	keys := map[string][]byte{}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q err:%w", id, err)
+		}
+		keys[id] = key
+	}
+
+	indexKey, err := base64.StdEncoding.DecodeString(file.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode index key err:%w", err)
+	}
+
+	return NewLocalKeyProvider(file.CurrentKeyID, keys, indexKey)
+}
+
+// IndexKey is the blind index key of the key file
+func (p *LocalKeyProvider) IndexKey() []byte {
+	return p.indexKey
+}
+
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	return p.current
+}
+
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(key, dataKey, []byte(keyID))
+}
+
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(key, wrapped, []byte(keyID))
+}
+
+func (p *LocalKeyProvider) key(keyID string) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	return key, nil
+}
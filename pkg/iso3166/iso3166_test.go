@@ -0,0 +1,22 @@
+package iso3166
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	assert.Len(t, countries, 249)
+
+	assert.True(t, Valid("GB"))
+	assert.True(t, Valid("gb"))
+	assert.Equal(t, "United Kingdom", Name("gb"))
+
+	// 'UK' is exceptionally reserved rather than assigned
+	assert.False(t, Valid("UK"))
+	assert.False(t, Valid("XX"))
+	assert.False(t, Valid("GBR"))
+	assert.False(t, Valid(""))
+	assert.Empty(t, Name("XX"))
+}
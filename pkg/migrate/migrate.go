@@ -0,0 +1,185 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Load reads the NNNN_name.sql files of dir ordered by version
+func Load(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []*Migration{}
+	versions := map[int]string{}
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		if existing, ok := versions[version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d in %s and %s", version, existing, entry.Name())
+		}
+		versions[version] = entry.Name()
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, &Migration{
+			Version: version,
+			Name:    match[2],
+			SQL:     string(data),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Runner applies migrations in version order, recording each one in the schema_migrations table
+type Runner struct {
+	DB         *sql.DB
+	Migrations []*Migration
+	// Lock is executed first in every migration transaction so concurrent runners, e.g. replicas starting
+	// at the same time, apply each migration once
+	Lock   string
+	Logger *slog.Logger
+}
+
+// Up applies every pending migration, each in its own transaction, and returns the ones it applied
+func (r *Runner) Up(ctx context.Context) ([]*Migration, error) {
+	err := r.createTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []*Migration{}
+	for _, m := range r.Migrations {
+		ok, err := r.apply(ctx, m)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d_%s err:%w", m.Version, m.Name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		applied = append(applied, m)
+		if r.Logger != nil {
+			r.Logger.
+				With("version", m.Version).
+				With("name", m.Name).
+				Info("applied migration")
+		}
+	}
+
+	return applied, nil
+}
+
+// Pending returns the migrations which have not been applied yet
+func (r *Runner) Pending(ctx context.Context) ([]*Migration, error) {
+	err := r.createTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		done[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := []*Migration{}
+	for _, m := range r.Migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+func (r *Runner) createTable(ctx context.Context) error {
+	_, err := r.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table err:%w", err)
+	}
+
+	return nil
+}
+
+func (r *Runner) apply(ctx context.Context, m *Migration) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if r.Lock != "" {
+		_, err = tx.ExecContext(ctx, r.Lock)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, m.Version).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists > 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, m.SQL)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		m.Version, m.Name, time.Now().UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,37 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_add_index.sql":     {Data: []byte("CREATE INDEX a ON b (c);")},
+		"sql/0001_create_tables.sql": {Data: []byte("CREATE TABLE b (c TEXT);")},
+		"sql/0010_later.sql":         {Data: []byte("SELECT 1;")},
+		"sql/README.md":              {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys, "sql")
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 3)
+
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "create_tables", migrations[0].Name)
+	assert.Equal(t, "CREATE TABLE b (c TEXT);", migrations[0].SQL)
+	assert.Equal(t, 2, migrations[1].Version)
+	assert.Equal(t, 10, migrations[2].Version)
+}
+
+func TestLoadDuplicateVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0001_create_tables.sql": {Data: []byte("SELECT 1;")},
+		"sql/001_other.sql":          {Data: []byte("SELECT 2;")},
+	}
+
+	_, err := Load(fsys, "sql")
+	assert.ErrorContains(t, err, "duplicate migration version 1")
+}
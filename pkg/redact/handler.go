@@ -0,0 +1,81 @@
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// Handler redacts tagged values and attributes with sensitive keys, at any depth of groups and LogValuers,
+// before passing records on to the next handler
+type Handler struct {
+	next   slog.Handler
+	policy *Policy
+}
+
+func NewHandler(next slog.Handler, policy *Policy) *Handler {
+	if policy == nil {
+		policy = DefaultPolicy()
+	}
+
+	return &Handler{next: next, policy: policy}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		if a, ok := h.redact(a); ok {
+			redacted.AddAttrs(a)
+		}
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{next: h.next.WithAttrs(h.redactAll(attrs)), policy: h.policy}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), policy: h.policy}
+}
+
+func (h *Handler) redactAll(attrs []slog.Attr) []slog.Attr {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a, ok := h.redact(a); ok {
+			redacted = append(redacted, a)
+		}
+	}
+
+	return redacted
+}
+
+// redact returns the attribute with its value redacted, it reports false when the attribute must be dropped
+func (h *Handler) redact(a slog.Attr) (slog.Attr, bool) {
+	class, sensitive := h.policy.Keys[strings.ToLower(a.Key)]
+
+	v := a.Value
+	if v.Kind() == slog.KindLogValuer {
+		if tagged, ok := v.LogValuer().(Tagged); ok {
+			class, sensitive = tagged.Class, true
+			v = slog.AnyValue(tagged.Value)
+		}
+		v = v.Resolve()
+	}
+
+	if v.Kind() == slog.KindGroup {
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(h.redactAll(v.Group())...)}, true
+	}
+	if !sensitive {
+		return slog.Attr{Key: a.Key, Value: v}, true
+	}
+
+	v, ok := h.policy.apply(class, v)
+	return slog.Attr{Key: a.Key, Value: v}, ok
+}
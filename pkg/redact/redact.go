@@ -0,0 +1,161 @@
+// Package redact keeps personal data out of logs, see Handler
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// Action is what the Handler does with a sensitive value
+type Action string
+
+const (
+	ActionKeep Action = "keep"
+	// ActionMask keeps the first character, and the domain of emails, e.g. j***@example.com
+	ActionMask Action = "mask"
+	// ActionHash replaces the value by a keyed hash, so log lines of the same value can still be correlated
+	ActionHash Action = "hash"
+	ActionDrop Action = "drop"
+)
+
+// Class groups sensitive values which are redacted the same way
+type Class string
+
+const (
+	ClassEmail  Class = "email"
+	ClassName   Class = "name"
+	ClassID     Class = "id"
+	ClassSecret Class = "secret"
+)
+
+// Masked replaces tagged values logged without a Handler, so they never leak in plain text
+const Masked = "[REDACTED]"
+
+// Policy decides how the Handler redacts each class of sensitive values,
+// values of a class without an action are masked
+type Policy struct {
+	Actions map[Class]Action
+	// Keys classifies untagged attributes by their key, e.g. With("email", email)
+	Keys map[string]Class
+	// HashKey keys the HMAC of hashed values, without it hashes of guessable values can be reversed
+	HashKey []byte
+}
+
+// DefaultPolicy masks emails and names, hashes IDs and drops secrets
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Actions: map[Class]Action{
+			ClassEmail:  ActionMask,
+			ClassName:   ActionMask,
+			ClassID:     ActionHash,
+			ClassSecret: ActionDrop,
+		},
+		Keys: map[string]Class{
+			"email":            ClassEmail,
+			"normalized-email": ClassEmail,
+			"password":         ClassSecret,
+			"token":            ClassSecret,
+			"user-id":          ClassID,
+			"caller-id":        ClassID,
+			"target-user-id":   ClassID,
+			"kept-user-id":     ClassID,
+			"user-ids":         ClassID,
+			"userid":           ClassID,
+		},
+	}
+}
+
+// Set overrides the actions of the policy from a comma separated list of class=action pairs, e.g. "id=keep,name=drop"
+func (p *Policy) Set(spec string) error {
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		class, action, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("redaction %q is not a class=action pair", pair)
+		}
+
+		switch a := Action(strings.ToLower(strings.TrimSpace(action))); a {
+		case ActionKeep, ActionMask, ActionHash, ActionDrop:
+			p.Actions[Class(strings.ToLower(strings.TrimSpace(class)))] = a
+		default:
+			return fmt.Errorf("redaction action %q of %s must be one of keep, mask, hash or drop", action, class)
+		}
+	}
+
+	return nil
+}
+
+// apply redacts the value, it reports false when the value must be dropped
+func (p *Policy) apply(class Class, v slog.Value) (slog.Value, bool) {
+	action, ok := p.Actions[class]
+	if !ok {
+		action = ActionMask
+	}
+
+	switch action {
+	case ActionKeep:
+		return v, true
+	case ActionDrop:
+		return slog.Value{}, false
+	case ActionHash:
+		return slog.StringValue(p.hash(v.String())), true
+	}
+
+	if class == ClassEmail {
+		return slog.StringValue(maskEmail(v.String())), true
+	}
+	return slog.StringValue(mask(v.String())), true
+}
+
+func (p *Policy) hash(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, p.HashKey)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	first, _ := utf8.DecodeRuneInString(s)
+	return string(first) + "***"
+}
+
+func maskEmail(s string) string {
+	local, domain, ok := strings.Cut(s, "@")
+	if !ok {
+		return mask(s)
+	}
+
+	return mask(local) + "@" + domain
+}
+
+// Tagged is a sensitive value of a class, the Handler redacts it according to its Policy
+type Tagged struct {
+	Class Class
+	Value any
+}
+
+// Tag classifies the value, e.g. slog.Any("email", redact.Tag(redact.ClassEmail, u.Email))
+func Tag(class Class, v any) slog.Value {
+	return slog.AnyValue(Tagged{Class: class, Value: v})
+}
+
+// LogValue masks the value for loggers without a Handler
+func (t Tagged) LogValue() slog.Value {
+	return slog.StringValue(Masked)
+}
@@ -0,0 +1,89 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type profile struct {
+	ID    string
+	Email string
+	Name  string
+}
+
+func (p profile) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("id", Tag(ClassID, p.ID)),
+		slog.Any("email", Tag(ClassEmail, p.Email)),
+		slog.Any("name", Tag(ClassName, p.Name)),
+		slog.String("country", "GB"),
+	)
+}
+
+func newTestLogger(policy *Policy) (*slog.Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	return slog.New(NewHandler(slog.NewJSONHandler(buf, nil), policy)), buf
+}
+
+func decodeLine(t *testing.T, buf *bytes.Buffer) map[string]any {
+	line := map[string]any{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	buf.Reset()
+
+	return line
+}
+
+func TestHandlerRedactsTaggedValues(t *testing.T) {
+	logger, buf := newTestLogger(nil)
+
+	logger.With("user", profile{ID: "1234", Email: "john@example.com", Name: "John"}).Info("msg")
+	user := decodeLine(t, buf)["user"].(map[string]any)
+
+	assert.Equal(t, "j***@example.com", user["email"])
+	assert.Equal(t, "J***", user["name"])
+	assert.Len(t, user["id"], 16)
+	assert.NotEqual(t, "1234", user["id"])
+	assert.Equal(t, "GB", user["country"])
+
+	logger.Info("msg", "user", profile{ID: "1234"})
+	assert.Equal(t, user["id"], decodeLine(t, buf)["user"].(map[string]any)["id"], "hashes correlate log lines")
+}
+
+func TestHandlerRedactsSensitiveKeys(t *testing.T) {
+	logger, buf := newTestLogger(nil)
+
+	logger.WithGroup("request").With("email", "jane@example.com").Info("msg", "password", "secret", "caller-id", "1234", "count", 2)
+	line := decodeLine(t, buf)["request"].(map[string]any)
+
+	assert.Equal(t, "j***@example.com", line["email"])
+	assert.NotContains(t, line, "password")
+	assert.NotEqual(t, "1234", line["caller-id"])
+	assert.Equal(t, float64(2), line["count"])
+}
+
+func TestPolicySet(t *testing.T) {
+	policy := DefaultPolicy()
+	assert.NoError(t, policy.Set("id=keep, email=drop,name=hash"))
+
+	logger, buf := newTestLogger(policy)
+	logger.Info("msg", "user", profile{ID: "1234", Email: "john@example.com", Name: "John"})
+	user := decodeLine(t, buf)["user"].(map[string]any)
+
+	assert.Equal(t, "1234", user["id"])
+	assert.NotContains(t, user, "email")
+	assert.Len(t, user["name"], 16)
+
+	assert.Error(t, policy.Set("email"))
+	assert.Error(t, policy.Set("email=shred"))
+}
+
+func TestTaggedWithoutHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	slog.New(slog.NewJSONHandler(buf, nil)).Info("msg", "email", Tag(ClassEmail, "john@example.com"))
+
+	assert.Equal(t, Masked, decodeLine(t, buf)["email"])
+}
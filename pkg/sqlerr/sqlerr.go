@@ -0,0 +1,28 @@
+// Package sqlerr recognises driver errors of the SQL backends, every driver reports them differently
+package sqlerr
+
+import (
+	"errors"
+
+	"github.com/glebarez/go-sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// pgUniqueViolation is the SQLSTATE of a unique constraint violation
+	pgUniqueViolation = "23505"
+	// sqliteConstraintUnique is the extended result code of a unique constraint violation
+	sqliteConstraintUnique = 2067
+)
+
+// IsPostgresUniqueViolation reports whether the pgx error is a unique constraint violation
+func IsPostgresUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// IsSQLiteUniqueViolation reports whether the sqlite error is a unique constraint violation
+func IsSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqliteConstraintUnique
+}
@@ -0,0 +1,40 @@
+package sqlerr
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPostgresUniqueViolation(t *testing.T) {
+	err := fmt.Errorf("insert err: %w", &pgconn.PgError{Code: pgUniqueViolation})
+	assert.True(t, IsPostgresUniqueViolation(err))
+	assert.False(t, IsPostgresUniqueViolation(&pgconn.PgError{Code: "23503"}))
+	assert.False(t, IsPostgresUniqueViolation(errors.New("unique")))
+	assert.False(t, IsPostgresUniqueViolation(nil))
+}
+
+func TestIsSQLiteUniqueViolation(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE t (id TEXT UNIQUE, parent TEXT NOT NULL)`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO t (id, parent) VALUES ('1', '1')`)
+	assert.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO t (id, parent) VALUES ('1', '1')`)
+	assert.True(t, IsSQLiteUniqueViolation(err))
+
+	_, err = db.Exec(`INSERT INTO t (id) VALUES ('2')`)
+	assert.Error(t, err)
+	assert.False(t, IsSQLiteUniqueViolation(err), "other constraint violations are not unique violations")
+	assert.False(t, IsSQLiteUniqueViolation(nil))
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextKey string
+
+const (
+	requestIDKey       contextKey = "request-id"
+	callerIDKey        contextKey = "caller-id"
+	callerIsAdminKey   contextKey = "caller-is-admin"
+	clientIPKey        contextKey = "client-ip"
+	expectedVersionKey contextKey = "expected-version"
+)
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// WithCallerID stores the ID of the authenticated user making the request
+func WithCallerID(ctx context.Context, callerID string) context.Context {
+	return context.WithValue(ctx, callerIDKey, callerID)
+}
+
+func CallerID(ctx context.Context) string {
+	callerID, _ := ctx.Value(callerIDKey).(string)
+	return callerID
+}
+
+// WithCallerIsAdmin records whether the caller stored by WithCallerID is an administrator
+func WithCallerIsAdmin(ctx context.Context, isAdmin bool) context.Context {
+	return context.WithValue(ctx, callerIsAdminKey, isAdmin)
+}
+
+func CallerIsAdmin(ctx context.Context) bool {
+	isAdmin, _ := ctx.Value(callerIsAdminKey).(bool)
+	return isAdmin
+}
+
+// WithClientIP stores the address of the client making the request
+func WithClientIP(ctx context.Context, clientIP string) context.Context {
+	return context.WithValue(ctx, clientIPKey, clientIP)
+}
+
+func ClientIP(ctx context.Context) string {
+	clientIP, _ := ctx.Value(clientIPKey).(string)
+	return clientIP
+}
+
+// WithExpectedVersion makes the writes of the request conditional on the stored version of the item,
+// e.g. the version of an If-Match header
+func WithExpectedVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, expectedVersionKey, version)
+}
+
+// ExpectedVersion returns the version writes are conditional on, if any
+func ExpectedVersion(ctx context.Context) (int64, bool) {
+	version, ok := ctx.Value(expectedVersionKey).(int64)
+	return version, ok
+}
+
+// ContextLogger annotates the logger with the request scoped values of the context
+func ContextLogger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if requestID := RequestID(ctx); requestID != "" {
+		logger = logger.With("request-id", requestID)
+	}
+	if callerID := CallerID(ctx); callerID != "" {
+		logger = logger.With("caller-id", callerID)
+	}
+
+	return logger
+}
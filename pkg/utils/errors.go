@@ -6,4 +6,9 @@ var (
 	ErrNotFound   = fmt.Errorf("could not find item")
 	AlreadyExists = fmt.Errorf("item already exists")
 	ValidationErr = fmt.Errorf("invalid value")
+
+	// VersionConflict is returned by conditional writes when the item was changed concurrently
+	VersionConflict = fmt.Errorf("item was modified concurrently")
+	// PreconditionFailed is returned when the item is not at the version the caller expected
+	PreconditionFailed = fmt.Errorf("item is not at the expected version")
 )
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes why the value of a single field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors collects every invalid field rather than only the first,
+// it matches ValidationErr with errors.Is
+type ValidationErrors []*FieldError
+
+// Add records an invalid field
+func (e *ValidationErrors) Add(field, format string, args ...any) {
+	*e = append(*e, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns nil when no field is invalid, so a nil ValidationErrors is never returned as a non-nil error
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func (e ValidationErrors) Error() string {
+	messages := []string{}
+	for _, field := range e {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+
+	return fmt.Sprintf("%s - %s", ValidationErr, strings.Join(messages, "; "))
+}
+
+func (e ValidationErrors) Unwrap() error {
+	return ValidationErr
+}